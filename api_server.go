@@ -1,30 +1,106 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// PIDManager handles PID list operations
+// PIDManager handles PID list operations.
+// It is shared by the API server, which records the PIDs users add, and the
+// controller, which records the children inherited by followed targets.
 type PIDManager struct {
-	pids []uint32
-	mu   sync.RWMutex
+	pids    []uint32
+	targets map[uint32]TargetPID
+	exited  []ExitedTarget // oldest first, at most maxExitedTargets
+	mu      sync.RWMutex
 }
 
 // NewPIDManager creates a new PID manager instance
 func NewPIDManager() *PIDManager {
 	return &PIDManager{
-		pids: make([]uint32, 0),
+		pids:    make([]uint32, 0),
+		targets: make(map[uint32]TargetPID),
 	}
 }
 
-// AddPIDs adds PIDs to the list
-func (pm *PIDManager) AddPIDs(newPIDs []uint32) {
+// AddPIDs adds PIDs to the list, ignoring those already present
+func (pm *PIDManager) AddPIDs(newPIDs []uint32, followChildren bool) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
-	pm.pids = append(pm.pids, newPIDs...)
+	for _, pid := range newPIDs {
+		if !containsPID(pm.pids, pid) {
+			pm.pids = append(pm.pids, pid)
+		}
+		pm.targets[pid] = TargetPID{PID: pid, FollowChildren: followChildren}
+	}
+}
+
+// AddInherited records a child added by the kernel because parent follows its children
+func (pm *PIDManager) AddInherited(child, parent uint32) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if containsPID(pm.pids, child) {
+		return
+	}
+	pm.pids = append(pm.pids, child)
+	pm.targets[child] = TargetPID{PID: child, FollowChildren: true, Inherited: true, ParentPID: parent}
+}
+
+// RemovePIDs removes PIDs from the list
+func (pm *PIDManager) RemovePIDs(pids []uint32) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	kept := pm.pids[:0]
+	for _, pid := range pm.pids {
+		if !containsPID(pids, pid) {
+			kept = append(kept, pid)
+		} else {
+			delete(pm.targets, pid)
+		}
+	}
+	pm.pids = kept
+}
+
+// MarkExited moves pid to the exited history; the kernel already dropped its entry.
+// PIDs unknown to the manager (e.g. added by a previous run) are recorded too.
+func (pm *PIDManager) MarkExited(pid uint32, comm string, exitedAt time.Time) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	target, ok := pm.targets[pid]
+	if !ok {
+		target = TargetPID{PID: pid}
+	}
+	kept := pm.pids[:0]
+	for _, p := range pm.pids {
+		if p != pid {
+			kept = append(kept, p)
+		}
+	}
+	pm.pids = kept
+	delete(pm.targets, pid)
+
+	if len(pm.exited) >= maxExitedTargets {
+		pm.exited = append(pm.exited[:0], pm.exited[1:]...)
+	}
+	pm.exited = append(pm.exited, ExitedTarget{TargetPID: target, Comm: comm, ExitedAt: exitedAt})
+}
+
+// GetExited returns the recently exited targets, newest first
+func (pm *PIDManager) GetExited() []ExitedTarget {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	exited := make([]ExitedTarget, 0, len(pm.exited))
+	for i := len(pm.exited) - 1; i >= 0; i-- {
+		exited = append(exited, pm.exited[i])
+	}
+	return exited
 }
 
 // ClearPIDList clears all PIDs
@@ -32,6 +108,7 @@ func (pm *PIDManager) ClearPIDList() {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	pm.pids = make([]uint32, 0)
+	pm.targets = make(map[uint32]TargetPID)
 }
 
 // GetAllPIDs returns all PIDs
@@ -41,28 +118,95 @@ func (pm *PIDManager) GetAllPIDs() []uint32 {
 	return append([]uint32{}, pm.pids...)
 }
 
+// GetTargets returns all PIDs with their follow/inherited marks, in insertion order
+func (pm *PIDManager) GetTargets() []TargetPID {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	targets := make([]TargetPID, 0, len(pm.pids))
+	for _, pid := range pm.pids {
+		targets = append(targets, pm.targets[pid])
+	}
+	return targets
+}
+
+func containsPID(pids []uint32, pid uint32) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
 // APIServer handles HTTP API requests
 type APIServer struct {
-	logger    Logger
-	pidManager *PIDManager
-	cmdCh      chan MonitorCommand
-	ebpfController        *EBpfController
-	router     *gin.Engine
-	port       string
+	logger         Logger
+	pidManager     *PIDManager
+	cmdCh          chan MonitorCommand
+	ebpfController *EBpfController
+	broadcaster    *EventBroadcaster
+	pipeline       *EventPipeline
+	ring           *RingSink
+	history        *EventHistory
+	journal        *EventJournal
+	recorder       *CaptureWriter
+	auth           *Authenticator
+	eventMetrics   *EventMetrics
+	router         *gin.Engine
+	addr           string
+
+	// queueRejected and commandTimeouts count commands the controller never answered
+	queueRejected   atomic.Uint64
+	commandTimeouts atomic.Uint64
+}
+
+// commandTimeout bounds how long a handler waits for the controller to apply a command
+const commandTimeout = 5 * time.Second
+
+var (
+	errCommandQueueFull = errors.New("command queue full")
+	errCommandTimeout   = errors.New("timed out waiting for the controller")
+)
+
+// CommandResult is the outcome of one command as reported by the API
+type CommandResult struct {
+	Command        string           `json:"command"`
+	PID            uint32           `json:"pid,omitempty"`
+	PIDs           []uint32         `json:"pids,omitempty"`
+	Comm           string           `json:"comm,omitempty"`
+	Comms          []string         `json:"comms,omitempty"`
+	Cgroup         *CgroupTarget    `json:"cgroup,omitempty"`
+	CgroupIDs      []uint64         `json:"cgroup_ids,omitempty"`
+	Syscall        string           `json:"syscall,omitempty"`
+	Removed        []uint32         `json:"removed,omitempty"`
+	RemovedComms   []string         `json:"removed_comms,omitempty"`
+	RemovedCgroups []uint64         `json:"removed_cgroups,omitempty"`
+	RateLimit      *RateLimitConfig `json:"rate_limit,omitempty"`
+	Success        bool             `json:"success"`
+	Error          string           `json:"error,omitempty"`
 }
 
 // NewAPIServer creates a new API server instance
-func NewAPIServer(port string, logger Logger, cmdCh chan MonitorCommand, ebpfController *EBpfController) *APIServer {
-	pidManager := NewPIDManager()
+func NewAPIServer(addr string, logger Logger, cmdCh chan MonitorCommand, ebpfController *EBpfController, pidManager *PIDManager, broadcaster *EventBroadcaster, pipeline *EventPipeline, ring *RingSink, history *EventHistory, journal *EventJournal, auth *Authenticator, eventMetrics *EventMetrics) *APIServer {
 	router := gin.Default()
+	// No proxy is trusted: X-Forwarded-For is ignored, so the client address logged
+	// and audited is that of the connection and cannot be spoofed
+	router.SetTrustedProxies(nil)
 
 	server := &APIServer{
-		logger:    logger,
-		pidManager: pidManager,
-		cmdCh:      cmdCh,
-		ebpfController:        ebpfController,
-		router:     router,
-		port:       port,
+		logger:         logger,
+		pidManager:     pidManager,
+		cmdCh:          cmdCh,
+		ebpfController: ebpfController,
+		broadcaster:    broadcaster,
+		pipeline:       pipeline,
+		ring:           ring,
+		history:        history,
+		journal:        journal,
+		auth:           auth,
+		eventMetrics:   eventMetrics,
+		router:         router,
+		addr:           addr,
 	}
 
 	server.setupRoutes()
@@ -71,6 +215,11 @@ func NewAPIServer(port string, logger Logger, cmdCh chan MonitorCommand, ebpfCon
 
 // setupRoutes configures all API routes
 func (as *APIServer) setupRoutes() {
+	// Every route requires a token when authentication is enabled: read for GET, admin otherwise
+	if as.auth != nil {
+		as.router.Use(as.auth.Middleware())
+	}
+
 	// GET - List all available APIs
 	as.router.GET("/apis", as.getAvailableAPIs)
 
@@ -83,8 +232,86 @@ func (as *APIServer) setupRoutes() {
 	// POST - Set print_all flag to true
 	as.router.POST("/set_print_all", as.setPrintAll)
 
+	// GET - Get the kernel rate limits and sampling, with the suppressed call counts
+	as.router.GET("/rate_limit", as.getRateLimit)
+
+	// POST - Replace the kernel rate limits and sampling
+	as.router.POST("/rate_limit", as.setRateLimit)
+
 	// GET - Get current target PIDs and print_all flag state
 	as.router.GET("/target_pids", as.getTargetPIDs)
+
+	// DELETE - Remove one PID from the target list
+	as.router.DELETE("/target_pids/:pid", as.removePID)
+
+	// DELETE - Remove several PIDs from the target list
+	as.router.DELETE("/target_pids", as.removePIDs)
+
+	// POST - Add task names (comm) to the target list and set print_all to false
+	as.router.POST("/target_comms", as.addComms)
+
+	// GET - Get current target task names
+	as.router.GET("/target_comms", as.getTargetComms)
+
+	// DELETE - Remove one task name from the target list
+	as.router.DELETE("/target_comms/:comm", as.removeComm)
+
+	// DELETE - Remove several task names from the target list
+	as.router.DELETE("/target_comms", as.removeComms)
+
+	// POST - Add cgroup v2 paths to the target list and set print_all to false
+	as.router.POST("/target_cgroups", as.addCgroups)
+
+	// GET - Get current target cgroups
+	as.router.GET("/target_cgroups", as.getTargetCgroups)
+
+	// DELETE - Remove one cgroup from the target list by ID
+	as.router.DELETE("/target_cgroups/:id", as.removeCgroup)
+
+	// DELETE - Remove several cgroups from the target list by path or ID
+	as.router.DELETE("/target_cgroups", as.removeCgroups)
+
+	// GET - Get excluded PIDs, task names and cgroups
+	as.router.GET("/exclusions", as.getExclusions)
+
+	// POST - Exclude PIDs, task names, cgroups or the default noisy daemons
+	as.router.POST("/exclusions", as.addExclusions)
+
+	// DELETE - Remove exclusions
+	as.router.DELETE("/exclusions", as.removeExclusions)
+
+	// GET - Get per-PID syscall counts and rates (optionally ?pid=)
+	as.router.GET("/stats", as.getStats)
+
+	// GET - Get per-PID syscall latency, returned bytes and errno breakdown (optionally ?pid=)
+	as.router.GET("/stats/exits", as.getExitStats)
+
+	// GET - Get event loss at each stage: kernel, reader, decoding, sinks
+	as.router.GET("/stats/loss", as.getLoss)
+
+	// GET - List traceable syscalls and which are enabled
+	as.router.GET("/syscalls", as.getSyscalls)
+
+	// POST - Enable/disable traced syscalls
+	as.router.POST("/syscalls", as.updateSyscalls)
+
+	// GET - Stream decoded events as Server-Sent Events (optionally ?pid=&type=&comm=)
+	as.router.GET("/events/stream", as.streamEvents)
+
+	// GET - Get the latest events kept by the in-memory ring sink (optionally ?limit=&pid=&type=&comm=)
+	as.router.GET("/events/recent", as.getRecentEvents)
+
+	// GET - Query the event history (optionally ?pid=&type=&comm=&since=&until=&limit=&cursor=)
+	as.router.GET("/events", as.getEvents)
+
+	// GET - Query the on-disk event journal (same parameters as GET /events)
+	as.router.GET("/journal", as.getJournal)
+
+	// GET - Get probe status (event transport and attach mechanism per syscall)
+	as.router.GET("/status", as.getStatus)
+
+	// GET - Prometheus metrics of the monitor and the observed processes
+	as.router.GET("/metrics", as.getMetrics)
 }
 
 // getAvailableAPIs returns all available API endpoints
@@ -92,26 +319,88 @@ func (as *APIServer) getAvailableAPIs(c *gin.Context) {
 	apis := map[string]interface{}{
 		"available_apis": []string{
 			"GET /apis - Get all available APIs",
-			"POST /add_pids - Add PIDs to target list (sets print_all to false)",
+			"POST /add_pids - Add PIDs to target list, optionally following their children (sets print_all to false)",
 			"POST /clear_pid_list - Clear all target PIDs (sets print_all to false)",
 			"POST /set_print_all - Set print_all flag to true (monitor all PIDs except own)",
+			"GET /rate_limit - Get the in-kernel rate limits and 1-in-N sampling, with suppressed call counts",
+			"POST /rate_limit - Replace the in-kernel rate limits and 1-in-N sampling ({} removes them)",
 			"GET /target_pids - Get current target PIDs and print_all flag state",
+			"DELETE /target_pids/:pid - Remove one PID from the target list",
+			"DELETE /target_pids - Remove several PIDs from the target list",
+			"POST /target_comms - Add task names to target list (sets print_all to false)",
+			"GET /target_comms - Get current target task names",
+			"DELETE /target_comms/:comm - Remove one task name from the target list",
+			"DELETE /target_comms - Remove several task names from the target list",
+			"POST /target_cgroups - Add cgroup v2 paths to target list, optionally with descendants (sets print_all to false)",
+			"GET /target_cgroups - Get current target cgroups",
+			"DELETE /target_cgroups/:id - Remove one cgroup from the target list by ID",
+			"DELETE /target_cgroups - Remove several cgroups from the target list by path or ID",
+			"GET /stats - Get per-PID syscall counts and rates (optional ?pid=1234)",
+			"GET /stats/exits - Get per-PID syscall latency, returned bytes and errno breakdown (optional ?pid=1234)",
+			"GET /stats/loss - Get events dropped by the kernel, lost by the reader, undecodable and dropped by each sink",
+			"GET /syscalls - List traceable syscalls with event type IDs and enabled state",
+			"POST /syscalls - Enable/disable traced syscalls",
+			"GET /events/stream - Stream events as Server-Sent Events (optional ?pid=1234&type=read&comm=nginx)",
+			"GET /events - Query the event history, oldest first (optional ?pid=1234&type=read&comm=nginx&since=1m&until=2024-01-01T00:00:00Z&limit=100&cursor=42)",
+			"GET /events/recent - Get the latest events kept by the ring sink (optional ?limit=100&pid=1234&type=read&comm=nginx)",
+			"GET /journal - Query the on-disk event journal, oldest first (same parameters as GET /events)",
+			"GET /status - Get probe status (event transport and attach mechanism per syscall)",
+			"GET /metrics - Prometheus metrics: events, lost samples, command queue, commands, targets, per-target syscalls",
 		},
 		"usage": map[string]interface{}{
 			"add_pids": map[string]interface{}{
 				"method": "POST",
-				"body":   `{"pids": [1234, 5678]}`,
+				"body":   `{"pids": [1234, 5678], "follow_children": false}`,
 			},
 			"clear_pid_list": map[string]interface{}{
 				"method": "POST",
 				"body":   `{} (optional - can be omitted)`,
 			},
+			"remove_pids": map[string]interface{}{
+				"method": "DELETE /target_pids",
+				"body":   `{"pids": [1234, 5678]}`,
+			},
+			"target_comms": map[string]interface{}{
+				"method": "POST",
+				"body":   `{"comms": ["nginx", "postgres"]}`,
+			},
+			"remove_comms": map[string]interface{}{
+				"method": "DELETE /target_comms",
+				"body":   `{"comms": ["nginx"]}`,
+			},
+			"target_cgroups": map[string]interface{}{
+				"method": "POST",
+				"body":   `{"cgroups": [{"path": "/system.slice/nginx.service", "descendants": true}]}`,
+			},
+			"remove_cgroups": map[string]interface{}{
+				"method": "DELETE /target_cgroups",
+				"body":   `{"paths": ["/system.slice/nginx.service"], "ids": [1234]}`,
+			},
+			"exclusions": map[string]interface{}{
+				"method": "POST",
+				"body":   `{"pids": [1234], "comms": ["dockerd"], "cgroups": [{"path": "/system.slice/fluent-bit.service"}], "noisy_daemons": true}`,
+			},
+			"remove_exclusions": map[string]interface{}{
+				"method": "DELETE /exclusions",
+				"body":   `{"pids": [1234], "comms": ["dockerd"], "cgroup_paths": ["/system.slice/fluent-bit.service"], "cgroup_ids": [5678], "noisy_daemons": true}`,
+			},
+			"rate_limit": map[string]interface{}{
+				"method": "POST",
+				"body":   `{"global_rate": 5000, "global_burst": 10000, "pid_rate": 500, "pid_burst": 1000, "sample_every": 10}`,
+			},
 			"set_print_all": map[string]interface{}{
 				"method": "POST",
 				"body":   `{} (optional - can be omitted)`,
 			},
+			"syscalls": map[string]interface{}{
+				"method": "POST",
+				"body":   `{"enable": ["openat", "connect"], "disable": ["write"]}`,
+			},
 		},
 	}
+	if as.auth != nil {
+		apis["authentication"] = "Authorization: Bearer <token>; the read role may call GET endpoints, the admin role every endpoint"
+	}
 
 	c.JSON(http.StatusOK, apis)
 }
@@ -119,11 +408,12 @@ func (as *APIServer) getAvailableAPIs(c *gin.Context) {
 // addPIDs handles adding PIDs to the target list
 func (as *APIServer) addPIDs(c *gin.Context) {
 	var request struct {
-		PIDs []uint32 `json:"pids"`
+		PIDs           []uint32 `json:"pids"`
+		FollowChildren bool     `json:"follow_children"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"pids\": [1234, 5678]}"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"pids\": [1234, 5678], \"follow_children\": false}"})
 		return
 	}
 	if len(request.PIDs) == 0 {
@@ -131,23 +421,36 @@ func (as *APIServer) addPIDs(c *gin.Context) {
 		return
 	}
 
-	as.logger.Infof("Received request: POST /add_pids {pids: %v}", request.PIDs)
+	as.logger.Infof("Received request: POST /add_pids {pids: %v, follow_children: %v}", request.PIDs, request.FollowChildren)
 
-	// Add PIDs to local manager
-	as.pidManager.AddPIDs(request.PIDs)
-
-	// Enqueue each PID
+	cmds := make([]MonitorCommand, 0, len(request.PIDs))
 	for _, pid := range request.PIDs {
-		select {
-		case as.cmdCh <- MonitorCommand{Kind: CommandAddPID, PID: pid}:
-		default:
-			as.logger.Warnf("command queue full, dropping AddPID(%d)", pid)
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddPID, PID: pid, FollowChildren: request.FollowChildren})
+	}
+	results, status := as.runCommands(cmds)
+
+	// The controller tracks the PIDs the probe accepted in the PIDManager
+	added := make([]uint32, 0, len(results))
+	for _, r := range results {
+		if r.Success {
+			added = append(added, r.PID)
 		}
 	}
 
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":      "Some PIDs could not be added",
+			"results":    results,
+			"added_pids": added,
+			"total_pids": len(as.pidManager.GetAllPIDs()),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "PIDs enqueued for add; print_all set to false",
-		"added_pids": request.PIDs,
+		"message": "PIDs added; print_all set to false",
+		"results": results,
+		"added_pids": added,
 		"total_pids": len(as.pidManager.GetAllPIDs()),
 		"print_all": false,
 	})
@@ -157,18 +460,18 @@ func (as *APIServer) addPIDs(c *gin.Context) {
 func (as *APIServer) clearPIDList(c *gin.Context) {
 	as.logger.Infof("Received request: POST /clear_pid_list")
 
-	// Clear PIDs from local manager
-	as.pidManager.ClearPIDList()
-
-	// Enqueue clear
-	select {
-	case as.cmdCh <- MonitorCommand{Kind: CommandClearPIDs}:
-	default:
-		as.logger.Warnf("command queue full, dropping ClearPIDs")
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandClearPIDs}})
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Failed to clear PID list",
+			"results": results,
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "PID list enqueued for clear; print_all set to false",
+		"message": "PID list cleared; print_all set to false",
+		"results": results,
 		"total_pids": 0,
 		"print_all": false,
 	})
@@ -178,46 +481,1233 @@ func (as *APIServer) clearPIDList(c *gin.Context) {
 func (as *APIServer) setPrintAll(c *gin.Context) {
 	as.logger.Infof("Received request: POST /set_print_all")
 
-	// Enqueue set_print_all
-	select {
-	case as.cmdCh <- MonitorCommand{Kind: CommandSetPrintAll, PrintAll: true}:
-	default:
-		as.logger.Warnf("command queue full, dropping SetPrintAll(true)")
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandSetPrintAll, PrintAll: true}})
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Failed to set print_all flag",
+			"results": results,
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Print all flag enqueued to true successfully",
+		"message": "Print all flag set to true successfully",
+		"results": results,
 		"print_all": true,
 	})
 }
 
-// getTargetPIDs returns current target PIDs and print_all flag state
-func (as *APIServer) getTargetPIDs(c *gin.Context) {
-	// Use the ebpfController (which queries EBpfProbe)
-	pids, err := as.ebpfController.GetTargetPIDs()
+// getRateLimit returns the limits in effect and how many matching calls they suppressed
+func (as *APIServer) getRateLimit(c *gin.Context) {
+	limit, err := as.ebpfController.GetRateLimit()
 	if err != nil {
-		as.logger.Warnf("Failed to get target PIDs: %v", err)
-		pids = []uint32{}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rate limit: " + err.Error()})
+		return
+	}
+	suppressed, err := as.ebpfController.GetSuppressedCounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get suppressed counts: " + err.Error()})
+		return
 	}
 
-	printAll, err := as.ebpfController.GetPrintAllState()
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Rate limit retrieved successfully",
+		"rate_limit":       limit,
+		"enabled":          limit.Enabled(),
+		"suppressed":       suppressed,
+		"suppressed_total": suppressed.Total(),
+	})
+}
+
+// setRateLimit replaces the limits; omitted fields are unlimited, so {} removes every limit
+func (as *APIServer) setRateLimit(c *gin.Context) {
+	var request RateLimitConfig
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"global_rate\": 5000, \"pid_rate\": 500, \"sample_every\": 10}"})
+		return
+	}
+	if err := request.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := request.Normalize()
+
+	as.logger.Infof("Received request: POST /rate_limit %+v", limit)
+
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandSetRateLimit, RateLimit: limit}})
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Failed to set rate limit",
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Rate limit set successfully",
+		"results":    results,
+		"rate_limit": limit,
+		"enabled":    limit.Enabled(),
+	})
+}
+
+// removePID handles removing a single PID; 404 if it was not a target
+func (as *APIServer) removePID(c *gin.Context) {
+	parsed, err := strconv.ParseUint(c.Param("pid"), 10, 32)
+	if err != nil || parsed == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path parameter 'pid' must be a positive integer"})
+		return
+	}
+	pid := uint32(parsed)
+
+	as.logger.Infof("Received request: DELETE /target_pids/%d", pid)
+
+	removed, results, ok := as.removeTargets(c, []uint32{pid})
+	if !ok {
+		return
+	}
+	if len(removed) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "PID is not in the target list",
+			"pid":     pid,
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "PID removed from the target list",
+		"pid": pid,
+		"results": results,
+		"total_pids": len(as.pidManager.GetAllPIDs()),
+	})
+}
+
+// removePIDs handles removing several PIDs in one command
+func (as *APIServer) removePIDs(c *gin.Context) {
+	var request struct {
+		PIDs []uint32 `json:"pids"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"pids\": [1234, 5678]}"})
+		return
+	}
+	if len(request.PIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Field 'pids' must contain at least one PID"})
+		return
+	}
+
+	as.logger.Infof("Received request: DELETE /target_pids {pids: %v}", request.PIDs)
+
+	removed, results, ok := as.removeTargets(c, request.PIDs)
+	if !ok {
+		return
+	}
+
+	notPresent := make([]uint32, 0, len(request.PIDs))
+	for _, pid := range request.PIDs {
+		if !containsPID(removed, pid) {
+			notPresent = append(notPresent, pid)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "PIDs removed from the target list",
+		"removed_pids": removed,
+		"not_present_pids": notPresent,
+		"results": results,
+		"total_pids": len(as.pidManager.GetAllPIDs()),
+	})
+}
+
+// removeTargets runs a CommandRemovePID, which also drops the PIDs from the PIDManager.
+// It returns the PIDs that were present; on failure it has already written the response.
+func (as *APIServer) removeTargets(c *gin.Context, pids []uint32) ([]uint32, []CommandResult, bool) {
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandRemovePID, PIDs: pids}})
+	removed := results[0].Removed
+	if removed == nil {
+		removed = []uint32{}
+	}
+
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":        "Failed to remove PIDs",
+			"removed_pids": removed,
+			"results":      results,
+		})
+		return nil, nil, false
+	}
+	return removed, results, true
+}
+
+// addComms handles adding task names to the target list
+func (as *APIServer) addComms(c *gin.Context) {
+	var request struct {
+		Comms []string `json:"comms"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"comms\": [\"nginx\", \"postgres\"]}"})
+		return
+	}
+	if len(request.Comms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Field 'comms' must contain at least one task name"})
+		return
+	}
+	for _, comm := range request.Comms {
+		if _, err := newCommKey(comm); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comm: " + err.Error()})
+			return
+		}
+	}
+
+	as.logger.Infof("Received request: POST /target_comms {comms: %v}", request.Comms)
+
+	cmds := make([]MonitorCommand, 0, len(request.Comms))
+	for _, comm := range request.Comms {
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddComm, Comm: comm})
+	}
+	results, status := as.runCommands(cmds)
+
+	added := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Success {
+			added = append(added, r.Comm)
+		}
+	}
+
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":       "Some task names could not be added",
+			"results":     results,
+			"added_comms": added,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Task names added; print_all set to false",
+		"results":     results,
+		"added_comms": added,
+		"print_all":   false,
+	})
+}
+
+// getTargetComms returns current target task names
+func (as *APIServer) getTargetComms(c *gin.Context) {
+	comms, err := as.ebpfController.GetTargetComms()
 	if err != nil {
-		as.logger.Warnf("Failed to get print_all state: %v", err)
-		printAll = false
+		as.logger.Errorf("Failed to get target comms: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read target comms"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Target PIDs and print_all flag state retrieved successfully",
-		"pids": pids,
-		"total_pids": len(pids),
-		"print_all": printAll,
+		"message":     "Target task names retrieved successfully",
+		"comms":       comms,
+		"total_comms": len(comms),
+	})
+}
+
+// removeComm handles removing a single task name; 404 if it was not a target.
+// Names containing '/' must go through the bulk endpoint.
+func (as *APIServer) removeComm(c *gin.Context) {
+	comm := c.Param("comm")
+	if _, err := newCommKey(comm); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comm: " + err.Error()})
+		return
+	}
+	as.logger.Infof("Received request: DELETE /target_comms/%s", comm)
+
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandRemoveComm, Comms: []string{comm}}})
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Failed to remove task name",
+			"results": results,
+		})
+		return
+	}
+	if len(results[0].RemovedComms) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Task name is not in the target list",
+			"comm":    comm,
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Task name removed from the target list",
+		"comm":    comm,
+		"results": results,
+	})
+}
+
+// removeComms handles removing several task names in one command
+func (as *APIServer) removeComms(c *gin.Context) {
+	var request struct {
+		Comms []string `json:"comms"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"comms\": [\"nginx\"]}"})
+		return
+	}
+	if len(request.Comms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Field 'comms' must contain at least one task name"})
+		return
+	}
+	for _, comm := range request.Comms {
+		if _, err := newCommKey(comm); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comm: " + err.Error()})
+			return
+		}
+	}
+
+	as.logger.Infof("Received request: DELETE /target_comms {comms: %v}", request.Comms)
+
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandRemoveComm, Comms: request.Comms}})
+	removed := results[0].RemovedComms
+	if removed == nil {
+		removed = []string{}
+	}
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":         "Failed to remove task names",
+			"removed_comms": removed,
+			"results":       results,
+		})
+		return
+	}
+
+	notPresent := make([]string, 0, len(request.Comms))
+	for _, comm := range request.Comms {
+		found := false
+		for _, r := range removed {
+			if r == comm {
+				found = true
+				break
+			}
+		}
+		if !found {
+			notPresent = append(notPresent, comm)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Task names removed from the target list",
+		"removed_comms":     removed,
+		"not_present_comms": notPresent,
+		"results":           results,
 	})
 }
 
+// addCgroups handles adding cgroup v2 targets; paths are resolved to cgroup IDs here
+func (as *APIServer) addCgroups(c *gin.Context) {
+	var request struct {
+		Cgroups []struct {
+			Path        string `json:"path"`
+			Descendants bool   `json:"descendants"`
+		} `json:"cgroups"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"cgroups\": [{\"path\": \"/system.slice/nginx.service\", \"descendants\": true}]}"})
+		return
+	}
+	if len(request.Cgroups) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Field 'cgroups' must contain at least one cgroup"})
+		return
+	}
+
+	as.logger.Infof("Received request: POST /target_cgroups {cgroups: %+v}", request.Cgroups)
+
+	cmds := make([]MonitorCommand, 0, len(request.Cgroups))
+	for _, cg := range request.Cgroups {
+		path, id, err := resolveCgroup(cg.Path)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cgroup: " + err.Error()})
+			return
+		}
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddCgroup, Cgroup: CgroupTarget{ID: id, Path: path, Descendants: cg.Descendants}})
+	}
+	results, status := as.runCommands(cmds)
+
+	added := make([]CgroupTarget, 0, len(results))
+	for _, r := range results {
+		if r.Success {
+			added = append(added, *r.Cgroup)
+		}
+	}
+
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":         "Some cgroups could not be added",
+			"results":       results,
+			"added_cgroups": added,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Cgroups added; print_all set to false",
+		"results":       results,
+		"added_cgroups": added,
+		"print_all":     false,
+	})
+}
+
+// getTargetCgroups returns current target cgroups
+func (as *APIServer) getTargetCgroups(c *gin.Context) {
+	cgroups, err := as.ebpfController.GetTargetCgroups()
+	if err != nil {
+		as.logger.Errorf("Failed to get target cgroups: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read target cgroups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Target cgroups retrieved successfully",
+		"cgroups":       cgroups,
+		"total_cgroups": len(cgroups),
+	})
+}
+
+// removeCgroup handles removing a single cgroup by ID; 404 if it was not a target
+func (as *APIServer) removeCgroup(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path parameter 'id' must be a positive integer"})
+		return
+	}
+
+	as.logger.Infof("Received request: DELETE /target_cgroups/%d", id)
+
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandRemoveCgroup, CgroupIDs: []uint64{id}}})
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Failed to remove cgroup",
+			"results": results,
+		})
+		return
+	}
+	if len(results[0].RemovedCgroups) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Cgroup is not in the target list",
+			"id":      id,
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cgroup removed from the target list",
+		"id":      id,
+		"results": results,
+	})
+}
+
+// removeCgroups handles removing several cgroups in one command.
+// Paths are matched against the targets first, so cgroups already deleted can still be removed.
+func (as *APIServer) removeCgroups(c *gin.Context) {
+	var request struct {
+		Paths []string `json:"paths"`
+		IDs   []uint64 `json:"ids"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"paths\": [\"/system.slice/nginx.service\"], \"ids\": [1234]}"})
+		return
+	}
+	if len(request.Paths) == 0 && len(request.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Fields 'paths' and 'ids' cannot both be empty"})
+		return
+	}
+
+	as.logger.Infof("Received request: DELETE /target_cgroups {paths: %v, ids: %v}", request.Paths, request.IDs)
+
+	ids := append([]uint64{}, request.IDs...)
+	for _, p := range request.Paths {
+		if id, ok := as.ebpfController.CgroupIDForPath(p); ok {
+			ids = append(ids, id)
+			continue
+		}
+		_, id, err := resolveCgroup(p)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cgroup: " + err.Error()})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandRemoveCgroup, CgroupIDs: ids}})
+	removed := results[0].RemovedCgroups
+	if removed == nil {
+		removed = []uint64{}
+	}
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":           "Failed to remove cgroups",
+			"removed_cgroups": removed,
+			"results":         results,
+		})
+		return
+	}
+
+	notPresent := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		found := false
+		for _, r := range removed {
+			if r == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			notPresent = append(notPresent, id)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Cgroups removed from the target list",
+		"removed_cgroups":     removed,
+		"not_present_cgroups": notPresent,
+		"results":             results,
+	})
+}
+
+// getExclusions returns the excluded PIDs, task names and cgroups
+func (as *APIServer) getExclusions(c *gin.Context) {
+	exclusions, err := as.ebpfController.GetExclusions()
+	if err != nil {
+		as.logger.Errorf("Failed to get exclusions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read exclusions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Exclusions retrieved successfully",
+		"exclusions":    exclusions,
+		"noisy_daemons": defaultNoisyComms,
+	})
+}
+
+// addExclusions handles excluding processes from reporting, in both modes
+func (as *APIServer) addExclusions(c *gin.Context) {
+	var request ExclusionConfig
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"pids\": [1234], \"comms\": [\"dockerd\"], \"cgroups\": [{\"path\": \"/system.slice/fluent-bit.service\", \"descendants\": true}], \"noisy_daemons\": true}"})
+		return
+	}
+	if !request.NoisyDaemons && len(request.PIDs) == 0 && len(request.Comms) == 0 && len(request.Cgroups) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to exclude: set 'pids', 'comms', 'cgroups' or 'noisy_daemons'"})
+		return
+	}
+
+	as.logger.Infof("Received request: POST /exclusions %+v", request)
+
+	cmds, err := exclusionCommands(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exclusion: " + err.Error()})
+		return
+	}
+	results, status := as.runCommands(cmds)
+
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Some exclusions could not be added",
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Exclusions added",
+		"results": results,
+	})
+}
+
+// removeExclusions handles removing exclusions; cgroups are given by path or ID.
+// The monitor's own PID cannot be removed.
+func (as *APIServer) removeExclusions(c *gin.Context) {
+	var request struct {
+		PIDs         []uint32 `json:"pids"`
+		Comms        []string `json:"comms"`
+		CgroupPaths  []string `json:"cgroup_paths"`
+		CgroupIDs    []uint64 `json:"cgroup_ids"`
+		NoisyDaemons bool     `json:"noisy_daemons"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"pids\": [1234], \"comms\": [\"dockerd\"], \"cgroup_paths\": [\"/system.slice/fluent-bit.service\"], \"cgroup_ids\": [5678], \"noisy_daemons\": true}"})
+		return
+	}
+	if !request.NoisyDaemons && len(request.PIDs) == 0 && len(request.Comms) == 0 && len(request.CgroupPaths) == 0 && len(request.CgroupIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to remove: set 'pids', 'comms', 'cgroup_paths', 'cgroup_ids' or 'noisy_daemons'"})
+		return
+	}
+	if containsPID(request.PIDs, selfPID()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errExcludeSelf.Error()})
+		return
+	}
+
+	as.logger.Infof("Received request: DELETE /exclusions %+v", request)
+
+	comms := request.Comms
+	if request.NoisyDaemons {
+		comms = append(append([]string{}, defaultNoisyComms...), comms...)
+	}
+	ids := append([]uint64{}, request.CgroupIDs...)
+	for _, p := range request.CgroupPaths {
+		if id, ok := as.ebpfController.ExcludedCgroupIDForPath(p); ok {
+			ids = append(ids, id)
+			continue
+		}
+		_, id, err := resolveCgroup(p)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cgroup: " + err.Error()})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	results, status := as.runCommands([]MonitorCommand{{Kind: CommandRemoveExclusions, PIDs: request.PIDs, Comms: comms, CgroupIDs: ids}})
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Failed to remove exclusions",
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Exclusions removed",
+		"removed_pids":    results[0].Removed,
+		"removed_comms":   results[0].RemovedComms,
+		"removed_cgroups": results[0].RemovedCgroups,
+		"results":         results,
+	})
+}
+
+// submitCommand enqueues cmd without blocking and waits for the controller's outcome
+func (as *APIServer) submitCommand(cmd MonitorCommand) CommandOutcome {
+	cmd.Result = make(chan CommandOutcome, 1)
+	select {
+	case as.cmdCh <- cmd:
+	default:
+		as.queueRejected.Add(1)
+		as.logger.Warnf("command queue full, rejecting %s", cmd.Kind)
+		return CommandOutcome{Err: errCommandQueueFull}
+	}
+
+	timer := time.NewTimer(commandTimeout)
+	defer timer.Stop()
+	select {
+	case outcome := <-cmd.Result:
+		return outcome
+	case <-timer.C:
+		as.commandTimeouts.Add(1)
+		as.logger.Warnf("Timed out after %v waiting for %s", commandTimeout, cmd.Kind)
+		return CommandOutcome{Err: errCommandTimeout}
+	}
+}
+
+// runCommands applies cmds in order and returns their results with the HTTP status to reply:
+// 503 if the queue was full, 504 if the controller did not answer in time,
+// 500 if the probe rejected a command, 200 otherwise.
+// Once the queue is full the remaining commands are not submitted.
+func (as *APIServer) runCommands(cmds []MonitorCommand) ([]CommandResult, int) {
+	results := make([]CommandResult, 0, len(cmds))
+	var queueFull, timedOut, failed bool
+
+	for _, cmd := range cmds {
+		outcome := CommandOutcome{Err: errCommandQueueFull}
+		if !queueFull {
+			outcome = as.submitCommand(cmd)
+		}
+		err := outcome.Err
+
+		result := CommandResult{
+			Command:        cmd.Kind.String(),
+			PID:            cmd.PID,
+			PIDs:           cmd.PIDs,
+			Comm:           cmd.Comm,
+			Comms:          cmd.Comms,
+			CgroupIDs:      cmd.CgroupIDs,
+			Syscall:        cmd.Syscall,
+			Removed:        outcome.Removed,
+			RemovedComms:   outcome.RemovedComms,
+			RemovedCgroups: outcome.RemovedCgroups,
+			Success:        err == nil,
+		}
+		if cmd.Kind == CommandAddCgroup || cmd.Kind == CommandExcludeCgroup {
+			cgroup := cmd.Cgroup
+			result.Cgroup = &cgroup
+		}
+		if cmd.Kind == CommandSetRateLimit {
+			limit := cmd.RateLimit
+			result.RateLimit = &limit
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, errCommandQueueFull):
+			queueFull = true
+		case errors.Is(err, errCommandTimeout):
+			timedOut = true
+		default:
+			failed = true
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	switch {
+	case queueFull:
+		return results, http.StatusServiceUnavailable
+	case timedOut:
+		return results, http.StatusGatewayTimeout
+	case failed:
+		return results, http.StatusInternalServerError
+	}
+	return results, http.StatusOK
+}
+
+// getTargetPIDs returns current target PIDs and print_all flag state
+func (as *APIServer) getTargetPIDs(c *gin.Context) {
+	// Use the ebpfController (which queries EBpfProbe)
+	targets, err := as.ebpfController.GetTargets()
+	if err != nil {
+		as.logger.Warnf("Failed to get target PIDs: %v", err)
+		targets = []TargetPID{}
+	}
+	pids := make([]uint32, 0, len(targets))
+	for _, target := range targets {
+		pids = append(pids, target.PID)
+	}
+
+	printAll, err := as.ebpfController.GetPrintAllState()
+	if err != nil {
+		as.logger.Warnf("Failed to get print_all state: %v", err)
+		printAll = false
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Target PIDs and print_all flag state retrieved successfully",
+		"pids": pids,
+		"targets": targets,
+		"total_pids": len(pids),
+		"print_all": printAll,
+		"exited": as.pidManager.GetExited(),
+	})
+}
+
+// queryPID parses the optional ?pid= filter; 0 means no filter.
+// On error it has already written a 400 response.
+func (as *APIServer) queryPID(c *gin.Context) (uint32, bool) {
+	pidParam := c.Query("pid")
+	if pidParam == "" {
+		return 0, true
+	}
+	parsed, err := strconv.ParseUint(pidParam, 10, 32)
+	if err != nil || parsed == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'pid' must be a positive integer"})
+		return 0, false
+	}
+	return uint32(parsed), true
+}
+
+// getStats returns the in-kernel syscall counters per PID
+func (as *APIServer) getStats(c *gin.Context) {
+	pid, ok := as.queryPID(c)
+	if !ok {
+		return
+	}
+
+	stats, err := as.ebpfController.GetStats(pid)
+	if err != nil {
+		as.logger.Errorf("Failed to get stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read syscall counters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Syscall stats retrieved successfully",
+		"stats":      stats,
+		"total_pids": len(stats),
+	})
+}
+
+// getExitStats returns completed-syscall aggregates per PID
+func (as *APIServer) getExitStats(c *gin.Context) {
+	pid, ok := as.queryPID(c)
+	if !ok {
+		return
+	}
+
+	stats, err := as.ebpfController.GetExitStats(pid)
+	if err != nil {
+		as.logger.Errorf("Failed to get exit stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read syscall exit counters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Syscall exit stats retrieved successfully",
+		"stats":      stats,
+		"total_pids": len(stats),
+	})
+}
+
+// getSyscalls returns the syscall registry and which entries are traced
+func (as *APIServer) getSyscalls(c *gin.Context) {
+	syscalls := as.ebpfController.GetSyscalls()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Syscalls retrieved successfully",
+		"syscalls": syscalls,
+	})
+}
+
+// updateSyscalls enables/disables traced syscalls through the controller
+func (as *APIServer) updateSyscalls(c *gin.Context) {
+	var request struct {
+		Enable  []string `json:"enable"`
+		Disable []string `json:"disable"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format. Expected: {\"enable\": [\"openat\"], \"disable\": [\"write\"]}"})
+		return
+	}
+	if len(request.Enable) == 0 && len(request.Disable) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Fields 'enable' and 'disable' cannot both be empty"})
+		return
+	}
+	for _, name := range append(append([]string{}, request.Enable...), request.Disable...) {
+		if _, ok := lookupSyscall(name); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown syscall: " + name})
+			return
+		}
+	}
+
+	as.logger.Infof("Received request: POST /syscalls {enable: %v, disable: %v}", request.Enable, request.Disable)
+
+	cmds := make([]MonitorCommand, 0, len(request.Enable)+len(request.Disable))
+	for _, name := range request.Enable {
+		cmds = append(cmds, MonitorCommand{Kind: CommandEnableSyscall, Syscall: name})
+	}
+	for _, name := range request.Disable {
+		cmds = append(cmds, MonitorCommand{Kind: CommandDisableSyscall, Syscall: name})
+	}
+	results, status := as.runCommands(cmds)
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":   "Some syscall changes failed",
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Syscall changes applied",
+		"results": results,
+		"enable":  request.Enable,
+		"disable": request.Disable,
+	})
+}
+
+// queryEventFilter parses the optional ?pid=, ?type= (name or ID) and ?comm= filters.
+// On error it has already written a 400 response.
+func (as *APIServer) queryEventFilter(c *gin.Context) (EventFilter, bool) {
+	pid, ok := as.queryPID(c)
+	if !ok {
+		return EventFilter{}, false
+	}
+	filter := EventFilter{PID: pid, Comm: c.Query("comm")}
+
+	if typeParam := c.Query("type"); typeParam != "" {
+		eventType, ok := parseEventType(typeParam)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'type' must be a syscall name, process event name or event type ID"})
+			return EventFilter{}, false
+		}
+		filter.EventType = eventType
+	}
+	return filter, true
+}
+
+// streamEvents sends matching events as Server-Sent Events until the client goes away
+// or falls too far behind, in which case a final "dropped" event is sent
+func (as *APIServer) streamEvents(c *gin.Context) {
+	filter, ok := as.queryEventFilter(c)
+	if !ok {
+		return
+	}
+
+	sub := as.broadcaster.Subscribe(filter)
+	defer as.broadcaster.Unsubscribe(sub)
+	as.logger.Infof("Stream client connected: %s (filter %+v)", c.ClientIP(), filter)
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, open := <-sub.Events():
+			if !open {
+				as.logger.Warnf("Stream client %s dropped: too slow", c.ClientIP())
+				c.SSEvent("dropped", gin.H{"reason": "client too slow, buffer full"})
+				return false
+			}
+			c.SSEvent("event", event)
+			return true
+		case <-keepalive.C:
+			c.SSEvent("ping", gin.H{})
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+	as.logger.Infof("Stream client disconnected: %s", c.ClientIP())
+}
+
+// getRecentEvents returns the newest matching events held by the ring sink, oldest first
+func (as *APIServer) getRecentEvents(c *gin.Context) {
+	if as.ring == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ring sink is not configured"})
+		return
+	}
+	filter, ok := as.queryEventFilter(c)
+	if !ok {
+		return
+	}
+	limit := 100
+	if limitParam := c.Query("limit"); limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'limit' must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	var events []Data
+	for _, event := range as.ring.Recent(0) {
+		if filter.Matches(event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	if events == nil {
+		events = []Data{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Recent events retrieved successfully",
+		"events":  events,
+		"count":   len(events),
+	})
+}
+
+// getEvents queries the event history. since and until take an RFC 3339 time or a
+// duration before now (since=1m is the last minute). Pages are followed with
+// cursor=next_cursor; gap is true when events the query covers were evicted.
+func (as *APIServer) getEvents(c *gin.Context) {
+	if as.history == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event history is disabled (history.capacity is 0)"})
+		return
+	}
+	query, ok := as.queryHistory(c)
+	if !ok {
+		return
+	}
+
+	page := as.history.Query(query)
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Events retrieved successfully",
+		"events":      page.Events,
+		"count":       len(page.Events),
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
+		"gap":         page.Gap,
+		"history":     as.history.Stats(),
+	})
+}
+
+// getJournal queries the on-disk journal with the parameters of GET /events;
+// gap is true when segments the query covers were removed by retention
+func (as *APIServer) getJournal(c *gin.Context) {
+	if as.journal == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event journal is disabled (journal.dir is empty)"})
+		return
+	}
+	query, ok := as.queryHistory(c)
+	if !ok {
+		return
+	}
+
+	page, err := as.journal.Query(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query the journal: " + err.Error()})
+		return
+	}
+	stats, _ := as.journal.Stats()
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Journal events retrieved successfully",
+		"events":      page.Events,
+		"count":       len(page.Events),
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
+		"gap":         page.Gap,
+		"journal":     stats,
+	})
+}
+
+// queryHistory parses the filters, ?since=, ?until=, ?limit= and ?cursor= of an event query.
+// On error it has already written a 400 response.
+func (as *APIServer) queryHistory(c *gin.Context) (HistoryQuery, bool) {
+	filter, ok := as.queryEventFilter(c)
+	if !ok {
+		return HistoryQuery{}, false
+	}
+	query := HistoryQuery{Filter: filter, Limit: 100}
+
+	now := time.Now()
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		param := c.Query(p.name)
+		if param == "" {
+			continue
+		}
+		t, ok := parseTimeBound(param, now)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter '" + p.name + "' must be an RFC 3339 time or a duration such as 5m"})
+			return HistoryQuery{}, false
+		}
+		*p.dst = t
+	}
+	if limitParam := c.Query("limit"); limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n <= 0 || n > maxHistoryPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'limit' must be an integer between 1 and " + strconv.Itoa(maxHistoryPage)})
+			return HistoryQuery{}, false
+		}
+		query.Limit = n
+	}
+	if cursorParam := c.Query("cursor"); cursorParam != "" {
+		cursor, err := strconv.ParseUint(cursorParam, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'cursor' must be a next_cursor value"})
+			return HistoryQuery{}, false
+		}
+		query.Cursor = cursor
+	}
+	return query, true
+}
+
+// getStatus returns how the probe is wired to the kernel
+func (as *APIServer) getStatus(c *gin.Context) {
+	info := as.ebpfController.GetProbeInfo()
+	// Userspace counters are valid even if the kernel counter cannot be read
+	reader, _ := as.ebpfController.ReaderStats()
+
+	status := gin.H{
+		"message":     "Probe status retrieved successfully",
+		"transport":   info.Transport,
+		"attachments": info.Attachments,
+		"pin_path":    info.PinPath,
+		"stream":      as.broadcaster.Stats(),
+		"sinks":       as.pipeline.Stats(),
+		"reader":      reader,
+	}
+	if as.history != nil {
+		status["history"] = as.history.Stats()
+	}
+	if as.journal != nil {
+		if stats, err := as.journal.Stats(); err == nil {
+			status["journal"] = stats
+		}
+	}
+	if as.auth != nil {
+		status["auth"] = as.auth.Stats()
+	}
+	if as.recorder != nil {
+		status["recording"] = gin.H{"path": as.recorder.path, "records": as.recorder.Records()}
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// getLoss accounts for events at each stage, from the kernel to the sinks.
+// delivered_ratio is the share of the events emitted by the kernel that reached the
+// event handler; a sink's written_ratio is the share of delivered events it wrote.
+func (as *APIServer) getLoss(c *gin.Context) {
+	reader, err := as.ebpfController.ReaderStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read loss counters: " + err.Error()})
+		return
+	}
+
+	// Suppressed calls are intentional and not part of the loss; they are reported for context
+	suppressed, err := as.ebpfController.GetSuppressedCounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read suppressed counts: " + err.Error()})
+		return
+	}
+
+	// Perf lost samples are left out: the kernel counter already has them
+	emitted := reader.KernelDropped + reader.DecodeErrors + reader.Delivered
+	sinks := make([]gin.H, 0)
+	for _, st := range as.pipeline.Stats() {
+		sinks = append(sinks, gin.H{
+			"name":          st.Name,
+			"written":       st.Written,
+			"dropped":       st.Dropped,
+			"errors":        st.Errors,
+			"queued":        st.Queued,
+			"written_ratio": lossRatio(st.Written, reader.Delivered),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Loss accounting retrieved successfully",
+		"kernel_dropped":  reader.KernelDropped,
+		"lost_samples":    reader.LostSamples,
+		"read_errors":     reader.ReadErrors,
+		"decode_errors":   reader.DecodeErrors,
+		"delivered":       reader.Delivered,
+		"delivered_ratio": lossRatio(reader.Delivered, emitted),
+		"suppressed":      suppressed,
+		"sinks":           sinks,
+		"stream":          as.broadcaster.Stats(),
+	})
+}
+
+// lossRatio is part/total, 1 when nothing was counted yet
+func lossRatio(part, total uint64) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(part) / float64(total)
+}
+
+// getMetrics renders the counters of the probe, controller, queue and sinks in the
+// Prometheus text format. Target map sizes are left out if the probe cannot be read.
+func (as *APIServer) getMetrics(c *gin.Context) {
+	w := &metricsWriter{}
+
+	as.eventMetrics.write(w)
+
+	// Userspace counters are valid even if the kernel counter cannot be read
+	reader, err := as.ebpfController.ReaderStats()
+	if err == nil {
+		w.family("ebpf_game_kernel_dropped_total", "counter", "Events the kernel could not queue to userspace (full ring buffer or failed perf output).")
+		w.sample("ebpf_game_kernel_dropped_total", float64(reader.KernelDropped))
+	}
+	if suppressed, err := as.ebpfController.GetSuppressedCounts(); err == nil {
+		w.family("ebpf_game_suppressed_events_total", "counter", "Matching syscalls not emitted because of sampling or rate limits, by reason.")
+		w.sample("ebpf_game_suppressed_events_total", float64(suppressed.Sampled), "reason", "sampled")
+		w.sample("ebpf_game_suppressed_events_total", float64(suppressed.PIDLimited), "reason", "pid_limit")
+		w.sample("ebpf_game_suppressed_events_total", float64(suppressed.GlobalLimited), "reason", "global_limit")
+	}
+	w.family("ebpf_game_lost_samples_total", "counter", "Samples lost by the perf buffer because userspace fell behind.")
+	w.sample("ebpf_game_lost_samples_total", float64(reader.LostSamples))
+	w.family("ebpf_game_read_errors_total", "counter", "Errors reading the ring buffer or perf buffer.")
+	w.sample("ebpf_game_read_errors_total", float64(reader.ReadErrors))
+	w.family("ebpf_game_decode_errors_total", "counter", "Samples too short to decode.")
+	w.sample("ebpf_game_decode_errors_total", float64(reader.DecodeErrors))
+	w.family("ebpf_game_events_delivered_total", "counter", "Events passed from the reader to the sinks.")
+	w.sample("ebpf_game_events_delivered_total", float64(reader.Delivered))
+
+	w.family("ebpf_game_command_queue_depth", "gauge", "Commands waiting for the controller.")
+	w.sample("ebpf_game_command_queue_depth", float64(len(as.cmdCh)))
+	w.family("ebpf_game_command_queue_capacity", "gauge", "Size of the command queue.")
+	w.sample("ebpf_game_command_queue_capacity", float64(cap(as.cmdCh)))
+	w.family("ebpf_game_command_queue_rejected_total", "counter", "API commands dropped because the command queue was full.")
+	w.sample("ebpf_game_command_queue_rejected_total", float64(as.queueRejected.Load()))
+	w.family("ebpf_game_command_timeouts_total", "counter", "API commands the controller did not answer in time.")
+	w.sample("ebpf_game_command_timeouts_total", float64(as.commandTimeouts.Load()))
+
+	w.family("ebpf_game_commands_total", "counter", "Commands processed by the controller by kind and result.")
+	for _, cc := range as.ebpfController.CommandCounts() {
+		w.sample("ebpf_game_commands_total", float64(cc.Count), "kind", cc.Kind, "result", cc.Result)
+	}
+
+	w.family("ebpf_game_targets", "gauge", "Entries of the target maps by kind.")
+	if pids, err := as.ebpfController.GetTargetPIDs(); err == nil {
+		w.sample("ebpf_game_targets", float64(len(pids)), "kind", "pid")
+	}
+	if comms, err := as.ebpfController.GetTargetComms(); err == nil {
+		w.sample("ebpf_game_targets", float64(len(comms)), "kind", "comm")
+	}
+	if cgroups, err := as.ebpfController.GetTargetCgroups(); err == nil {
+		w.sample("ebpf_game_targets", float64(len(cgroups)), "kind", "cgroup")
+	}
+
+	sinks := as.pipeline.Stats()
+	w.family("ebpf_game_sink_events_written_total", "counter", "Events written by each sink.")
+	for _, st := range sinks {
+		w.sample("ebpf_game_sink_events_written_total", float64(st.Written), "sink", st.Name)
+	}
+	w.family("ebpf_game_sink_events_dropped_total", "counter", "Events dropped because the sink queue was full.")
+	for _, st := range sinks {
+		w.sample("ebpf_game_sink_events_dropped_total", float64(st.Dropped), "sink", st.Name)
+	}
+	w.family("ebpf_game_sink_errors_total", "counter", "Events a sink failed to write.")
+	for _, st := range sinks {
+		w.sample("ebpf_game_sink_errors_total", float64(st.Errors), "sink", st.Name)
+	}
+
+	stream := as.broadcaster.Stats()
+	w.family("ebpf_game_stream_clients", "gauge", "Connected /events/stream clients.")
+	w.sample("ebpf_game_stream_clients", float64(stream.Clients))
+
+	if as.auth != nil {
+		auth := as.auth.Stats()
+		w.family("ebpf_game_api_denied_requests_total", "counter", "API requests denied by authentication or authorization, by reason.")
+		for _, reason := range []string{denyMissingToken, denyInvalidToken, denyForbidden} {
+			w.sample("ebpf_game_api_denied_requests_total", float64(auth.Denied[reason]), "reason", reason)
+		}
+		w.family("ebpf_game_api_tokens", "gauge", "API tokens loaded from the tokens file.")
+		w.sample("ebpf_game_api_tokens", float64(auth.Tokens))
+		w.family("ebpf_game_api_token_reload_errors_total", "counter", "Changes of the tokens file that failed to load.")
+		w.sample("ebpf_game_api_token_reload_errors_total", float64(auth.ReloadErrors))
+	}
+
+	if as.history != nil {
+		history := as.history.Stats()
+		w.family("ebpf_game_history_events", "gauge", "Events held by the in-memory history.")
+		w.sample("ebpf_game_history_events", float64(history.Size))
+		w.family("ebpf_game_history_evicted_total", "counter", "Events evicted from the in-memory history to make room.")
+		w.sample("ebpf_game_history_evicted_total", float64(history.Evicted))
+	}
+	if as.journal != nil {
+		if journal, err := as.journal.Stats(); err == nil {
+			w.family("ebpf_game_journal_bytes", "gauge", "Size of the segments of the on-disk journal.")
+			w.sample("ebpf_game_journal_bytes", float64(journal.SizeBytes))
+			w.family("ebpf_game_journal_segments", "gauge", "Segments of the on-disk journal.")
+			w.sample("ebpf_game_journal_segments", float64(journal.Segments))
+			w.family("ebpf_game_journal_removed_segments_total", "counter", "Journal segments removed by the size and age retention.")
+			w.sample("ebpf_game_journal_removed_segments_total", float64(journal.RemovedSegments))
+		}
+	}
+
+	c.Data(http.StatusOK, metricsContentType, w.buf.Bytes())
+}
+
 // Start starts the API server
 func (as *APIServer) Start() error {
-	as.logger.Infof("API Server starting on localhost:%s", as.port)
-	return as.router.Run(":" + as.port)
+	as.logger.Infof("API Server starting on %s", as.addr)
+	return as.router.Run(as.addr)
 }
 
 // GetRouter returns the router for testing purposes
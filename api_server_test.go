@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// newTestApplication wires the whole application around a MemoryProbe, without
+// listening: requests go through GetRouter
+func newTestApplication(t *testing.T, cfg Config) (*Application, *MemoryProbe) {
+	t.Helper()
+	logger, err := NewLogger(LogConfig{Kind: LoggerStdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := NewMemoryProbe(logger)
+	app, err := NewApplicationWithProbe(cfg, logger, probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Stop)
+	return app, probe
+}
+
+// testConfig is DefaultConfig without the state file and the logger sink
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.StateFile = ""
+	cfg.Sinks = nil
+	return cfg
+}
+
+// doRequest serves one request and decodes the JSON response
+func doRequest(t *testing.T, app *Application, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	app.apiServer.GetRouter().ServeHTTP(rec, req)
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s %s: invalid JSON response %q: %v", method, path, rec.Body.String(), err)
+	}
+	return rec.Code, response
+}
+
+// targetPIDs reads GET /target_pids
+func targetPIDs(t *testing.T, app *Application) ([]uint32, bool) {
+	t.Helper()
+	code, response := doRequest(t, app, http.MethodGet, "/target_pids", "")
+	if code != http.StatusOK {
+		t.Fatalf("GET /target_pids: status %d", code)
+	}
+	pids := []uint32{}
+	for _, pid := range response["pids"].([]interface{}) {
+		pids = append(pids, uint32(pid.(float64)))
+	}
+	return pids, response["print_all"].(bool)
+}
+
+// waitFor polls cond, for what the sinks and the controller do asynchronously
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func equalPIDs(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAddAndClearPIDs(t *testing.T) {
+	app, probe := newTestApplication(t, testConfig())
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", `{"pids": [1001, 1002, 1003]}`); code != http.StatusOK {
+		t.Fatalf("POST /add_pids: status %d", code)
+	}
+	if pids, printAll := targetPIDs(t, app); !equalPIDs(pids, []uint32{1001, 1002, 1003}) || printAll {
+		t.Fatalf("after add: pids %v print_all %v", pids, printAll)
+	}
+	if !probe.Inject(1001, evtRead) {
+		t.Error("event of a target PID was not delivered")
+	}
+	if probe.Inject(2000, evtRead) {
+		t.Error("event of a PID that is not a target was delivered")
+	}
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/clear_pid_list", ""); code != http.StatusOK {
+		t.Fatalf("POST /clear_pid_list: status %d", code)
+	}
+	if pids, _ := targetPIDs(t, app); len(pids) != 0 {
+		t.Errorf("after clear: pids %v", pids)
+	}
+	if probe.Inject(1003, evtRead) {
+		t.Error("event of a cleared PID was delivered")
+	}
+}
+
+func TestAddPIDsValidation(t *testing.T) {
+	app, _ := newTestApplication(t, testConfig())
+
+	for _, body := range []string{`{"pids": []}`, `{"pids": "1234"}`, `{}`, `not json`} {
+		if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", body); code != http.StatusBadRequest {
+			t.Errorf("POST /add_pids %s: status %d, want 400", body, code)
+		}
+	}
+}
+
+func TestSetPrintAll(t *testing.T) {
+	app, probe := newTestApplication(t, testConfig())
+
+	if probe.Inject(3000, evtWrite) {
+		t.Fatal("event delivered with no target and print_all off")
+	}
+	if code, _ := doRequest(t, app, http.MethodPost, "/set_print_all", ""); code != http.StatusOK {
+		t.Fatalf("POST /set_print_all: status %d", code)
+	}
+	if _, printAll := targetPIDs(t, app); !printAll {
+		t.Fatal("print_all not reported after POST /set_print_all")
+	}
+	if !probe.Inject(3000, evtWrite) {
+		t.Error("event not delivered with print_all on")
+	}
+
+	// Adding a target turns print_all off again
+	if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", `{"pids": [3001]}`); code != http.StatusOK {
+		t.Fatalf("POST /add_pids: status %d", code)
+	}
+	if _, printAll := targetPIDs(t, app); printAll {
+		t.Error("print_all still on after POST /add_pids")
+	}
+	if probe.Inject(3000, evtWrite) {
+		t.Error("event of a non-target delivered after print_all was turned off")
+	}
+}
+
+func TestRemovePIDs(t *testing.T) {
+	app, probe := newTestApplication(t, testConfig())
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", `{"pids": [1001, 1002, 1003]}`); code != http.StatusOK {
+		t.Fatalf("POST /add_pids: status %d", code)
+	}
+
+	if code, _ := doRequest(t, app, http.MethodDelete, "/target_pids/1001", ""); code != http.StatusOK {
+		t.Errorf("DELETE /target_pids/1001: status %d", code)
+	}
+	if code, _ := doRequest(t, app, http.MethodDelete, "/target_pids/1001", ""); code != http.StatusNotFound {
+		t.Errorf("DELETE /target_pids/1001 again: status %d, want 404", code)
+	}
+	if probe.Inject(1001, evtRead) {
+		t.Error("event of a removed PID was delivered")
+	}
+
+	code, response := doRequest(t, app, http.MethodDelete, "/target_pids", `{"pids": [1002, 4242]}`)
+	if code != http.StatusOK {
+		t.Fatalf("DELETE /target_pids: status %d", code)
+	}
+	if removed := response["removed_pids"].([]interface{}); len(removed) != 1 || removed[0].(float64) != 1002 {
+		t.Errorf("removed_pids = %v, want [1002]", removed)
+	}
+	if notPresent := response["not_present_pids"].([]interface{}); len(notPresent) != 1 || notPresent[0].(float64) != 4242 {
+		t.Errorf("not_present_pids = %v, want [4242]", notPresent)
+	}
+	if pids, _ := targetPIDs(t, app); !equalPIDs(pids, []uint32{1003}) {
+		t.Errorf("after remove: pids %v, want [1003]", pids)
+	}
+}
+
+func TestRemovePIDsValidation(t *testing.T) {
+	app, _ := newTestApplication(t, testConfig())
+
+	for _, tc := range []struct {
+		path, body string
+	}{
+		{"/target_pids/abc", ""},
+		{"/target_pids/0", ""},
+		{"/target_pids", `{}`},
+		{"/target_pids", `{"pids": []}`},
+	} {
+		if code, _ := doRequest(t, app, http.MethodDelete, tc.path, tc.body); code != http.StatusBadRequest {
+			t.Errorf("DELETE %s %s: status %d, want 400", tc.path, tc.body, code)
+		}
+	}
+}
+
+func TestCommRequestValidation(t *testing.T) {
+	app, _ := newTestApplication(t, testConfig())
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/target_comms", `{"comms": ["a-name-of-16-byte"]}`, http.StatusBadRequest},
+		{http.MethodPost, "/target_comms", `{"comms": [""]}`, http.StatusBadRequest},
+		{http.MethodDelete, "/target_comms/a-name-of-16-byte", "", http.StatusBadRequest},
+		{http.MethodDelete, "/target_comms", `{"comms": ["nginx", "a-name-of-16-byte"]}`, http.StatusBadRequest},
+		{http.MethodDelete, "/target_comms", `{"comms": [""]}`, http.StatusBadRequest},
+		{http.MethodPost, "/target_comms", `{"comms": ["nginx"]}`, http.StatusOK},
+		{http.MethodDelete, "/target_comms/postgres", "", http.StatusNotFound},
+		{http.MethodDelete, "/target_comms/nginx", "", http.StatusOK},
+	} {
+		if code, _ := doRequest(t, app, tc.method, tc.path, tc.body); code != tc.want {
+			t.Errorf("%s %s %s: status %d, want %d", tc.method, tc.path, tc.body, code, tc.want)
+		}
+	}
+}
+
+func TestInheritedThreadsDropped(t *testing.T) {
+	app, probe := newTestApplication(t, testConfig())
+	app.ebpfController.SetThreadCheck(func(id uint32) (bool, error) {
+		switch id {
+		case 602, 604:
+			return true, nil
+		case 603:
+			return false, os.ErrNotExist
+		}
+		return false, nil
+	})
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", `{"pids": [600], "follow_children": true}`); code != http.StatusOK {
+		t.Fatalf("POST /add_pids: status %d", code)
+	}
+	for _, child := range []uint32{601, 602, 603} {
+		if !probe.InjectFork(600, child) {
+			t.Fatalf("fork of %d not inherited", child)
+		}
+	}
+
+	// The thread and the child gone before it was checked leave the kernel map
+	waitFor(t, "the thread and the gone child to be removed", func() bool {
+		pids, _ := probe.GetTargetPIDs()
+		return equalPIDs(pids, []uint32{600, 601})
+	})
+	waitFor(t, "the process child to be recorded", func() bool {
+		return len(app.pidManager.GetTargets()) == 2
+	})
+	if target := app.pidManager.GetTargets()[1]; target.PID != 601 || !target.Inherited || target.ParentPID != 600 {
+		t.Errorf("inherited target %+v, want 601 inherited from 600", target)
+	}
+
+	// A thread exiting before its fork is handled drops its own entry in the kernel
+	if !probe.InjectFork(600, 604) || !probe.InjectThreadExit(604) {
+		t.Fatal("thread 604 not inherited then removed")
+	}
+	if probe.InjectThreadExit(604) {
+		t.Error("exit of a thread without an entry reported")
+	}
+}
+
+func TestSkipPIDPrecedence(t *testing.T) {
+	app, probe := newTestApplication(t, testConfig())
+	self := selfPID()
+	probe.AddSkipPID(5000)
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", `{"pids": [5000, 5001]}`); code != http.StatusOK {
+		t.Fatalf("POST /add_pids: status %d", code)
+	}
+	if code, _ := doRequest(t, app, http.MethodPost, "/exclusions", `{"pids": [5001]}`); code != http.StatusOK {
+		t.Fatalf("POST /exclusions: status %d", code)
+	}
+	if probe.Inject(5000, evtRead) {
+		t.Error("skipped PID delivered although it is a target")
+	}
+	if probe.Inject(5001, evtRead) {
+		t.Error("excluded PID delivered although it is a target")
+	}
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/set_print_all", ""); code != http.StatusOK {
+		t.Fatalf("POST /set_print_all: status %d", code)
+	}
+	for _, pid := range []uint32{self, 5000, 5001} {
+		if probe.Inject(pid, evtRead) {
+			t.Errorf("skipped PID %d delivered in print_all mode", pid)
+		}
+	}
+	if !probe.Inject(5002, evtRead) {
+		t.Error("other PID not delivered in print_all mode")
+	}
+}
+
+func TestAbandonedCommandTracked(t *testing.T) {
+	app, probe := newTestApplication(t, testConfig())
+
+	// A command whose caller gave up, as after a 504, is still applied and tracked
+	app.cmdCh <- MonitorCommand{Kind: CommandAddPID, PID: 7001, Result: make(chan CommandOutcome, 1)}
+	waitFor(t, "the abandoned add in the PID list", func() bool {
+		return equalPIDs(app.pidManager.GetAllPIDs(), []uint32{7001})
+	})
+	if !probe.Inject(7001, evtRead) {
+		t.Error("event of the added PID was not delivered")
+	}
+
+	app.cmdCh <- MonitorCommand{Kind: CommandRemovePID, PIDs: []uint32{7001}, Result: make(chan CommandOutcome, 1)}
+	waitFor(t, "the abandoned remove in the PID list", func() bool {
+		return len(app.pidManager.GetAllPIDs()) == 0
+	})
+}
+
+func TestEventsEndpoint(t *testing.T) {
+	app, probe := newTestApplication(t, testConfig())
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/set_print_all", ""); code != http.StatusOK {
+		t.Fatalf("POST /set_print_all: status %d", code)
+	}
+	if !probe.Inject(3000, evtWrite) || !probe.Inject(3001, evtRead) {
+		t.Fatal("event not delivered with print_all on")
+	}
+
+	// The events go through the pipeline into the history
+	waitFor(t, "the event in GET /events", func() bool {
+		code, response := doRequest(t, app, http.MethodGet, "/events?pid=3000", "")
+		return code == http.StatusOK && response["count"].(float64) == 1
+	})
+	if code, _ := doRequest(t, app, http.MethodGet, "/events?cursor=abc", ""); code != http.StatusBadRequest {
+		t.Errorf("GET /events?cursor=abc: status %d, want 400", code)
+	}
+}
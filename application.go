@@ -1,42 +1,367 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"os"
+	"time"
 )
 
 // Application manages eBPF monitoring, the command-processing app, and the API server.
 type Application struct {
 	logger         Logger
-	ebpfProbe      *EBpfProbe
+	ebpfProbe      Probe
 	ebpfController *EBpfController
 	cmdCh          chan MonitorCommand
+	pipeline       *EventPipeline
 	apiServer      *APIServer
+	pidManager     *PIDManager
+
+	// stateFile, when set, is refreshed by persistLoop and read back at startup
+	stateFile string
+	stateStop chan struct{}
+	stateDone chan struct{}
+
+	// recorder, when set, is the capture file of the record mode
+	recorder *CaptureWriter
+	// auth, when set, authenticates the API requests
+	auth *Authenticator
 }
 
-// NewApplication creates a new application instance
-func NewApplication(apiPort string, logger Logger) (*Application, error) {
+// NewApplication creates a new application instance backed by the eBPF probe.
+// With cfg.StateFile, the targets, exclusions and print_all mode saved by a previous
+// run are restored, and saved again while running. The exclusions and targets of
+// cfg are applied after that. With cfg.Capture.Record the raw events and the target
+// changes are recorded from then on; with cfg.Capture.Replay a capture is replayed instead.
+func NewApplication(cfg Config, logger Logger) (*Application, error) {
+	if cfg.Capture.Replay != "" {
+		return newReplayApplication(cfg, logger)
+	}
+
 	// Initialize eBPF monitor
-	ebpfProbe, err := NewEBpfProbe(logger)
+	ebpfProbe, err := NewEBpfProbe(logger, cfg.Probe)
 	if err != nil {
 		logger.Errorf("failed to create eBPF monitor: %v", err)
 		return nil, errors.New("failed to create eBPF monitor: " + err.Error())
 	}
 
+	app, err := NewApplicationWithProbe(cfg, logger, ebpfProbe)
+	if err != nil {
+		ebpfProbe.Stop()
+		return nil, err
+	}
+	if cfg.StateFile != "" {
+		app.stateFile = cfg.StateFile
+		app.restoreState(ebpfProbe.ReusedPinnedMaps())
+	}
+	if err := app.applyExclusions(cfg.Exclude); err != nil {
+		app.Stop()
+		logger.Errorf("failed to apply exclusions: %v", err)
+		return nil, errors.New("failed to apply exclusions: " + err.Error())
+	}
+	if err := app.applyTargets(cfg.Targets); err != nil {
+		app.Stop()
+		logger.Errorf("failed to apply initial targets: %v", err)
+		return nil, errors.New("failed to apply initial targets: " + err.Error())
+	}
+	if cfg.RateLimit.Enabled() {
+		if err := app.submit(MonitorCommand{Kind: CommandSetRateLimit, RateLimit: cfg.RateLimit.Normalize()}); err != nil {
+			app.Stop()
+			logger.Errorf("failed to apply rate limit: %v", err)
+			return nil, errors.New("failed to apply rate limit: " + err.Error())
+		}
+	}
+	app.syncPIDManager()
+	if cfg.Capture.Record != "" {
+		if err := app.startRecording(cfg.Capture.Record, ebpfProbe); err != nil {
+			app.Stop()
+			return nil, err
+		}
+	}
+	return app, nil
+}
+
+// newReplayApplication runs the application on a capture file instead of the kernel.
+// The state file, exclusions, targets and rate limit of cfg are ignored: the capture
+// carries the targets and mode of the recording.
+func newReplayApplication(cfg Config, logger Logger) (*Application, error) {
+	replay, err := NewReplayProbe(logger, cfg.Capture.Replay, cfg.Capture.Speed)
+	if err != nil {
+		return nil, err
+	}
+	app, err := NewApplicationWithProbe(cfg, logger, replay)
+	if err != nil {
+		replay.Stop()
+		return nil, err
+	}
+	// The recorded children are checked against the /proc of another host, or of
+	// another time: every one is taken for a process, the threads the recording
+	// dropped are removed by the recorded remove_pid commands
+	app.ebpfController.SetThreadCheck(func(uint32) (bool, error) { return false, nil })
+	replay.SetCommandHandler(app.replayCommand)
+	return app, nil
+}
+
+// replayCommand applies a target or mode change of a capture through the controller
+func (app *Application) replayCommand(cmd MonitorCommand) {
+	cmd.Result = make(chan CommandOutcome, 1)
+	select {
+	case app.cmdCh <- cmd:
+	default:
+		app.logger.Warnf("Command queue full, skipping replayed %s", cmd.Kind)
+		return
+	}
+	select {
+	case outcome := <-cmd.Result:
+		if outcome.Err != nil {
+			app.logger.Warnf("Failed to replay %s: %v", cmd.Kind, outcome.Err)
+		}
+	case <-time.After(commandTimeout):
+		app.logger.Warnf("Timed out replaying %s", cmd.Kind)
+	}
+}
+
+// startRecording creates the capture file and records the current targets and mode,
+// then every sample read and every command applied
+func (app *Application) startRecording(path string, ebpfProbe *EBpfProbe) error {
+	hostname, _ := os.Hostname()
+	recorder, err := CreateCapture(path, CaptureHeader{
+		Created:   time.Now(),
+		Hostname:  hostname,
+		Transport: ebpfProbe.Info().Transport,
+		DataSize:  dataSize,
+	}, app.logger)
+	if err != nil {
+		return err
+	}
+	state, err := app.ebpfController.Snapshot()
+	if err != nil {
+		recorder.Close()
+		app.logger.Errorf("failed to snapshot the state to record: %v", err)
+		return errors.New("failed to snapshot the state to record: " + err.Error())
+	}
+	recorder.WriteState(state)
+	ebpfProbe.SetRecorder(recorder)
+	app.ebpfController.SetRecorder(recorder)
+	app.recorder = recorder
+	app.apiServer.recorder = recorder
+	app.logger.Infof("Recording events and target changes to %s", path)
+	return nil
+}
+
+// submit sends cmd to the controller and waits for its outcome; only used before
+// the API server starts, when nothing else competes for the queue
+func (app *Application) submit(cmd MonitorCommand) error {
+	cmd.Result = make(chan CommandOutcome, 1)
+	app.cmdCh <- cmd
+	return (<-cmd.Result).Err
+}
+
+// applyExclusions installs configured exclusions through the controller, so that
+// excluded cgroups keep their paths like the ones added through the API
+func (app *Application) applyExclusions(cfg ExclusionConfig) error {
+	cmds, err := exclusionCommands(cfg)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if err := app.submit(cmd); err != nil {
+			return err
+		}
+	}
+	if len(cmds) > 0 {
+		app.logger.Infof("Applied %d exclusions from the configuration", len(cmds))
+	}
+	return nil
+}
+
+// applyTargets adds the targets of the configuration through the controller
+func (app *Application) applyTargets(cfg TargetConfig) error {
+	cmds, err := targetCommands(cfg, app.logger)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if err := app.submit(cmd); err != nil {
+			return err
+		}
+	}
+	if len(cmds) > 0 {
+		app.logger.Infof("Applied %d targets from the configuration", len(cmds))
+	}
+	return nil
+}
+
+// restoreState replays the state file. Failures only cost the affected entries:
+// a malformed file is moved aside and the monitor starts empty.
+func (app *Application) restoreState(pinned bool) {
+	state, err := loadState(app.stateFile)
+	if err != nil {
+		app.logger.Warnf("Cannot restore state, starting without it: %v", err)
+		if renameErr := os.Rename(app.stateFile, app.stateFile+".bad"); renameErr != nil {
+			app.logger.Warnf("Failed to move %s aside: %v", app.stateFile, renameErr)
+		}
+		return
+	}
+	if state == nil {
+		return
+	}
+
+	app.logger.Infof("Restoring state saved at %s from %s", state.SavedAt.Format(time.RFC3339), app.stateFile)
+	failed := 0
+	for _, cmd := range restoreCommands(state, pinned, app.logger) {
+		if err := app.submit(cmd); err != nil {
+			app.logger.Warnf("Failed to restore %s: %v", cmd.Kind, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		app.logger.Warnf("State restored with %d failures", failed)
+	}
+}
+
+// syncPIDManager fills the PID list from the kernel, which may hold targets
+// restored from pinned maps or the state file
+func (app *Application) syncPIDManager() {
+	targets, err := app.ebpfProbe.GetTargets()
+	if err != nil {
+		app.logger.Warnf("Failed to read restored targets: %v", err)
+		return
+	}
+	for _, t := range targets {
+		if t.Inherited {
+			app.pidManager.AddInherited(t.PID, t.ParentPID)
+		} else {
+			app.pidManager.AddPIDs([]uint32{t.PID}, t.FollowChildren)
+		}
+	}
+}
+
+// persistLoop saves the state file whenever the state changed, and once more on Stop
+func (app *Application) persistLoop() {
+	defer close(app.stateDone)
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	var saved []byte
+	for {
+		select {
+		case <-ticker.C:
+			saved = app.saveState(saved)
+		case <-app.stateStop:
+			app.saveState(saved)
+			return
+		}
+	}
+}
+
+// saveState writes the state file unless the state is still the saved one,
+// and returns the state now on disk
+func (app *Application) saveState(saved []byte) []byte {
+	state, err := app.ebpfController.Snapshot()
+	if err != nil {
+		app.logger.Warnf("Failed to snapshot state: %v", err)
+		return saved
+	}
+	current, err := json.Marshal(state)
+	if err != nil || bytes.Equal(current, saved) {
+		return saved
+	}
+
+	state.fillStartTimes()
+	state.SavedAt = time.Now()
+	if err := saveState(app.stateFile, state); err != nil {
+		app.logger.Warnf("Failed to save state to %s: %v", app.stateFile, err)
+		return saved
+	}
+	return current
+}
+
+// NewApplicationWithProbe wires the sinks, history, journal, controller and API server of cfg
+// around any Probe, e.g. a MemoryProbe when running without root. The state file,
+// exclusions, targets and rate limit of cfg are left to NewApplication.
+func NewApplicationWithProbe(cfg Config, logger Logger, ebpfProbe Probe) (*Application, error) {
 	// Shared command queue
 	cmdCh := make(chan MonitorCommand, 256)
 
+	// PID list shared by the API (user targets) and the controller (inherited targets)
+	pidManager := NewPIDManager()
+
 	// Initialize controller (reads from queue and controls eBPF)
-	ebpfController := NewEBpfController(logger, ebpfProbe, cmdCh)
+	ebpfController := NewEBpfController(logger, ebpfProbe, cmdCh, pidManager)
+
+	// Events go through the sink pipeline: configured sinks plus live stream clients
+	pipeline := NewEventPipeline(logger)
+	var ring *RingSink
+	for _, sinkCfg := range cfg.Sinks {
+		sink, err := NewEventSink(sinkCfg, logger)
+		if err != nil {
+			logger.Errorf("failed to create %s sink: %v", sinkCfg.Kind, err)
+			pipeline.Close()
+			ebpfController.Stop()
+			return nil, errors.New("failed to create " + sinkCfg.Kind + " sink: " + err.Error())
+		}
+		if r, ok := sink.(*RingSink); ok {
+			ring = r
+		}
+		pipeline.AddSink(sink, sinkCfg.QueueSize)
+		logger.Infof("Event sink enabled: %s", sink.Name())
+	}
+	var history *EventHistory
+	if cfg.History.Capacity > 0 {
+		history = NewEventHistory(cfg.History.Capacity)
+		pipeline.AddSink(history, 0)
+		logger.Infof("Event history enabled: %d events", cfg.History.Capacity)
+	}
+	var journal *EventJournal
+	if cfg.Journal.Dir != "" {
+		var err error
+		journal, err = OpenJournal(cfg.Journal, logger)
+		if err != nil {
+			pipeline.Close()
+			ebpfController.Stop()
+			return nil, err
+		}
+		pipeline.AddSink(journal, cfg.Journal.QueueSize)
+		logger.Infof("Event journal enabled: %s", cfg.Journal.Dir)
+	}
+	var auth *Authenticator
+	if cfg.Auth.TokensFile != "" {
+		var err error
+		auth, err = NewAuthenticator(cfg.Auth, logger)
+		if err != nil {
+			pipeline.Close()
+			ebpfController.Stop()
+			return nil, err
+		}
+		logger.Infof("API authentication enabled: %s", cfg.Auth.TokensFile)
+	} else {
+		logger.Warnf("API authentication disabled: anyone reaching %s can change the targets", cfg.Listen)
+	}
+	broadcaster := NewEventBroadcaster()
+	pipeline.AddSink(broadcaster, 0)
+	eventMetrics := NewEventMetrics(cfg.Metrics.MaxTargets)
+	ebpfProbe.SetEventHandler(func(event Data) {
+		eventMetrics.Observe(event)
+		if isProcessEvent(event.EventType) {
+			ebpfController.HandleProcessEvent(event)
+		}
+		pipeline.Publish(event)
+	})
 
 	// Initialize API server (enqueues to queue, queries via controller)
-	apiServer := NewAPIServer(apiPort, logger, cmdCh, ebpfController)
+	apiServer := NewAPIServer(cfg.Listen, logger, cmdCh, ebpfController, pidManager, broadcaster, pipeline, ring, history, journal, auth, eventMetrics)
 
 	return &Application{
 		logger:         logger,
 		ebpfProbe:      ebpfProbe,
 		ebpfController: ebpfController,
 		cmdCh:          cmdCh,
+		pipeline:       pipeline,
 		apiServer:      apiServer,
+		pidManager:     pidManager,
+		auth:           auth,
 	}, nil
 }
 
@@ -45,6 +370,13 @@ func (app *Application) Start() error {
 	// Start eBPF monitoring
 	app.ebpfProbe.Start()
 
+	// Keep the state file in step with the controller
+	if app.stateFile != "" {
+		app.stateStop = make(chan struct{})
+		app.stateDone = make(chan struct{})
+		go app.persistLoop()
+	}
+
 	// Start API server in a goroutine
 	go func() {
 		if err := app.apiServer.Start(); err != nil {
@@ -57,10 +389,32 @@ func (app *Application) Start() error {
 
 // Stop cleans up all resources
 func (app *Application) Stop() {
+	// First, so the final snapshot still sees the controller and probe
+	if app.stateStop != nil {
+		close(app.stateStop)
+		<-app.stateDone
+		app.stateStop = nil
+	}
 	if app.ebpfController != nil {
 		app.ebpfController.Stop()
 	}
 	if app.ebpfProbe != nil {
 		app.ebpfProbe.Stop()
 	}
+	// After the probe, so events already read are flushed to the sinks
+	if app.pipeline != nil {
+		app.pipeline.Close()
+	}
+	if app.recorder != nil {
+		if err := app.recorder.Close(); err != nil {
+			app.logger.Warnf("Failed to close the capture file: %v", err)
+		}
+		app.recorder = nil
+	}
+	if app.auth != nil {
+		if err := app.auth.Close(); err != nil {
+			app.logger.Warnf("Failed to close the audit file: %v", err)
+		}
+		app.auth = nil
+	}
 }
\ No newline at end of file
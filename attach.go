@@ -0,0 +1,454 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+)
+
+// Attach mechanisms, in order of preference
+const (
+	AttachTracepoint = "tracepoint"
+	AttachFentry     = "fentry"
+	AttachKprobe     = "kprobe"
+)
+
+// AttachInfo reports how one syscall is hooked.
+// ExitTarget is empty when exit tracking could not be attached.
+type AttachInfo struct {
+	Syscall    string `json:"syscall"`
+	Mechanism  string `json:"mechanism"`
+	Target     string `json:"target"`
+	ExitTarget string `json:"exit_target,omitempty"`
+}
+
+type attachment struct {
+	info     AttachInfo
+	link     link.Link // nil for raw_syscalls:sys_enter, which is shared
+	exitLink link.Link // nil for raw_syscalls:sys_exit or without exit tracking
+}
+
+// attacher loads programs one at a time against a shared set of maps,
+// so a program the kernel rejects (e.g. fentry without BTF) does not prevent
+// the others from loading, and attaches them with the best available mechanism.
+type attacher struct {
+	spec     *ebpf.CollectionSpec
+	mapsColl *ebpf.Collection
+	maps     map[string]*ebpf.Map
+	mode     string
+	logger   Logger
+
+	mu          sync.Mutex
+	progs       map[string]*ebpf.Program
+	attachments map[string]*attachment
+	rawLink     link.Link
+	rawExitLink link.Link
+	rawUsers    int
+	// process lifecycle hooks, kept until close
+	processLinks []link.Link
+}
+
+// newAttacher creates every map of spec and assigns them to maps.
+// Ownership of the assigned maps moves to maps; the attacher owns the rest.
+// Maps marked for pinning are created under, or reused from, pinPath.
+func newAttacher(spec *ebpf.CollectionSpec, maps *ebpf_probeMaps, mode, pinPath string, logger Logger) (*attacher, error) {
+	switch mode {
+	case "", AttachTracepoint, AttachFentry, AttachKprobe:
+	default:
+		return nil, errors.New("unknown attach mode: " + mode)
+	}
+
+	// Global data sections (.rodata) are left out: every program gets its own copy
+	mapsSpec := &ebpf.CollectionSpec{
+		Maps:      make(map[string]*ebpf.MapSpec),
+		Types:     spec.Types,
+		ByteOrder: spec.ByteOrder,
+	}
+	for name, ms := range spec.Maps {
+		if !strings.HasPrefix(name, ".") {
+			mapsSpec.Maps[name] = ms
+		}
+	}
+
+	coll, err := ebpf.NewCollectionWithOptions(mapsSpec, ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: pinPath}})
+	if err != nil {
+		return nil, err
+	}
+	shared := make(map[string]*ebpf.Map, len(coll.Maps))
+	for name, m := range coll.Maps {
+		shared[name] = m
+	}
+	if err := coll.Assign(maps); err != nil {
+		coll.Close()
+		return nil, err
+	}
+
+	return &attacher{
+		spec:        spec,
+		mapsColl:    coll,
+		maps:        shared,
+		mode:        mode,
+		logger:      logger,
+		progs:       make(map[string]*ebpf.Program),
+		attachments: make(map[string]*attachment),
+	}, nil
+}
+
+// program loads the named program once; attachTo overrides the fentry target
+func (a *attacher) program(name, attachTo string) (*ebpf.Program, error) {
+	if prog, ok := a.progs[name]; ok {
+		return prog, nil
+	}
+
+	progSpec, ok := a.spec.Programs[name]
+	if !ok {
+		return nil, errors.New("program " + name + " missing from eBPF spec")
+	}
+	progSpec = progSpec.Copy()
+	if attachTo != "" {
+		progSpec.AttachTo = attachTo
+	}
+
+	single := &ebpf.CollectionSpec{
+		Maps:      a.spec.Maps,
+		Programs:  map[string]*ebpf.ProgramSpec{name: progSpec},
+		Types:     a.spec.Types,
+		ByteOrder: a.spec.ByteOrder,
+	}
+	coll, err := ebpf.NewCollectionWithOptions(single, ebpf.CollectionOptions{MapReplacements: a.maps})
+	if err != nil {
+		return nil, err
+	}
+	prog := coll.DetachProgram(name)
+	coll.Close()
+
+	a.progs[name] = prog
+	return prog, nil
+}
+
+// attach hooks sc with the first mechanism that works, in the order
+// tracepoint (syscalls:sys_enter_*, then raw_syscalls:sys_enter), fentry, kprobe
+func (a *attacher) attach(sc syscallProbe) (AttachInfo, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if att, ok := a.attachments[sc.name]; ok {
+		return att.info, nil
+	}
+
+	type step struct {
+		mechanism string
+		try       func(syscallProbe) (*attachment, error)
+	}
+	steps := []step{
+		{AttachTracepoint, a.attachTracepoint},
+		{AttachTracepoint, a.attachRawTracepoint},
+		{AttachFentry, a.attachFentry},
+		{AttachKprobe, a.attachKprobe},
+	}
+
+	var failures []string
+	for _, s := range steps {
+		if a.mode != "" && a.mode != s.mechanism {
+			continue
+		}
+		att, err := s.try(sc)
+		if err != nil {
+			a.logger.Debugf("Cannot attach %s via %s: %v", sc.name, s.mechanism, err)
+			failures = append(failures, s.mechanism+": "+err.Error())
+			continue
+		}
+		a.attachments[sc.name] = att
+		return att.info, nil
+	}
+
+	return AttachInfo{}, errors.New("no attach mechanism available for " + sc.name + " (" + strings.Join(failures, "; ") + ")")
+}
+
+func (a *attacher) attachTracepoint(sc syscallProbe) (*attachment, error) {
+	prog, err := a.program("tp_sys_enter", "")
+	if err != nil {
+		return nil, err
+	}
+	events := a.maps["syscall_events"]
+	if err := events.Update(&sc.nr, &sc.eventType, ebpf.UpdateAny); err != nil {
+		return nil, err
+	}
+	l, err := link.Tracepoint("syscalls", "sys_enter_"+sc.name, prog, nil)
+	if err != nil {
+		events.Delete(&sc.nr)
+		return nil, err
+	}
+	att := &attachment{
+		info: AttachInfo{Syscall: sc.name, Mechanism: AttachTracepoint, Target: "syscalls:sys_enter_" + sc.name},
+		link: l,
+	}
+	a.attachExit(sc, att, "syscalls:sys_exit_"+sc.name, func() (link.Link, error) {
+		exitProg, err := a.program("tp_sys_exit", "")
+		if err != nil {
+			return nil, err
+		}
+		return link.Tracepoint("syscalls", "sys_exit_"+sc.name, exitProg, nil)
+	})
+	return att, nil
+}
+
+func (a *attacher) attachRawTracepoint(sc syscallProbe) (*attachment, error) {
+	prog, err := a.program("raw_tp_sys_enter", "")
+	if err != nil {
+		return nil, err
+	}
+	if a.rawLink == nil {
+		l, err := link.Tracepoint("raw_syscalls", "sys_enter", prog, nil)
+		if err != nil {
+			return nil, err
+		}
+		a.rawLink = l
+	}
+	if a.rawExitLink == nil {
+		if exitProg, err := a.program("raw_tp_sys_exit", ""); err != nil {
+			a.logger.Warnf("Exit tracking unavailable via raw_syscalls:sys_exit: %v", err)
+		} else if l, err := link.Tracepoint("raw_syscalls", "sys_exit", exitProg, nil); err != nil {
+			a.logger.Warnf("Exit tracking unavailable via raw_syscalls:sys_exit: %v", err)
+		} else {
+			a.rawExitLink = l
+		}
+	}
+	if err := a.maps["raw_syscall_events"].Update(&sc.nr, &sc.eventType, ebpf.UpdateAny); err != nil {
+		a.releaseRaw()
+		return nil, err
+	}
+	a.rawUsers++
+	info := AttachInfo{Syscall: sc.name, Mechanism: AttachTracepoint, Target: "raw_syscalls:sys_enter"}
+	if a.rawExitLink != nil {
+		info.ExitTarget = "raw_syscalls:sys_exit"
+	}
+	return &attachment{info: info}, nil
+}
+
+func (a *attacher) attachFentry(sc syscallProbe) (*attachment, error) {
+	if sc.fentryProg == "" {
+		return nil, errors.New("no fentry program for " + sc.name)
+	}
+	kernelBTF, err := btf.LoadKernelSpec()
+	if err != nil {
+		return nil, err
+	}
+	var fn *btf.Func
+	var target string
+	for _, cand := range syscallSymbolCandidates(sc.name) {
+		if kernelBTF.TypeByName(cand, &fn) == nil {
+			target = cand
+			break
+		}
+	}
+	if target == "" {
+		return nil, errors.New("no BTF function found for " + sc.name)
+	}
+	prog, err := a.program(sc.fentryProg, target)
+	if err != nil {
+		return nil, err
+	}
+	l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	if err != nil {
+		return nil, err
+	}
+	att := &attachment{
+		info: AttachInfo{Syscall: sc.name, Mechanism: AttachFentry, Target: target},
+		link: l,
+	}
+	a.attachExit(sc, att, "fexit:"+target, func() (link.Link, error) {
+		if sc.fexitProg == "" {
+			return nil, errors.New("no fexit program for " + sc.name)
+		}
+		exitProg, err := a.program(sc.fexitProg, target)
+		if err != nil {
+			return nil, err
+		}
+		return link.AttachTracing(link.TracingOptions{Program: exitProg})
+	})
+	return att, nil
+}
+
+func (a *attacher) attachKprobe(sc syscallProbe) (*attachment, error) {
+	if sc.kprobeProg == "" {
+		return nil, errors.New("no kprobe program for " + sc.name)
+	}
+	target, err := findSyscallSymbol(sc.name, a.logger)
+	if err != nil {
+		return nil, err
+	}
+	if _, loaded := a.progs[sc.kprobeProg]; !loaded {
+		// Plain sys_<name> (no arch wrapper) receives the syscall arguments directly
+		wrapper := uint32(0)
+		if strings.HasPrefix(target, "__") {
+			wrapper = 1
+		}
+		if err := a.spec.RewriteConstants(map[string]interface{}{"syscall_wrapper": wrapper}); err != nil {
+			return nil, err
+		}
+	}
+	prog, err := a.program(sc.kprobeProg, "")
+	if err != nil {
+		return nil, err
+	}
+	l, err := link.Kprobe(target, prog, nil)
+	if err != nil {
+		return nil, err
+	}
+	att := &attachment{
+		info: AttachInfo{Syscall: sc.name, Mechanism: AttachKprobe, Target: target},
+		link: l,
+	}
+	a.attachExit(sc, att, "kretprobe:"+target, func() (link.Link, error) {
+		if sc.kretprobeProg == "" {
+			return nil, errors.New("no kretprobe program for " + sc.name)
+		}
+		exitProg, err := a.program(sc.kretprobeProg, "")
+		if err != nil {
+			return nil, err
+		}
+		return link.Kretprobe(target, exitProg, nil)
+	})
+	return att, nil
+}
+
+// attachExit hooks the exit side matching att's mechanism.
+// Exit tracking is best effort: without it, entries are still traced.
+func (a *attacher) attachExit(sc syscallProbe, att *attachment, target string, try func() (link.Link, error)) {
+	l, err := try()
+	if err != nil {
+		a.logger.Warnf("Exit tracking unavailable for %s via %s: %v", sc.name, target, err)
+		return
+	}
+	att.exitLink = l
+	att.info.ExitTarget = target
+}
+
+// attachProcessTracepoint hooks a program on a tracepoint unrelated to syscalls
+// (sched:sched_process_fork, ...); it stays attached until close.
+// btfProgName, if set, is a tp_btf program for the same tracepoint, which can read
+// the tasks involved; progName is the fallback for kernels without BTF.
+func (a *attacher) attachProcessTracepoint(btfProgName, progName, group, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if btfProgName != "" {
+		l, err := a.attachBTFTracepoint(btfProgName)
+		if err == nil {
+			a.processLinks = append(a.processLinks, l)
+			a.logger.Infof("Attached %s to %s:%s", btfProgName, group, name)
+			return nil
+		}
+		a.logger.Debugf("Cannot attach %s, falling back to %s: %v", btfProgName, progName, err)
+	}
+
+	prog, err := a.program(progName, "")
+	if err != nil {
+		return err
+	}
+	l, err := link.Tracepoint(group, name, prog, nil)
+	if err != nil {
+		return err
+	}
+	a.processLinks = append(a.processLinks, l)
+	a.logger.Infof("Attached %s to %s:%s", progName, group, name)
+	return nil
+}
+
+func (a *attacher) attachBTFTracepoint(progName string) (link.Link, error) {
+	prog, err := a.program(progName, "")
+	if err != nil {
+		return nil, err
+	}
+	return link.AttachTracing(link.TracingOptions{Program: prog})
+}
+
+// detach unhooks sc and stops mapping its syscall number to an event type
+func (a *attacher) detach(sc syscallProbe) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	att, ok := a.attachments[sc.name]
+	if !ok {
+		return errors.New("syscall " + sc.name + " is not enabled")
+	}
+	delete(a.attachments, sc.name)
+
+	if att.exitLink != nil {
+		att.exitLink.Close()
+	}
+	if att.link != nil {
+		if err := att.link.Close(); err != nil {
+			return err
+		}
+		if att.info.Mechanism == AttachTracepoint {
+			a.maps["syscall_events"].Delete(&sc.nr)
+		}
+		return nil
+	}
+
+	// Shared raw_syscalls:sys_enter hook
+	a.rawUsers--
+	err := a.maps["raw_syscall_events"].Delete(&sc.nr)
+	a.releaseRaw()
+	return err
+}
+
+// releaseRaw detaches raw_syscalls:sys_enter once no syscall uses it anymore
+func (a *attacher) releaseRaw() {
+	if a.rawUsers == 0 && a.rawLink != nil {
+		a.rawLink.Close()
+		a.rawLink = nil
+		if a.rawExitLink != nil {
+			a.rawExitLink.Close()
+			a.rawExitLink = nil
+		}
+	}
+}
+
+// infos lists the current attachments in registry order
+func (a *attacher) infos() []AttachInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	infos := make([]AttachInfo, 0, len(a.attachments))
+	for _, sc := range syscallRegistry {
+		if att, ok := a.attachments[sc.name]; ok {
+			infos = append(infos, att.info)
+		}
+	}
+	return infos
+}
+
+// close detaches everything and frees the programs and the maps not handed out
+func (a *attacher) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, att := range a.attachments {
+		if att.exitLink != nil {
+			att.exitLink.Close()
+		}
+		if att.link != nil {
+			att.link.Close()
+		}
+	}
+	if a.rawExitLink != nil {
+		a.rawExitLink.Close()
+	}
+	if a.rawLink != nil {
+		a.rawLink.Close()
+	}
+	for _, l := range a.processLinks {
+		l.Close()
+	}
+	for _, prog := range a.progs {
+		prog.Close()
+	}
+	a.mapsColl.Close()
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// API token roles. Readers may call the GET endpoints; admins may call everything.
+const (
+	RoleReader = "read"
+	RoleAdmin  = "admin"
+)
+
+const (
+	// authReloadInterval is how often the tokens file is checked for changes
+	authReloadInterval = 5 * time.Second
+	// minTokenLength rejects tokens short enough to guess
+	minTokenLength = 16
+)
+
+// Reasons a request is denied, as audited and counted
+const (
+	denyMissingToken = "missing_token"
+	denyInvalidToken = "invalid_token"
+	denyForbidden    = "forbidden"
+)
+
+// AuthConfig enables token authentication on the API
+type AuthConfig struct {
+	// TokensFile lists the API tokens and their roles; empty leaves the API open
+	TokensFile string `json:"tokens_file" yaml:"tokens_file"`
+	// AuditFile, when set, receives every denied request as a JSON line, besides the log
+	AuditFile string `json:"audit_file" yaml:"audit_file"`
+}
+
+// APIToken is one entry of the tokens file. Exactly one of Token and SHA256 is set;
+// SHA256, the hex digest of the token, keeps the token itself out of the file.
+type APIToken struct {
+	Name   string `json:"name" yaml:"name"`
+	Role   string `json:"role" yaml:"role"`
+	Token  string `json:"token,omitempty" yaml:"token"`
+	SHA256 string `json:"sha256,omitempty" yaml:"sha256"`
+}
+
+type tokensFile struct {
+	Tokens []APIToken `json:"tokens" yaml:"tokens"`
+}
+
+// AuditEntry records one denied request
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Reason string    `json:"reason"`
+	Status int       `json:"status"`
+	// Token and Role identify the caller when the token was valid
+	Token string `json:"token,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// AuthStats reports the loaded tokens and the denied requests
+type AuthStats struct {
+	TokensFile   string            `json:"tokens_file"`
+	Tokens       int               `json:"tokens"`
+	LoadedAt     time.Time         `json:"loaded_at"`
+	Reloads      uint64            `json:"reloads"`
+	ReloadErrors uint64            `json:"reload_errors"`
+	Denied       map[string]uint64 `json:"denied"`
+}
+
+// Authenticator checks the bearer token of every API request against the tokens
+// file, which is reloaded when it changes. A file that fails to load keeps the
+// previous tokens in force.
+type Authenticator struct {
+	path   string
+	logger Logger
+
+	// tokens maps the SHA-256 of each token to its entry, so lookups never compare secrets
+	tokens   atomic.Pointer[map[[sha256.Size]byte]APIToken]
+	loadedAt atomic.Pointer[time.Time]
+	modTime  time.Time
+	size     int64
+
+	auditMu sync.Mutex
+	audit   *os.File
+
+	reloads      atomic.Uint64
+	reloadErrors atomic.Uint64
+	deniedMu     sync.Mutex
+	denied       map[string]uint64
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// NewAuthenticator loads cfg.TokensFile and starts watching it
+func NewAuthenticator(cfg AuthConfig, logger Logger) (*Authenticator, error) {
+	a := &Authenticator{
+		path:   cfg.TokensFile,
+		logger: logger,
+		denied: map[string]uint64{denyMissingToken: 0, denyInvalidToken: 0, denyForbidden: 0},
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if _, err := a.reload(); err != nil {
+		logger.Errorf("failed to load API tokens: %v", err)
+		return nil, errors.New("failed to load API tokens: " + err.Error())
+	}
+	if cfg.AuditFile != "" {
+		if err := os.MkdirAll(filepath.Dir(cfg.AuditFile), 0o750); err != nil {
+			logger.Errorf("failed to create audit directory: %v", err)
+			return nil, errors.New("failed to create audit directory: " + err.Error())
+		}
+		f, err := os.OpenFile(cfg.AuditFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			logger.Errorf("failed to open audit file: %v", err)
+			return nil, errors.New("failed to open audit file: " + err.Error())
+		}
+		a.audit = f
+	}
+	go a.run()
+	return a, nil
+}
+
+// run reloads the tokens file whenever its modification time or size changes
+func (a *Authenticator) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(authReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := a.reload()
+			if err != nil {
+				a.reloadErrors.Add(1)
+				a.logger.Errorf("Failed to reload API tokens, keeping the previous ones: %v", err)
+			} else if changed {
+				a.reloads.Add(1)
+			}
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// reload reads the tokens file if it changed since the last load
+func (a *Authenticator) reload() (bool, error) {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return false, err
+	}
+	if a.tokens.Load() != nil && info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return false, nil
+	}
+	// Record the attempt, so a broken file is reported once rather than every interval
+	a.modTime, a.size = info.ModTime(), info.Size()
+
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return false, err
+	}
+	tokens, err := parseTokens(a.path, data)
+	if err != nil {
+		return false, err
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		a.logger.Warnf("API tokens file %s is world-readable", a.path)
+	}
+	if len(tokens) == 0 {
+		a.logger.Warnf("API tokens file %s has no tokens: every request is denied", a.path)
+	}
+	now := time.Now()
+	a.tokens.Store(&tokens)
+	a.loadedAt.Store(&now)
+	a.logger.Infof("Loaded %d API tokens from %s", len(tokens), a.path)
+	return true, nil
+}
+
+// parseTokens decodes and checks a tokens file, YAML or JSON (.json extension)
+func parseTokens(path string, data []byte) (map[[sha256.Size]byte]APIToken, error) {
+	var file tokensFile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&file); err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+	}
+
+	tokens := make(map[[sha256.Size]byte]APIToken, len(file.Tokens))
+	names := make(map[string]bool, len(file.Tokens))
+	for i, t := range file.Tokens {
+		field := fmt.Sprintf("%s: tokens[%d]", path, i)
+		if t.Name == "" {
+			return nil, errors.New(field + ".name: required")
+		}
+		if names[t.Name] {
+			return nil, errors.New(field + ".name: duplicate name " + t.Name)
+		}
+		names[t.Name] = true
+		if t.Role != RoleReader && t.Role != RoleAdmin {
+			return nil, errors.New(field + ".role: must be " + RoleReader + " or " + RoleAdmin + ", got " + fmt.Sprintf("%q", t.Role))
+		}
+
+		var sum [sha256.Size]byte
+		switch {
+		case t.Token != "" && t.SHA256 != "":
+			return nil, errors.New(field + ": set token or sha256, not both")
+		case t.Token != "":
+			if len(t.Token) < minTokenLength {
+				return nil, errors.New(field + ".token: must be at least " + fmt.Sprint(minTokenLength) + " characters")
+			}
+			sum = sha256.Sum256([]byte(t.Token))
+		case t.SHA256 != "":
+			raw, err := hex.DecodeString(t.SHA256)
+			if err != nil || len(raw) != sha256.Size {
+				return nil, errors.New(field + ".sha256: must be a hex SHA-256 digest")
+			}
+			copy(sum[:], raw)
+		default:
+			return nil, errors.New(field + ": token or sha256 required")
+		}
+		if _, exists := tokens[sum]; exists {
+			return nil, errors.New(field + ": same token as another entry")
+		}
+		// Only the digest is kept in memory
+		t.Token = ""
+		tokens[sum] = t
+	}
+	return tokens, nil
+}
+
+// requiredRole is the role a request needs: reading for GET and HEAD, admin otherwise
+func requiredRole(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return RoleReader
+	}
+	return RoleAdmin
+}
+
+// Middleware authenticates the Authorization: Bearer token of every request and
+// checks its role against the method, auditing the denied requests
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="ebpf-game"`)
+			a.deny(c, http.StatusUnauthorized, denyMissingToken, APIToken{}, "missing bearer token")
+			return
+		}
+		entry, ok := (*a.tokens.Load())[sha256.Sum256([]byte(token))]
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="ebpf-game", error="invalid_token"`)
+			a.deny(c, http.StatusUnauthorized, denyInvalidToken, APIToken{}, "invalid token")
+			return
+		}
+		if requiredRole(c.Request.Method) == RoleAdmin && entry.Role != RoleAdmin {
+			a.deny(c, http.StatusForbidden, denyForbidden, entry, "the "+entry.Role+" role cannot call "+c.Request.Method+" endpoints")
+			return
+		}
+		c.Set("token", entry.Name)
+		c.Next()
+	}
+}
+
+// deny aborts the request and audits it
+func (a *Authenticator) deny(c *gin.Context, status int, reason string, entry APIToken, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": message})
+
+	a.deniedMu.Lock()
+	a.denied[reason]++
+	a.deniedMu.Unlock()
+
+	audit := AuditEntry{
+		Time:   time.Now(),
+		Remote: c.ClientIP(),
+		Method: c.Request.Method,
+		Path:   c.Request.URL.Path,
+		Reason: reason,
+		Status: status,
+		Token:  entry.Name,
+		Role:   entry.Role,
+	}
+	if audit.Token != "" {
+		a.logger.Warnf("Denied %s %s from %s: %s (token %s, role %s)", audit.Method, audit.Path, audit.Remote, reason, audit.Token, audit.Role)
+	} else {
+		a.logger.Warnf("Denied %s %s from %s: %s", audit.Method, audit.Path, audit.Remote, reason)
+	}
+	if a.audit == nil {
+		return
+	}
+	line, _ := json.Marshal(audit)
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+	if _, err := a.audit.Write(append(line, '\n')); err != nil {
+		a.logger.Warnf("Failed to write audit entry: %v", err)
+	}
+}
+
+// Stats reports the loaded tokens and the denied requests by reason
+func (a *Authenticator) Stats() AuthStats {
+	stats := AuthStats{
+		TokensFile:   a.path,
+		Tokens:       len(*a.tokens.Load()),
+		LoadedAt:     *a.loadedAt.Load(),
+		Reloads:      a.reloads.Load(),
+		ReloadErrors: a.reloadErrors.Load(),
+		Denied:       make(map[string]uint64),
+	}
+	a.deniedMu.Lock()
+	defer a.deniedMu.Unlock()
+	for reason, n := range a.denied {
+		stats.Denied[reason] = n
+	}
+	return stats
+}
+
+// Close stops watching the tokens file and closes the audit file
+func (a *Authenticator) Close() error {
+	close(a.stopCh)
+	<-a.done
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+	if a.audit == nil {
+		return nil
+	}
+	return a.audit.Close()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testReadToken  = "read-token-0123456789"
+	testAdminToken = "admin-token-0123456789"
+)
+
+func sha256Hex(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTokens writes a tokens file with a read and an admin token into a new directory
+func writeTokens(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	data := "tokens:\n" +
+		"  - {name: grafana, role: read, token: " + testReadToken + "}\n" +
+		"  - {name: ops, role: admin, sha256: " + sha256Hex(testAdminToken) + "}\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseTokens(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		path    string
+		data    string
+		want    map[string]string // token -> role
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			path: "tokens.yaml",
+			data: "tokens:\n  - {name: a, role: read, token: " + testReadToken + "}\n  - {name: b, role: admin, sha256: " + sha256Hex(testAdminToken) + "}\n",
+			want: map[string]string{testReadToken: RoleReader, testAdminToken: RoleAdmin},
+		},
+		{
+			name: "json",
+			path: "tokens.JSON",
+			data: `{"tokens": [{"name": "a", "role": "admin", "token": "` + testAdminToken + `"}]}`,
+			want: map[string]string{testAdminToken: RoleAdmin},
+		},
+		{name: "empty file", path: "tokens.yaml", data: "", want: map[string]string{}},
+		{name: "unknown yaml field", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: read, token: " + testReadToken + ", expires: never}\n", wantErr: "expires"},
+		{name: "unknown json field", path: "tokens.json", data: `{"tokens": [], "version": 2}`, wantErr: "version"},
+		{name: "missing name", path: "tokens.yaml", data: "tokens:\n  - {role: read, token: " + testReadToken + "}\n", wantErr: "tokens[0].name: required"},
+		{name: "duplicate name", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: read, token: " + testReadToken + "}\n  - {name: a, role: admin, token: " + testAdminToken + "}\n", wantErr: "duplicate name a"},
+		{name: "bad role", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: root, token: " + testReadToken + "}\n", wantErr: `got "root"`},
+		{name: "token and sha256", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: read, token: " + testReadToken + ", sha256: " + sha256Hex(testReadToken) + "}\n", wantErr: "not both"},
+		{name: "short token", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: read, token: short}\n", wantErr: "at least 16 characters"},
+		{name: "bad sha256", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: read, sha256: abcd}\n", wantErr: "hex SHA-256 digest"},
+		{name: "no secret", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: read}\n", wantErr: "token or sha256 required"},
+		{name: "same token twice", path: "tokens.yaml", data: "tokens:\n  - {name: a, role: read, token: " + testReadToken + "}\n  - {name: b, role: admin, sha256: " + sha256Hex(testReadToken) + "}\n", wantErr: "tokens[1]: same token"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, err := parseTokens(tc.path, []byte(tc.data))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tokens) != len(tc.want) {
+				t.Fatalf("%d tokens, want %d", len(tokens), len(tc.want))
+			}
+			for token, role := range tc.want {
+				entry, ok := tokens[sha256.Sum256([]byte(token))]
+				if !ok || entry.Role != role || entry.Token != "" {
+					t.Errorf("token %s: entry %+v, want role %s without the token kept", token, entry, role)
+				}
+			}
+		})
+	}
+}
+
+func TestRequiredRole(t *testing.T) {
+	for method, want := range map[string]string{
+		http.MethodGet:    RoleReader,
+		http.MethodHead:   RoleReader,
+		http.MethodPost:   RoleAdmin,
+		http.MethodDelete: RoleAdmin,
+		http.MethodPut:    RoleAdmin,
+		http.MethodPatch:  RoleAdmin,
+	} {
+		if got := requiredRole(method); got != want {
+			t.Errorf("requiredRole(%s) = %s, want %s", method, got, want)
+		}
+	}
+}
+
+func TestAuthRolesByMethod(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.TokensFile = writeTokens(t)
+	cfg.Auth.AuditFile = filepath.Join(t.TempDir(), "audit", "denied.jsonl")
+	app, _ := newTestApplication(t, cfg)
+
+	for _, tc := range []struct {
+		name          string
+		method, path  string
+		authorization string
+		want          int
+	}{
+		{"no token", http.MethodGet, "/target_pids", "", http.StatusUnauthorized},
+		{"not bearer", http.MethodGet, "/target_pids", "Basic " + testAdminToken, http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/target_pids", "Bearer unknown-token-0123456789", http.StatusUnauthorized},
+		{"reader GET", http.MethodGet, "/target_pids", "Bearer " + testReadToken, http.StatusOK},
+		{"reader POST", http.MethodPost, "/add_pids", "Bearer " + testReadToken, http.StatusForbidden},
+		{"reader DELETE", http.MethodDelete, "/target_pids/900", "Bearer " + testReadToken, http.StatusForbidden},
+		{"admin GET", http.MethodGet, "/target_pids", "Bearer " + testAdminToken, http.StatusOK},
+		{"admin POST", http.MethodPost, "/add_pids", "bearer " + testAdminToken, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"pids": [900]}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			app.apiServer.GetRouter().ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+
+	denied := app.auth.Stats().Denied
+	if denied[denyMissingToken] != 2 || denied[denyInvalidToken] != 1 || denied[denyForbidden] != 2 {
+		t.Errorf("denied counts %v", denied)
+	}
+}
+
+func TestAuthAuditIgnoresForwardedFor(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.TokensFile = writeTokens(t)
+	cfg.Auth.AuditFile = filepath.Join(t.TempDir(), "denied.jsonl")
+	app, _ := newTestApplication(t, cfg)
+
+	req := httptest.NewRequest(http.MethodPost, "/clear_pid_list", nil)
+	req.RemoteAddr = "192.0.2.7:40000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	req.Header.Set("X-Real-IP", "203.0.113.2")
+	req.Header.Set("Authorization", "Bearer "+testReadToken)
+	rec := httptest.NewRecorder()
+	app.apiServer.GetRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", rec.Code)
+	}
+
+	data, err := os.ReadFile(cfg.Auth.AuditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("audit file %q: %v", data, err)
+	}
+	if entry.Remote != "192.0.2.7" || entry.Token != "grafana" || entry.Reason != denyForbidden {
+		t.Errorf("audit entry %+v, want remote 192.0.2.7, token grafana, forbidden", entry)
+	}
+}
+
+func TestAuthReloadKeepsTokensOnError(t *testing.T) {
+	path := writeTokens(t)
+	logger, err := NewLogger(LogConfig{Kind: LoggerStdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthenticator(AuthConfig{TokensFile: path}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	known := func(token string) bool {
+		_, ok := (*a.tokens.Load())[sha256.Sum256([]byte(token))]
+		return ok
+	}
+
+	for _, bad := range []string{
+		"tokens:\n  - {name: a, role: read, token: short}\n",
+		"tokens: [",
+		"tokens:\n  - {name: a, role: superuser, token: " + testReadToken + "}\n",
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if changed, err := a.reload(); err == nil || changed {
+			t.Fatalf("reload of %q: changed %v, error %v", bad, changed, err)
+		}
+		if !known(testReadToken) || !known(testAdminToken) || a.Stats().Tokens != 2 {
+			t.Fatalf("previous tokens not kept after loading %q", bad)
+		}
+	}
+
+	// Not reported again until the file changes
+	if changed, err := a.reload(); err != nil || changed {
+		t.Errorf("reload of an unchanged bad file: changed %v, error %v", changed, err)
+	}
+
+	// A good file replaces the tokens
+	rotated := "tokens:\n  - {name: ops, role: admin, token: rotated-token-0123456789}\n"
+	if err := os.WriteFile(path, []byte(rotated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := a.reload(); err != nil || !changed {
+		t.Fatalf("reload of a good file: changed %v, error %v", changed, err)
+	}
+	if known(testReadToken) || known(testAdminToken) || !known("rotated-token-0123456789") {
+		t.Error("tokens not replaced by the good file")
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// captureMagic starts every capture file and is followed by the format version byte
+	captureMagic   = "EBPFCAP"
+	captureVersion = 1
+	// captureRecordHeader is the kind (u8), time (Unix ns, u64) and payload length (u32) of a record
+	captureRecordHeader = 13
+	// captureMaxRecord bounds a payload, so a corrupt length is not taken for a record
+	captureMaxRecord     = 1 << 20
+	captureFlushInterval = time.Second
+	defaultReplaySpeed   = 1
+)
+
+// Capture record kinds
+const (
+	// captureSample is a raw struct data_t sample as read from the ring or perf buffer
+	captureSample = 1
+	// captureLost is a number of samples the perf buffer lost (u64)
+	captureLost = 2
+	// captureCommand is a target or mode change applied by the controller (JSON CaptureCommand)
+	captureCommand = 3
+	// captureState is the targets and mode when recording started (JSON PersistedState)
+	captureState = 4
+)
+
+var errCaptureTruncated = errors.New("capture file ends with a truncated record")
+
+// CaptureConfig selects the record or replay mode
+type CaptureConfig struct {
+	// Record, when set, is the capture file the raw events and target changes are written to
+	Record string `json:"record" yaml:"record"`
+	// Replay, when set, is a capture file replayed instead of reading the kernel
+	Replay string `json:"replay" yaml:"replay"`
+	// Speed of the replay: 1 keeps the recorded timing, 10 is ten times faster, 0 is as fast as possible
+	Speed float64 `json:"speed" yaml:"speed"`
+}
+
+// CaptureHeader is the JSON header following the magic and version of a capture file
+type CaptureHeader struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	Hostname  string    `json:"hostname"`
+	Transport string    `json:"transport"`
+	// DataSize is sizeof(struct data_t) of the recording build
+	DataSize int `json:"data_size"`
+}
+
+// CaptureCommand is a MonitorCommand as recorded in a capture file
+type CaptureCommand struct {
+	Kind           string           `json:"kind"`
+	PID            uint32           `json:"pid,omitempty"`
+	PIDs           []uint32         `json:"pids,omitempty"`
+	FollowChildren bool             `json:"follow_children,omitempty"`
+	Comm           string           `json:"comm,omitempty"`
+	Comms          []string         `json:"comms,omitempty"`
+	Cgroup         *CgroupTarget    `json:"cgroup,omitempty"`
+	CgroupIDs      []uint64         `json:"cgroup_ids,omitempty"`
+	PrintAll       bool             `json:"print_all,omitempty"`
+	Syscall        string           `json:"syscall,omitempty"`
+	RateLimit      *RateLimitConfig `json:"rate_limit,omitempty"`
+}
+
+// captureCommandOf converts cmd, applied with outcome, for recording. Commands the
+// controller sends itself in reaction to process events are not recorded: replaying
+// the events repeats them. A replay cannot tell threads from processes though, so a
+// child dropped by CommandTrackChild is recorded as its removal.
+func captureCommandOf(cmd MonitorCommand, outcome CommandOutcome) (CaptureCommand, bool) {
+	if cmd.Kind == CommandTrackChild && len(outcome.Removed) > 0 {
+		return CaptureCommand{Kind: CommandRemovePID.String(), PIDs: outcome.Removed}, true
+	}
+	if cmd.Kind == CommandTrackChild || cmd.Kind == CommandTargetExited {
+		return CaptureCommand{}, false
+	}
+	cc := CaptureCommand{
+		Kind:           cmd.Kind.String(),
+		PID:            cmd.PID,
+		PIDs:           cmd.PIDs,
+		FollowChildren: cmd.FollowChildren,
+		Comm:           cmd.Comm,
+		Comms:          cmd.Comms,
+		CgroupIDs:      cmd.CgroupIDs,
+		PrintAll:       cmd.PrintAll,
+		Syscall:        cmd.Syscall,
+	}
+	switch cmd.Kind {
+	case CommandAddCgroup, CommandExcludeCgroup:
+		cgroup := cmd.Cgroup
+		cc.Cgroup = &cgroup
+	case CommandSetRateLimit:
+		limit := cmd.RateLimit
+		cc.RateLimit = &limit
+	}
+	return cc, true
+}
+
+// command converts a recorded command back
+func (cc CaptureCommand) command() (MonitorCommand, bool) {
+	kind, ok := parseCommandKind(cc.Kind)
+	if !ok {
+		return MonitorCommand{}, false
+	}
+	cmd := MonitorCommand{
+		Kind:           kind,
+		PID:            cc.PID,
+		PIDs:           cc.PIDs,
+		FollowChildren: cc.FollowChildren,
+		Comm:           cc.Comm,
+		Comms:          cc.Comms,
+		CgroupIDs:      cc.CgroupIDs,
+		PrintAll:       cc.PrintAll,
+		Syscall:        cc.Syscall,
+	}
+	if cc.Cgroup != nil {
+		cmd.Cgroup = *cc.Cgroup
+	}
+	if cc.RateLimit != nil {
+		cmd.RateLimit = *cc.RateLimit
+	}
+	return cmd, true
+}
+
+// parseCommandKind is the reverse of CommandKind.String
+func parseCommandKind(name string) (CommandKind, bool) {
+	for kind := CommandAddPID; kind <= CommandSetRateLimit; kind++ {
+		if kind.String() == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+// captureStateCommands rebuilds a recorded state. Unlike restoreCommands nothing is
+// checked against this host: the PIDs and cgroups are those of the recording.
+// Inherited targets only get a CommandTrackChild, for the PID list: their entries
+// are restored in the target map by the replay itself, as only the kernel adds them.
+func captureStateCommands(state PersistedState) []MonitorCommand {
+	var cmds []MonitorCommand
+	for _, t := range state.Targets {
+		if t.Inherited {
+			cmds = append(cmds, MonitorCommand{Kind: CommandTrackChild, PID: t.PID, ParentPID: t.ParentPID})
+			continue
+		}
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddPID, PID: t.PID, FollowChildren: t.FollowChildren})
+	}
+	for _, p := range state.Exclusions.PIDs {
+		cmds = append(cmds, MonitorCommand{Kind: CommandExcludePID, PID: p.PID})
+	}
+	for _, comm := range state.Comms {
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddComm, Comm: comm})
+	}
+	for _, comm := range state.Exclusions.Comms {
+		cmds = append(cmds, MonitorCommand{Kind: CommandExcludeComm, Comm: comm})
+	}
+	for _, cg := range state.Cgroups {
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddCgroup, Cgroup: cg})
+	}
+	for _, cg := range state.Exclusions.Cgroups {
+		cmds = append(cmds, MonitorCommand{Kind: CommandExcludeCgroup, Cgroup: cg})
+	}
+	if state.RateLimit.Enabled() {
+		cmds = append(cmds, MonitorCommand{Kind: CommandSetRateLimit, RateLimit: state.RateLimit})
+	}
+	// Last: adding targets turns print_all off
+	return append(cmds, MonitorCommand{Kind: CommandSetPrintAll, PrintAll: state.PrintAll})
+}
+
+// CaptureWriter appends records to a capture file. It is shared by the event
+// reader and the controller. The first write error is logged and stops the recording.
+type CaptureWriter struct {
+	path   string
+	logger Logger
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	records uint64
+	failed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// CreateCapture creates or truncates the capture file at path and writes its header
+func CreateCapture(path string, header CaptureHeader, logger Logger) (*CaptureWriter, error) {
+	header.Version = captureVersion
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		logger.Errorf("failed to create capture file: %v", err)
+		return nil, errors.New("failed to create capture file: " + err.Error())
+	}
+
+	w := bufio.NewWriterSize(f, 256<<10)
+	w.WriteString(captureMagic)
+	w.WriteByte(captureVersion)
+	w.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(headerJSON))))
+	w.Write(headerJSON)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		logger.Errorf("failed to write capture header: %v", err)
+		return nil, errors.New("failed to write capture header: " + err.Error())
+	}
+
+	c := &CaptureWriter{
+		path:   path,
+		logger: logger,
+		f:      f,
+		w:      w,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// WriteSample records a raw sample before it is decoded
+func (c *CaptureWriter) WriteSample(sample []byte) {
+	c.writeRecord(captureSample, sample)
+}
+
+// WriteLost records samples lost by the perf buffer
+func (c *CaptureWriter) WriteLost(lost uint64) {
+	c.writeRecord(captureLost, binary.LittleEndian.AppendUint64(nil, lost))
+}
+
+// WriteCommand records a command the controller applied successfully, with its outcome
+func (c *CaptureWriter) WriteCommand(cmd MonitorCommand, outcome CommandOutcome) {
+	cc, ok := captureCommandOf(cmd, outcome)
+	if !ok {
+		return
+	}
+	payload, err := json.Marshal(cc)
+	if err != nil {
+		return
+	}
+	c.writeRecord(captureCommand, payload)
+}
+
+// WriteState records the targets and mode the recording starts from
+func (c *CaptureWriter) WriteState(state PersistedState) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	c.writeRecord(captureState, payload)
+}
+
+func (c *CaptureWriter) writeRecord(kind byte, payload []byte) {
+	var header [captureRecordHeader]byte
+	header[0] = kind
+	binary.LittleEndian.PutUint64(header[1:9], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(header[9:13], uint32(len(payload)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil || c.failed {
+		return
+	}
+	if _, err := c.w.Write(header[:]); err == nil {
+		_, err = c.w.Write(payload)
+		if err == nil {
+			c.records++
+			return
+		}
+	}
+	c.fail(c.w.Flush())
+}
+
+// fail logs the first write error; callers must hold c.mu
+func (c *CaptureWriter) fail(err error) {
+	if err == nil || c.failed {
+		return
+	}
+	c.failed = true
+	c.logger.Errorf("Recording to %s stopped: %v", c.path, err)
+}
+
+// run flushes the records every second, bounding what a crash loses
+func (c *CaptureWriter) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(captureFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.f != nil && !c.failed {
+				c.fail(c.w.Flush())
+			}
+			c.mu.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Records is the number of records written so far
+func (c *CaptureWriter) Records() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.records
+}
+
+// Close flushes and closes the file; later records are dropped
+func (c *CaptureWriter) Close() error {
+	close(c.stop)
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	err := c.w.Flush()
+	if closeErr := c.f.Close(); err == nil {
+		err = closeErr
+	}
+	c.f = nil
+	return err
+}
+
+// CaptureRecord is one record read back from a capture file
+type CaptureRecord struct {
+	Kind    byte
+	Time    time.Time
+	Payload []byte
+}
+
+// CaptureReader reads a capture file record by record
+type CaptureReader struct {
+	Header CaptureHeader
+	f      *os.File
+	r      *bufio.Reader
+}
+
+// OpenCapture opens a capture file and checks its magic and version
+func OpenCapture(path string) (*CaptureReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(f, 256<<10)
+	prefix := make([]byte, len(captureMagic)+5)
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix[:len(captureMagic)]) != captureMagic {
+		f.Close()
+		return nil, errors.New(path + " is not a capture file")
+	}
+	if version := prefix[len(captureMagic)]; version != captureVersion {
+		f.Close()
+		return nil, errors.New(path + ": unsupported capture version " + strconv.Itoa(int(version)))
+	}
+	headerJSON := make([]byte, binary.LittleEndian.Uint32(prefix[len(captureMagic)+1:]))
+	if len(headerJSON) > captureMaxRecord {
+		f.Close()
+		return nil, errors.New(path + ": corrupt capture header")
+	}
+	if _, err := io.ReadFull(r, headerJSON); err != nil {
+		f.Close()
+		return nil, errors.New(path + ": truncated capture header")
+	}
+
+	reader := &CaptureReader{f: f, r: r}
+	if err := json.Unmarshal(headerJSON, &reader.Header); err != nil {
+		f.Close()
+		return nil, errors.New(path + ": corrupt capture header: " + err.Error())
+	}
+	return reader, nil
+}
+
+// Next returns the next record: io.EOF at the end of the file, errCaptureTruncated
+// when the recording was interrupted mid-record
+func (r *CaptureReader) Next() (CaptureRecord, error) {
+	var header [captureRecordHeader]byte
+	if n, err := io.ReadFull(r.r, header[:]); err != nil {
+		if n == 0 && err == io.EOF {
+			return CaptureRecord{}, io.EOF
+		}
+		return CaptureRecord{}, errCaptureTruncated
+	}
+	size := binary.LittleEndian.Uint32(header[9:13])
+	if size > captureMaxRecord {
+		return CaptureRecord{}, errCaptureTruncated
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return CaptureRecord{}, errCaptureTruncated
+	}
+	return CaptureRecord{
+		Kind:    header[0],
+		Time:    time.Unix(0, int64(binary.LittleEndian.Uint64(header[1:9]))),
+		Payload: payload,
+	}, nil
+}
+
+func (r *CaptureReader) Close() error {
+	return r.f.Close()
+}
+
+// CaptureReport summarizes a capture file by PID and syscall
+type CaptureReport struct {
+	Header       CaptureHeader   `json:"header"`
+	Start        time.Time       `json:"start"`
+	End          time.Time       `json:"end"`
+	Events       uint64          `json:"events"`
+	DecodeErrors uint64          `json:"decode_errors"`
+	LostSamples  uint64          `json:"lost_samples"`
+	Truncated    bool            `json:"truncated"`
+	Commands     []ReportCommand `json:"commands"`
+	Syscalls     []ReportSyscall `json:"syscalls"`
+	Processes    []ReportProcess `json:"processes"`
+	byPID        map[uint32]*ReportProcess
+	bySyscall    map[string]*ReportSyscall
+}
+
+// ReportCommand is a recorded target or mode change, or the initial state
+type ReportCommand struct {
+	Time    time.Time       `json:"time"`
+	Kind    string          `json:"kind"`
+	Command json.RawMessage `json:"command"`
+}
+
+// ReportSyscall counts the events of one syscall or process event. Calls weighs
+// each event by its sample rate, estimating the calls made under sampling.
+type ReportSyscall struct {
+	Syscall string `json:"syscall"`
+	Events  uint64 `json:"events"`
+	Calls   uint64 `json:"calls"`
+	// Bytes is the sum of the byte counts requested, where the syscall has one
+	Bytes uint64 `json:"bytes"`
+}
+
+// ReportProcess counts the events of one PID, by syscall
+type ReportProcess struct {
+	PID      uint32          `json:"pid"`
+	Comm     string          `json:"comm"`
+	Events   uint64          `json:"events"`
+	Calls    uint64          `json:"calls"`
+	Syscalls []ReportSyscall `json:"syscalls"`
+	syscalls map[string]*ReportSyscall
+}
+
+// SummarizeCapture reads the capture at path once, decoding every sample like the
+// eBPF reader, and counts the events per PID and syscall, busiest first
+func SummarizeCapture(path string) (CaptureReport, error) {
+	reader, err := OpenCapture(path)
+	if err != nil {
+		return CaptureReport{}, err
+	}
+	defer reader.Close()
+
+	report := CaptureReport{
+		Header:    reader.Header,
+		Commands:  make([]ReportCommand, 0),
+		byPID:     make(map[uint32]*ReportProcess),
+		bySyscall: make(map[string]*ReportSyscall),
+	}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.Truncated = true
+			break
+		}
+		if report.Start.IsZero() {
+			report.Start = record.Time
+		}
+		report.End = record.Time
+
+		switch record.Kind {
+		case captureSample:
+			event, ok := decodeData(record.Payload)
+			if !ok {
+				report.DecodeErrors++
+				continue
+			}
+			report.add(event)
+		case captureLost:
+			if len(record.Payload) == 8 {
+				report.LostSamples += binary.LittleEndian.Uint64(record.Payload)
+			}
+		case captureCommand, captureState:
+			kind := "state"
+			if record.Kind == captureCommand {
+				var cc CaptureCommand
+				json.Unmarshal(record.Payload, &cc)
+				kind = cc.Kind
+			}
+			report.Commands = append(report.Commands, ReportCommand{Time: record.Time, Kind: kind, Command: record.Payload})
+		}
+	}
+	report.finish()
+	return report, nil
+}
+
+func (r *CaptureReport) add(event Data) {
+	calls := uint64(event.SampleRate)
+	r.Events++
+
+	p, ok := r.byPID[event.Pid]
+	if !ok {
+		p = &ReportProcess{PID: event.Pid, syscalls: make(map[string]*ReportSyscall)}
+		r.byPID[event.Pid] = p
+	}
+	// The last name seen, in case of exec
+	p.Comm = event.Comm
+	p.Events++
+	p.Calls += calls
+
+	for _, counts := range []map[string]*ReportSyscall{r.bySyscall, p.syscalls} {
+		s, ok := counts[event.Syscall]
+		if !ok {
+			s = &ReportSyscall{Syscall: event.Syscall}
+			counts[event.Syscall] = s
+		}
+		s.Events++
+		s.Calls += calls
+		s.Bytes += event.Count
+	}
+}
+
+// finish sorts the counters into the exported slices, busiest first
+func (r *CaptureReport) finish() {
+	r.Syscalls = sortedReportSyscalls(r.bySyscall)
+	r.Processes = make([]ReportProcess, 0, len(r.byPID))
+	for _, p := range r.byPID {
+		p.Syscalls = sortedReportSyscalls(p.syscalls)
+		r.Processes = append(r.Processes, *p)
+	}
+	sort.Slice(r.Processes, func(i, j int) bool {
+		if r.Processes[i].Events != r.Processes[j].Events {
+			return r.Processes[i].Events > r.Processes[j].Events
+		}
+		return r.Processes[i].PID < r.Processes[j].PID
+	})
+}
+
+func sortedReportSyscalls(counts map[string]*ReportSyscall) []ReportSyscall {
+	sorted := make([]ReportSyscall, 0, len(counts))
+	for _, s := range counts {
+		sorted = append(sorted, *s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Events != sorted[j].Events {
+			return sorted[i].Events > sorted[j].Events
+		}
+		return sorted[i].Syscall < sorted[j].Syscall
+	})
+	return sorted
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// cgroupRoot is where the cgroup v2 hierarchy is mounted
+	cgroupRoot = "/sys/fs/cgroup"
+	// maxCgroupDepth is MAX_CGROUP_DEPTH in ebpf_probe.c
+	maxCgroupDepth = 16
+)
+
+// CgroupTarget is one entry of the target_cgroups map.
+// Path is only known for targets added through the API of this process.
+type CgroupTarget struct {
+	ID          uint64 `json:"id" yaml:"id"`
+	Path        string `json:"path,omitempty" yaml:"path"`
+	Descendants bool   `json:"descendants" yaml:"descendants"`
+}
+
+// errNoCgroupAncestors is returned when descendants are requested on a kernel
+// without bpf_get_current_ancestor_cgroup_id for tracing programs
+var errNoCgroupAncestors = errors.New("kernel does not support matching descendant cgroups (bpf_get_current_ancestor_cgroup_id)")
+
+// resolveCgroup turns a cgroup v2 path into the ID reported by bpf_get_current_cgroup_id,
+// which is the inode number of the cgroup directory.
+// Paths may be given under /sys/fs/cgroup or relative to it, as in /proc/<pid>/cgroup
+// (e.g. /system.slice/nginx.service).
+func resolveCgroup(path string) (string, uint64, error) {
+	if path == "" {
+		return "", 0, errors.New("cgroup path must not be empty")
+	}
+	full := filepath.Clean(path)
+	if full != cgroupRoot && !strings.HasPrefix(full, cgroupRoot+"/") {
+		full = filepath.Join(cgroupRoot, full)
+	}
+
+	var fs unix.Statfs_t
+	if err := unix.Statfs(full, &fs); err != nil {
+		return "", 0, errors.New("cannot access cgroup " + full + ": " + err.Error())
+	}
+	if fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return "", 0, errors.New(full + " is not on a cgroup v2 filesystem")
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(full, &st); err != nil {
+		return "", 0, errors.New("cannot access cgroup " + full + ": " + err.Error())
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return "", 0, errors.New(full + " is not a cgroup directory")
+	}
+	return full, st.Ino, nil
+}
+
+// configureCgroupTargeting rewrites the cgroup_ancestors constant before load:
+// descendant matching is compiled out on kernels lacking the helper, so the
+// programs still load there. It reports whether descendants can be matched.
+func configureCgroupTargeting(spec *ebpf.CollectionSpec, logger Logger) (bool, error) {
+	supported := features.HaveProgramHelper(ebpf.TracePoint, asm.FnGetCurrentAncestorCgroupId) == nil
+	if !supported {
+		logger.Warnf("bpf_get_current_ancestor_cgroup_id unavailable: cgroup targets match their own cgroup only")
+	}
+
+	value := uint32(0)
+	if supported {
+		value = 1
+	}
+	if err := spec.RewriteConstants(map[string]interface{}{"cgroup_ancestors": value}); err != nil {
+		return false, err
+	}
+	return supported, nil
+}
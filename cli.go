@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runSubcommand runs the offline subcommands, which work on files and need neither
+// root nor the monitor configuration. ok is false when args name none of them.
+func runSubcommand(args []string) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "journal":
+		return runJournal(args[1:]), true
+	case "report":
+		return runReport(args[1:]), true
+	}
+	return 0, false
+}
+
+// runJournal reads the on-disk journal:
+//
+//	ebpf-game journal query [-dir DIR] [-since T] [-until T] [-pid N] [-type T] [-comm C] [-limit N] [-cursor N]
+//	ebpf-game journal stats [-dir DIR]
+//
+// query prints the matching events as JSON lines, oldest first, and a summary with
+// the cursor to continue from on stderr.
+func runJournal(args []string) int {
+	if len(args) == 0 || (args[0] != "query" && args[0] != "stats") {
+		fmt.Fprintln(os.Stderr, "usage: ebpf-game journal query|stats [flags]")
+		return 2
+	}
+	fs := flag.NewFlagSet("ebpf-game journal "+args[0], flag.ContinueOnError)
+	dir := fs.String("dir", defaultJournalDir, "journal directory")
+	since := fs.String("since", "", "RFC 3339 time or duration before now, e.g. 1h")
+	until := fs.String("until", "", "RFC 3339 time or duration before now")
+	pid := fs.Uint("pid", 0, "only events of this PID")
+	eventType := fs.String("type", "", "only events of this syscall, process event or event type ID")
+	comm := fs.String("comm", "", "only events of this task name")
+	limit := fs.Int("limit", 0, "stop after this many events, 0 for all")
+	cursor := fs.Uint64("cursor", 0, "first sequence number to read, as printed by a previous query")
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	journal, err := OpenJournalReader(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if args[0] == "stats" {
+		stats, err := journal.Stats()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+
+	query := HistoryQuery{Filter: EventFilter{PID: uint32(*pid), Comm: *comm}, Cursor: *cursor}
+	if *eventType != "" {
+		t, ok := parseEventType(*eventType)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "-type must be a syscall name, process event name or event type ID")
+			return 2
+		}
+		query.Filter.EventType = t
+	}
+	now := time.Now()
+	for _, p := range []struct {
+		name  string
+		value string
+		dst   *time.Time
+	}{{"since", *since, &query.Since}, {"until", *until, &query.Until}} {
+		if p.value == "" {
+			continue
+		}
+		t, ok := parseTimeBound(p.value, now)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "-"+p.name+" must be an RFC 3339 time or a duration such as 5m")
+			return 2
+		}
+		*p.dst = t
+	}
+
+	// Page through the journal so memory stays bounded whatever the range
+	enc := json.NewEncoder(os.Stdout)
+	printed := 0
+	gap := false
+	for {
+		query.Limit = maxHistoryPage
+		if *limit > 0 && *limit-printed < query.Limit {
+			query.Limit = *limit - printed
+		}
+		page, err := journal.Query(query)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		gap = gap || page.Gap
+		for _, event := range page.Events {
+			if err := enc.Encode(event); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		printed += len(page.Events)
+		query.Cursor = page.NextCursor
+		if !page.HasMore || (*limit > 0 && printed >= *limit) {
+			break
+		}
+	}
+
+	if gap {
+		fmt.Fprintln(os.Stderr, "warning: part of the range was already removed by retention")
+	}
+	fmt.Fprintf(os.Stderr, "%d events, next cursor %d\n", printed, query.Cursor)
+	return 0
+}
+
+// runReport summarizes a capture file by PID and syscall:
+//
+//	ebpf-game report [-top N] [-json] CAPTURE
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("ebpf-game report", flag.ContinueOnError)
+	top := fs.Int("top", 20, "show the N busiest PIDs and syscalls, 0 for all")
+	asJSON := fs.Bool("json", false, "print the whole report as JSON")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ebpf-game report [-top N] [-json] CAPTURE")
+		return 2
+	}
+
+	report, err := SummarizeCapture(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+
+	h := report.Header
+	fmt.Printf("Capture %s: recorded on %s over %s, version %d\n", fs.Arg(0), h.Hostname, h.Transport, h.Version)
+	if !report.Start.IsZero() {
+		fmt.Printf("Span: %s to %s (%s)\n", report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339), report.End.Sub(report.Start).Round(time.Millisecond))
+	}
+	fmt.Printf("Events: %d, decode errors: %d, lost samples: %d, commands: %d\n", report.Events, report.DecodeErrors, report.LostSamples, len(report.Commands))
+	if report.Truncated {
+		fmt.Println("warning: the capture ends with a torn record, it was not closed cleanly")
+	}
+
+	if len(report.Commands) > 0 {
+		fmt.Println("\nCommands:")
+		for _, c := range report.Commands {
+			fmt.Printf("  +%-12s %-14s %s\n", c.Time.Sub(report.Start).Round(time.Millisecond), c.Kind, c.Command)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nPID\tCOMM\tEVENTS\tCALLS\tTOP SYSCALLS")
+	for i, p := range report.Processes {
+		if *top > 0 && i >= *top {
+			fmt.Fprintf(w, "...\t%d more\n", len(report.Processes)-i)
+			break
+		}
+		names := ""
+		for j, sc := range p.Syscalls {
+			if j == 3 {
+				names += " ..."
+				break
+			}
+			if j > 0 {
+				names += ", "
+			}
+			names += fmt.Sprintf("%s %d", sc.Syscall, sc.Calls)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%s\n", p.PID, p.Comm, p.Events, p.Calls, names)
+	}
+	fmt.Fprintln(w, "\nSYSCALL\tEVENTS\tCALLS\tBYTES")
+	for i, sc := range report.Syscalls {
+		if *top > 0 && i >= *top {
+			fmt.Fprintf(w, "...\t%d more\n", len(report.Syscalls)-i)
+			break
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", sc.Syscall, sc.Events, sc.Calls, sc.Bytes)
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
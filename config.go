@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes every environment variable read by LoadConfig
+const envPrefix = "EBPF_GAME_"
+
+// Config holds every runtime setting. LoadConfig builds it with this precedence,
+// lowest first: DefaultConfig, the config file, EBPF_GAME_* environment variables,
+// command-line flags.
+type Config struct {
+	// Listen is the API server address, host:port (":8080" listens on every interface)
+	Listen string     `json:"listen" yaml:"listen"`
+	Auth   AuthConfig `json:"auth" yaml:"auth"`
+	// StateFile is where targets are saved and restored from; empty disables it
+	StateFile string          `json:"state_file" yaml:"state_file"`
+	Log       LogConfig       `json:"log" yaml:"log"`
+	Probe     ProbeOptions    `json:"probe" yaml:"probe"`
+	Sinks     []SinkConfig    `json:"sinks" yaml:"sinks"`
+	Exclude   ExclusionConfig `json:"exclude" yaml:"exclude"`
+	Targets   TargetConfig    `json:"targets" yaml:"targets"`
+	History   HistoryConfig   `json:"history" yaml:"history"`
+	Journal   JournalConfig   `json:"journal" yaml:"journal"`
+	Capture   CaptureConfig   `json:"capture" yaml:"capture"`
+	Metrics   MetricsConfig   `json:"metrics" yaml:"metrics"`
+	// RateLimit, when it sets any limit, replaces the one restored from the state file
+	RateLimit RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+}
+
+// LogConfig describes the operational logger
+type LogConfig struct {
+	// Kind is LoggerStdout, LoggerFile or LoggerBoth
+	Kind string `json:"kind" yaml:"kind"`
+	// Path of the rotating log file (file and both)
+	Path       string `json:"path" yaml:"path"`
+	MaxSizeMB  int    `json:"max_size_mb" yaml:"max_size_mb"`
+	MaxBackups int    `json:"max_backups" yaml:"max_backups"`
+	MaxAgeDays int    `json:"max_age_days" yaml:"max_age_days"`
+	Compress   bool   `json:"compress" yaml:"compress"`
+	Debug      bool   `json:"debug" yaml:"debug"`
+}
+
+// TargetConfig lists the targets added at startup, after the state file is restored
+type TargetConfig struct {
+	PIDs []uint32 `json:"pids" yaml:"pids"`
+	// FollowChildren applies to PIDs
+	FollowChildren bool           `json:"follow_children" yaml:"follow_children"`
+	Comms          []string       `json:"comms" yaml:"comms"`
+	Cgroups        []CgroupTarget `json:"cgroups" yaml:"cgroups"`
+	// PrintAll switches to print_all mode once the targets are added
+	PrintAll bool `json:"print_all" yaml:"print_all"`
+}
+
+// DefaultConfig returns the settings used when nothing overrides them
+func DefaultConfig() Config {
+	return Config{
+		Listen:    ":8080",
+		StateFile: "/var/lib/ebpf-game/state.json",
+		Log: LogConfig{
+			Kind:       LoggerBoth,
+			Path:       defaultLogPath,
+			MaxSizeMB:  10,
+			MaxBackups: 5,
+			MaxAgeDays: 7,
+		},
+		Probe: ProbeOptions{
+			RingBufferSize: defaultRingBufferSize,
+			PerfBufferSize: defaultPerfBufferSize,
+			Syscalls:       []string{"read", "write"},
+			PinPath:        "/sys/fs/bpf/ebpf-game",
+		},
+		Sinks: []SinkConfig{
+			{Kind: SinkLogger},
+			{Kind: SinkRing, Capacity: defaultRingCapacity},
+		},
+		History: HistoryConfig{Capacity: defaultHistoryCapacity},
+		Journal: JournalConfig{
+			SegmentSizeMB: defaultJournalSegmentSizeMB,
+			MaxSizeMB:     defaultJournalMaxSizeMB,
+			MaxAgeHours:   defaultJournalMaxAgeHours,
+		},
+		Metrics: MetricsConfig{MaxTargets: defaultMetricsMaxTargets},
+		Capture: CaptureConfig{Speed: defaultReplaySpeed},
+	}
+}
+
+// setting is one value that can be set from the environment and the command line
+type setting struct {
+	name   string // flag name; the variable is EBPF_GAME_ + upper-cased name with '-' -> '_'
+	usage  string
+	isBool bool
+	apply  func(cfg *Config, value string) error
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+var settings = []setting{
+	{name: "listen", usage: "API listen address, host:port", apply: func(cfg *Config, v string) error {
+		cfg.Listen = v
+		return nil
+	}},
+	{name: "auth-tokens-file", usage: "API tokens file (YAML or JSON), empty leaves the API open", apply: func(cfg *Config, v string) error {
+		cfg.Auth.TokensFile = v
+		return nil
+	}},
+	{name: "auth-audit-file", usage: "file receiving denied API requests as JSON lines", apply: func(cfg *Config, v string) error {
+		cfg.Auth.AuditFile = v
+		return nil
+	}},
+	{name: "state-file", usage: "state file path, empty to disable", apply: func(cfg *Config, v string) error {
+		cfg.StateFile = v
+		return nil
+	}},
+	{name: "log-kind", usage: "logger: stdout, file or both", apply: func(cfg *Config, v string) error {
+		cfg.Log.Kind = v
+		return nil
+	}},
+	{name: "log-path", usage: "rotating log file path", apply: func(cfg *Config, v string) error {
+		cfg.Log.Path = v
+		return nil
+	}},
+	{name: "log-max-size-mb", usage: "log file size before rotation, in MB", apply: intSetting(func(cfg *Config) *int { return &cfg.Log.MaxSizeMB })},
+	{name: "log-max-backups", usage: "rotated log files kept", apply: intSetting(func(cfg *Config) *int { return &cfg.Log.MaxBackups })},
+	{name: "log-max-age-days", usage: "days rotated log files are kept", apply: intSetting(func(cfg *Config) *int { return &cfg.Log.MaxAgeDays })},
+	{name: "log-compress", usage: "gzip rotated log files", isBool: true, apply: boolSetting(func(cfg *Config) *bool { return &cfg.Log.Compress })},
+	{name: "debug", usage: "enable debug logs", isBool: true, apply: boolSetting(func(cfg *Config) *bool { return &cfg.Log.Debug })},
+	{name: "transport", usage: "event transport: ringbuf or perf, empty picks the best", apply: func(cfg *Config, v string) error {
+		cfg.Probe.Transport = v
+		return nil
+	}},
+	{name: "attach-mode", usage: "attach mechanism: tracepoint, fentry or kprobe, empty picks the best", apply: func(cfg *Config, v string) error {
+		cfg.Probe.AttachMode = v
+		return nil
+	}},
+	{name: "ringbuf-size", usage: "ring buffer size in bytes, shared by all CPUs", apply: intSetting(func(cfg *Config) *int { return &cfg.Probe.RingBufferSize })},
+	{name: "perfbuf-size", usage: "perf buffer size in bytes, per CPU", apply: intSetting(func(cfg *Config) *int { return &cfg.Probe.PerfBufferSize })},
+	{name: "syscalls", usage: "comma-separated syscalls traced at startup", apply: func(cfg *Config, v string) error {
+		cfg.Probe.Syscalls = splitList(v)
+		return nil
+	}},
+	{name: "pin-path", usage: "bpffs directory for pinned maps, empty to disable", apply: func(cfg *Config, v string) error {
+		cfg.Probe.PinPath = v
+		return nil
+	}},
+	{name: "map-sizes", usage: "comma-separated map=max_entries overrides, e.g. target_pids=4096", apply: func(cfg *Config, v string) error {
+		if cfg.Probe.MapSizes == nil {
+			cfg.Probe.MapSizes = make(map[string]uint32)
+		}
+		for _, item := range splitList(v) {
+			name, size, ok := strings.Cut(item, "=")
+			if !ok {
+				return errors.New("expected map=max_entries, got " + strconv.Quote(item))
+			}
+			n, err := strconv.ParseUint(size, 10, 32)
+			if err != nil {
+				return errors.New("invalid size for " + name + ": " + strconv.Quote(size))
+			}
+			cfg.Probe.MapSizes[name] = uint32(n)
+		}
+		return nil
+	}},
+	{name: "exclude-noisy-daemons", usage: "exclude dockerd, journald, log shippers...", isBool: true, apply: boolSetting(func(cfg *Config) *bool { return &cfg.Exclude.NoisyDaemons })},
+	{name: "target-pids", usage: "comma-separated PIDs targeted at startup", apply: func(cfg *Config, v string) error {
+		pids := make([]uint32, 0)
+		for _, item := range splitList(v) {
+			pid, err := strconv.ParseUint(item, 10, 32)
+			if err != nil {
+				return errors.New("invalid PID " + strconv.Quote(item))
+			}
+			pids = append(pids, uint32(pid))
+		}
+		cfg.Targets.PIDs = pids
+		return nil
+	}},
+	{name: "follow-children", usage: "also target the children of the startup PIDs", isBool: true, apply: boolSetting(func(cfg *Config) *bool { return &cfg.Targets.FollowChildren })},
+	{name: "target-comms", usage: "comma-separated task names targeted at startup", apply: func(cfg *Config, v string) error {
+		cfg.Targets.Comms = splitList(v)
+		return nil
+	}},
+	{name: "print-all", usage: "start in print_all mode", isBool: true, apply: boolSetting(func(cfg *Config) *bool { return &cfg.Targets.PrintAll })},
+	{name: "global-rate-limit", usage: "events per second emitted for all processes, 0 for unlimited", apply: uintSetting(func(cfg *Config) *uint64 { return &cfg.RateLimit.GlobalRate })},
+	{name: "pid-rate-limit", usage: "events per second emitted per process, 0 for unlimited", apply: uintSetting(func(cfg *Config) *uint64 { return &cfg.RateLimit.PIDRate })},
+	{name: "sample-every", usage: "emit 1 in N matching syscalls, 0 or 1 for all", apply: func(cfg *Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return errors.New("invalid integer " + strconv.Quote(v))
+		}
+		cfg.RateLimit.SampleEvery = uint32(n)
+		return nil
+	}},
+	{name: "history-capacity", usage: "events kept for GET /events, 0 to disable", apply: intSetting(func(cfg *Config) *int { return &cfg.History.Capacity })},
+	{name: "journal-dir", usage: "directory of the on-disk event journal, empty to disable", apply: func(cfg *Config, v string) error {
+		cfg.Journal.Dir = v
+		return nil
+	}},
+	{name: "journal-segment-size-mb", usage: "journal segment size before rollover, in MB", apply: intSetting(func(cfg *Config) *int { return &cfg.Journal.SegmentSizeMB })},
+	{name: "journal-max-size-mb", usage: "journal size kept, in MB, 0 for unbounded", apply: intSetting(func(cfg *Config) *int { return &cfg.Journal.MaxSizeMB })},
+	{name: "journal-max-age-hours", usage: "hours journal segments are kept, 0 for unbounded", apply: intSetting(func(cfg *Config) *int { return &cfg.Journal.MaxAgeHours })},
+	{name: "record", usage: "record raw events and target changes to this capture file", apply: func(cfg *Config, v string) error {
+		cfg.Capture.Record = v
+		return nil
+	}},
+	{name: "replay", usage: "replay this capture file instead of reading the kernel (no root needed)", apply: func(cfg *Config, v string) error {
+		cfg.Capture.Replay = v
+		return nil
+	}},
+	{name: "replay-speed", usage: "replay speed: 1 keeps the recorded timing, 0 is as fast as possible", apply: func(cfg *Config, v string) error {
+		speed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.New("invalid number " + strconv.Quote(v))
+		}
+		cfg.Capture.Speed = speed
+		return nil
+	}},
+	{name: "metrics-max-targets", usage: "PIDs with their own per-target metrics series, 0 to disable", apply: intSetting(func(cfg *Config) *int { return &cfg.Metrics.MaxTargets })},
+}
+
+func intSetting(field func(cfg *Config) *int) func(cfg *Config, v string) error {
+	return func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("invalid integer " + strconv.Quote(v))
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+func uintSetting(field func(cfg *Config) *uint64) func(cfg *Config, v string) error {
+	return func(cfg *Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return errors.New("invalid integer " + strconv.Quote(v))
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+func boolSetting(field func(cfg *Config) *bool) func(cfg *Config, v string) error {
+	return func(cfg *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("invalid boolean " + strconv.Quote(v))
+		}
+		*field(cfg) = b
+		return nil
+	}
+}
+
+// splitList splits a comma-separated value, dropping empty items
+func splitList(v string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// LoadConfig builds the configuration from args (without the program name).
+// The file comes from -config or EBPF_GAME_CONFIG; YAML unless it ends in .json.
+func LoadConfig(args []string) (Config, error) {
+	fs := flag.NewFlagSet("ebpf-game", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "YAML or JSON config file (env "+envPrefix+"CONFIG)")
+
+	// Flags are recorded while parsing and applied last, after the file and the environment
+	type flagValue struct {
+		s     setting
+		value string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		record := func(v string) error {
+			flagValues = append(flagValues, flagValue{s, v})
+			return nil
+		}
+		usage := s.usage + " (env " + s.env() + ")"
+		if s.isBool {
+			fs.BoolFunc(s.name, usage, record)
+		} else {
+			fs.Func(s.name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, errors.New("unexpected arguments: " + strings.Join(fs.Args(), " "))
+	}
+
+	cfg := DefaultConfig()
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := s.apply(&cfg, v); err != nil {
+				return Config{}, errors.New(s.env() + ": " + err.Error())
+			}
+		}
+	}
+	for _, f := range flagValues {
+		if err := f.s.apply(&cfg, f.value); err != nil {
+			return Config{}, errors.New("-" + f.s.name + ": " + err.Error())
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// loadConfigFile decodes path over cfg; unknown keys are errors so typos do not go unnoticed
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.New("cannot read config file: " + err.Error())
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return errors.New(path + ": " + err.Error())
+		}
+		return nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty file leaves the defaults
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New(path + ": " + err.Error())
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, each with its path in the config file
+func (cfg Config) Validate() error {
+	var problems []string
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(cfg.Listen); err != nil {
+		add("listen", "invalid address %q: %v", cfg.Listen, err)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		add("listen", "invalid port %q in %q", port, cfg.Listen)
+	}
+
+	if cfg.Auth.AuditFile != "" && cfg.Auth.TokensFile == "" {
+		add("auth.audit_file", "requires auth.tokens_file")
+	}
+
+	switch cfg.Log.Kind {
+	case LoggerStdout:
+	case LoggerFile, LoggerBoth:
+		if cfg.Log.Path == "" {
+			add("log.path", "required for the %s logger", cfg.Log.Kind)
+		}
+	default:
+		add("log.kind", "must be %s, %s or %s, got %q", LoggerStdout, LoggerFile, LoggerBoth, cfg.Log.Kind)
+	}
+	for _, f := range []struct {
+		field string
+		value int
+	}{{"log.max_size_mb", cfg.Log.MaxSizeMB}, {"log.max_backups", cfg.Log.MaxBackups}, {"log.max_age_days", cfg.Log.MaxAgeDays}} {
+		if f.value < 0 {
+			add(f.field, "must not be negative, got %d", f.value)
+		}
+	}
+
+	switch cfg.Probe.Transport {
+	case "", TransportRingBuf, TransportPerf:
+	default:
+		add("probe.transport", "must be %s or %s, got %q", TransportRingBuf, TransportPerf, cfg.Probe.Transport)
+	}
+	switch cfg.Probe.AttachMode {
+	case "", AttachTracepoint, AttachFentry, AttachKprobe:
+	default:
+		add("probe.attach_mode", "must be %s, %s or %s, got %q", AttachTracepoint, AttachFentry, AttachKprobe, cfg.Probe.AttachMode)
+	}
+	pageSize := os.Getpagesize()
+	if size := cfg.Probe.RingBufferSize; size <= 0 || size&(size-1) != 0 || size%pageSize != 0 {
+		add("probe.ring_buffer_size", "must be a power of two and a multiple of the page size (%d), got %d", pageSize, size)
+	}
+	if size := cfg.Probe.PerfBufferSize; size <= 0 || size%pageSize != 0 {
+		add("probe.perf_buffer_size", "must be a positive multiple of the page size (%d), got %d", pageSize, size)
+	}
+	for i, name := range cfg.Probe.Syscalls {
+		if _, ok := lookupSyscall(name); !ok {
+			add(fmt.Sprintf("probe.syscalls[%d]", i), "unknown syscall %q", name)
+		}
+	}
+	names := make([]string, 0, len(cfg.Probe.MapSizes))
+	for name := range cfg.Probe.MapSizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if size := cfg.Probe.MapSizes[name]; !isResizableMap(name) {
+			add("probe.map_sizes."+name, "unknown map, expected one of %s", strings.Join(resizableMaps, ", "))
+		} else if size == 0 {
+			add("probe.map_sizes."+name, "must be positive")
+		}
+	}
+
+	for i, sink := range cfg.Sinks {
+		field := fmt.Sprintf("sinks[%d]", i)
+		switch sink.Kind {
+		case SinkLogger, SinkRing, SinkStdout:
+		case SinkJSONL:
+			if sink.Path == "" {
+				add(field+".path", "required for the jsonl sink")
+			}
+		default:
+			add(field+".kind", "must be %s, %s, %s or %s, got %q", SinkLogger, SinkJSONL, SinkRing, SinkStdout, sink.Kind)
+		}
+		if sink.Capacity < 0 {
+			add(field+".capacity", "must not be negative, got %d", sink.Capacity)
+		}
+		if sink.QueueSize < 0 {
+			add(field+".queue_size", "must not be negative, got %d", sink.QueueSize)
+		}
+	}
+
+	checkPIDs := func(field string, pids []uint32) {
+		for i, pid := range pids {
+			if pid == 0 {
+				add(fmt.Sprintf("%s[%d]", field, i), "PIDs must be positive")
+			}
+		}
+	}
+	checkComms := func(field string, comms []string) {
+		for i, comm := range comms {
+			if _, err := newCommKey(comm); err != nil {
+				add(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+			}
+		}
+	}
+	checkCgroups := func(field string, cgroups []CgroupTarget) {
+		for i, cg := range cgroups {
+			if cg.Path == "" {
+				add(fmt.Sprintf("%s[%d].path", field, i), "required")
+			}
+		}
+	}
+	checkPIDs("exclude.pids", cfg.Exclude.PIDs)
+	checkComms("exclude.comms", cfg.Exclude.Comms)
+	checkCgroups("exclude.cgroups", cfg.Exclude.Cgroups)
+	checkPIDs("targets.pids", cfg.Targets.PIDs)
+	checkComms("targets.comms", cfg.Targets.Comms)
+	checkCgroups("targets.cgroups", cfg.Targets.Cgroups)
+
+	if err := cfg.RateLimit.Validate(); err != nil {
+		add("rate_limit", "%v", err)
+	}
+	if cfg.History.Capacity < 0 {
+		add("history.capacity", "must not be negative, got %d", cfg.History.Capacity)
+	}
+	if cfg.Journal.Dir != "" && cfg.Journal.SegmentSizeMB <= 0 {
+		add("journal.segment_size_mb", "must be positive, got %d", cfg.Journal.SegmentSizeMB)
+	}
+	for _, f := range []struct {
+		field string
+		value int
+	}{
+		{"journal.max_size_mb", cfg.Journal.MaxSizeMB},
+		{"journal.max_age_hours", cfg.Journal.MaxAgeHours},
+		{"journal.queue_size", cfg.Journal.QueueSize},
+	} {
+		if f.value < 0 {
+			add(f.field, "must not be negative, got %d", f.value)
+		}
+	}
+	if cfg.Capture.Record != "" && cfg.Capture.Replay != "" {
+		add("capture.record", "cannot be combined with capture.replay")
+	}
+	if cfg.Capture.Speed < 0 {
+		add("capture.speed", "must not be negative, got %g", cfg.Capture.Speed)
+	}
+	if cfg.Metrics.MaxTargets < 0 {
+		add("metrics.max_targets", "must not be negative, got %d", cfg.Metrics.MaxTargets)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
+
+// targetCommands turns the startup targets into commands; cgroup paths are resolved here.
+// PIDs without a running process are skipped with a warning: they may have exited since
+// the configuration was written.
+func targetCommands(cfg TargetConfig, logger Logger) ([]MonitorCommand, error) {
+	var cmds []MonitorCommand
+	for _, pid := range cfg.PIDs {
+		if _, err := processStartTime(pid); err != nil {
+			logger.Warnf("Not targeting PID %d from the configuration: %v", pid, err)
+			continue
+		}
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddPID, PID: pid, FollowChildren: cfg.FollowChildren})
+	}
+	for _, comm := range cfg.Comms {
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddComm, Comm: comm})
+	}
+	for i, cg := range cfg.Cgroups {
+		path, id, err := resolveCgroup(cg.Path)
+		if err != nil {
+			return nil, errors.New("targets.cgroups[" + strconv.Itoa(i) + "]: " + err.Error())
+		}
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddCgroup, Cgroup: CgroupTarget{ID: id, Path: path, Descendants: cg.Descendants}})
+	}
+	if cfg.PrintAll {
+		cmds = append(cmds, MonitorCommand{Kind: CommandSetPrintAll, PrintAll: true})
+	}
+	return cmds, nil
+}
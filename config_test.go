@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+listen: ":9000"
+history:
+  capacity: 10
+metrics:
+  max_targets: 5
+`)
+	t.Setenv(envPrefix+"LISTEN", ":9001")
+	t.Setenv(envPrefix+"HISTORY_CAPACITY", "20")
+
+	cfg, err := LoadConfig([]string{"-config", path, "-listen", ":9002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":9002" {
+		t.Errorf("listen = %q, want the flag over the environment", cfg.Listen)
+	}
+	if cfg.History.Capacity != 20 {
+		t.Errorf("history capacity = %d, want the environment over the file", cfg.History.Capacity)
+	}
+	if cfg.Metrics.MaxTargets != 5 {
+		t.Errorf("metrics max targets = %d, want the file over the default", cfg.Metrics.MaxTargets)
+	}
+	if len(cfg.Probe.Syscalls) != 2 || cfg.Probe.Syscalls[0] != "read" || cfg.Probe.Syscalls[1] != "write" {
+		t.Errorf("syscalls = %v, want the default", cfg.Probe.Syscalls)
+	}
+}
+
+func TestLoadConfigFileFromEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"listen": "127.0.0.1:9000", "targets": {"pids": [42]}}`)
+	t.Setenv(envPrefix+"CONFIG", path)
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:9000" || len(cfg.Targets.PIDs) != 1 || cfg.Targets.PIDs[0] != 42 {
+		t.Errorf("config file not loaded: listen %q, target PIDs %v", cfg.Listen, cfg.Targets.PIDs)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		wantErr string
+	}{
+		{
+			name:    "map size without a size",
+			args:    []string{"-map-sizes", "target_pids"},
+			wantErr: `-map-sizes: expected map=max_entries, got "target_pids"`,
+		},
+		{
+			name:    "map size not a number",
+			args:    []string{"-map-sizes", "target_pids=big"},
+			wantErr: `-map-sizes: invalid size for target_pids: "big"`,
+		},
+		{
+			name:    "unknown map",
+			args:    []string{"-map-sizes", "no_such_map=10"},
+			wantErr: "probe.map_sizes.no_such_map: unknown map",
+		},
+		{
+			name:    "zero map size",
+			env:     map[string]string{"MAP_SIZES": "target_pids=0"},
+			wantErr: "probe.map_sizes.target_pids: must be positive",
+		},
+		{
+			name:    "target PID not a number",
+			env:     map[string]string{"TARGET_PIDS": "12,abc"},
+			wantErr: `EBPF_GAME_TARGET_PIDS: invalid PID "abc"`,
+		},
+		{
+			name:    "zero target PID",
+			args:    []string{"-target-pids", "12,0"},
+			wantErr: "targets.pids[1]: PIDs must be positive",
+		},
+		{
+			name:    "rate limit out of range",
+			args:    []string{"-global-rate-limit", "2000000"},
+			wantErr: "rate_limit: global_rate must be at most 1000000, got 2000000",
+		},
+		{
+			name:    "burst out of range in the file",
+			file:    "rate_limit:\n  pid_rate: 10\n  pid_burst: 1000001\n",
+			wantErr: "rate_limit: pid_burst must be at most 1000000, got 1000001",
+		},
+		{
+			name:    "unknown key in the file",
+			file:    "listne: \":9000\"\n",
+			wantErr: "field listne not found",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(envPrefix+name, value)
+			}
+			args := tc.args
+			if tc.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, "config.yaml", tc.file)}, args...)
+			}
+			_, err := LoadConfig(args)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("LoadConfig error = %v, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
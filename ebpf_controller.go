@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type CommandKind int
@@ -10,31 +15,154 @@ const (
 	CommandAddPID CommandKind = iota
 	CommandClearPIDs
 	CommandSetPrintAll
+	CommandEnableSyscall
+	CommandDisableSyscall
+	CommandRemovePID
+	CommandAddComm
+	CommandRemoveComm
+	CommandAddCgroup
+	CommandRemoveCgroup
+	CommandTrackChild
+	CommandTargetExited
+	CommandExcludePID
+	CommandExcludeComm
+	CommandExcludeCgroup
+	CommandRemoveExclusions
+	CommandSetRateLimit
 )
 
+// String returns the command name used in logs and API results
+func (k CommandKind) String() string {
+	switch k {
+	case CommandAddPID:
+		return "add_pid"
+	case CommandClearPIDs:
+		return "clear_pids"
+	case CommandSetPrintAll:
+		return "set_print_all"
+	case CommandEnableSyscall:
+		return "enable_syscall"
+	case CommandDisableSyscall:
+		return "disable_syscall"
+	case CommandRemovePID:
+		return "remove_pid"
+	case CommandAddComm:
+		return "add_comm"
+	case CommandRemoveComm:
+		return "remove_comm"
+	case CommandAddCgroup:
+		return "add_cgroup"
+	case CommandRemoveCgroup:
+		return "remove_cgroup"
+	case CommandTrackChild:
+		return "track_child"
+	case CommandTargetExited:
+		return "target_exited"
+	case CommandExcludePID:
+		return "exclude_pid"
+	case CommandExcludeComm:
+		return "exclude_comm"
+	case CommandExcludeCgroup:
+		return "exclude_cgroup"
+	case CommandRemoveExclusions:
+		return "remove_exclusions"
+	case CommandSetRateLimit:
+		return "set_rate_limit"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// MonitorCommand is one change requested to the probe. When Result is set it
+// receives the outcome once the controller has applied the command; it must be
+// buffered so the controller never blocks on a caller that gave up waiting.
+// The remove commands act on PIDs/Comms/CgroupIDs, so one command can remove many targets;
+// CommandRemoveExclusions uses all three at once.
+// CommandTrackChild and CommandTargetExited are sent by the controller itself
+// when the kernel reports an inherited or exited target.
 type MonitorCommand struct {
-	Kind     CommandKind
-	PID      uint32
-	PrintAll bool
+	Kind           CommandKind
+	PID            uint32
+	PIDs           []uint32
+	ParentPID      uint32
+	FollowChildren bool
+	ExitedAt       time.Time
+	Comm           string
+	Comms          []string
+	Cgroup         CgroupTarget
+	CgroupIDs      []uint64
+	PrintAll       bool
+	Syscall        string
+	RateLimit      RateLimitConfig
+	Result         chan CommandOutcome
+}
+
+// CommandOutcome is what the controller reports back for one command
+type CommandOutcome struct {
+	Err error
+	// Removed lists the PIDs of a CommandRemovePID that were in the target map,
+	// or the child of a CommandTrackChild dropped because it is a thread or gone,
+	// whether or not its entry was still there
+	Removed []uint32
+	// RemovedComms lists the names of a CommandRemoveComm that were in the target map
+	RemovedComms []string
+	// RemovedCgroups lists the IDs of a CommandRemoveCgroup that were in the target map
+	RemovedCgroups []uint64
 }
 
 // EBpfController decouples API requests from the EBpfProbe via a command queue
 // It owns a worker goroutine which processes commands sequentially
 // to ensure consistent state updates.
 type EBpfController struct {
-	logger    Logger
-	ebpfProbe *EBpfProbe
-	cmdCh     chan MonitorCommand
-	stopCh    chan struct{}
+	logger     Logger
+	ebpfProbe  Probe
+	stats      *StatsTracker
+	pidManager *PIDManager
+	cmdCh      chan MonitorCommand
+	stopCh     chan struct{}
+
+	// cgroupPaths and excludedCgroupPaths remember the path each cgroup target
+	// or exclusion was added with; the kernel maps only hold IDs
+	mu                  sync.RWMutex
+	cgroupPaths         map[uint64]string
+	excludedCgroupPaths map[uint64]string
+
+	// commandCounts counts processed commands by kind and result
+	countsMu      sync.Mutex
+	commandCounts map[commandCountKey]uint64
+
+	// recorder, when set, records the commands applied successfully
+	recorder atomic.Pointer[CaptureWriter]
+
+	// threadCheck tells an inherited thread from a process; isThread unless replaced
+	threadCheck func(id uint32) (bool, error)
+}
+
+type commandCountKey struct {
+	kind CommandKind
+	ok   bool
 }
 
-// NewEBpfController constructs the app given an ebpf monitor and a shared command queue
-func NewEBpfController(logger Logger, ebpf *EBpfProbe, cmdCh chan MonitorCommand) *EBpfController {
+// CommandCount is the number of commands of one kind processed with one result
+type CommandCount struct {
+	Kind   string `json:"kind"`
+	Result string `json:"result"` // success or error
+	Count  uint64 `json:"count"`
+}
+
+// NewEBpfController constructs the app given a probe and a shared command queue
+func NewEBpfController(logger Logger, ebpf Probe, cmdCh chan MonitorCommand, pidManager *PIDManager) *EBpfController {
 	app := &EBpfController{
 		logger:   logger,
 		ebpfProbe: ebpf,
+		stats:     NewStatsTracker(),
+		pidManager: pidManager,
 		cmdCh:     cmdCh,
 		stopCh:    make(chan struct{}),
+		cgroupPaths: make(map[uint64]string),
+		excludedCgroupPaths: make(map[uint64]string),
+		commandCounts: make(map[commandCountKey]uint64),
+		threadCheck: isThread,
 	}
 	go app.run()
 	return app
@@ -44,36 +172,265 @@ func (r *EBpfController) run() {
 	for {
 		select {
 		case cmd := <-r.cmdCh:
-			r.handle(cmd)
+			outcome := r.handle(cmd)
+			r.countCommand(cmd.Kind, outcome.Err == nil)
+			if recorder := r.recorder.Load(); recorder != nil && outcome.Err == nil {
+				recorder.WriteCommand(cmd, outcome)
+			}
+			if cmd.Result != nil {
+				cmd.Result <- outcome
+			}
 		case <-r.stopCh:
 			return
 		}
 	}
 }
 
-func (r *EBpfController) handle(cmd MonitorCommand) {
+// SetThreadCheck replaces the /proc lookup that tells an inherited thread from a
+// process; it must be called before the first command is sent
+func (r *EBpfController) SetThreadCheck(check func(id uint32) (bool, error)) {
+	r.threadCheck = check
+}
+
+// SetRecorder records every command applied successfully from now on to a capture file
+func (r *EBpfController) SetRecorder(recorder *CaptureWriter) {
+	r.recorder.Store(recorder)
+}
+
+func (r *EBpfController) countCommand(kind CommandKind, ok bool) {
+	r.countsMu.Lock()
+	defer r.countsMu.Unlock()
+	r.commandCounts[commandCountKey{kind: kind, ok: ok}]++
+}
+
+// CommandCounts returns the processed command counters sorted by kind, then result
+func (r *EBpfController) CommandCounts() []CommandCount {
+	r.countsMu.Lock()
+	defer r.countsMu.Unlock()
+	counts := make([]CommandCount, 0, len(r.commandCounts))
+	for k, n := range r.commandCounts {
+		result := "success"
+		if !k.ok {
+			result = "error"
+		}
+		counts = append(counts, CommandCount{Kind: k.kind.String(), Result: result, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Kind != counts[j].Kind {
+			return counts[i].Kind < counts[j].Kind
+		}
+		return counts[i].Result < counts[j].Result
+	})
+	return counts
+}
+
+// handle applies one command and stops at the first error met
+func (r *EBpfController) handle(cmd MonitorCommand) CommandOutcome {
 	switch cmd.Kind {
 	case CommandAddPID:
-		if err := r.ebpfProbe.AddTargetPID(cmd.PID); err != nil {
+		if err := r.ebpfProbe.AddTargetPID(cmd.PID, cmd.FollowChildren); err != nil {
 			r.logger.Errorf("Failed to add PID %d: %v", cmd.PID, err)
+			return CommandOutcome{Err: errors.New("failed to add PID: " + err.Error())}
 		}
+		r.pidManager.AddPIDs([]uint32{cmd.PID}, cmd.FollowChildren)
 		if err := r.ebpfProbe.SetPrintAll(false); err != nil {
 			r.logger.Errorf("Failed to set print_all false after add: %v", err)
+			return CommandOutcome{Err: errors.New("failed to set print_all false: " + err.Error())}
 		}
 	case CommandClearPIDs:
 		if err := r.ebpfProbe.ClearTargetPIDs(); err != nil {
 			r.logger.Errorf("Failed to clear PIDs: %v", err)
+			return CommandOutcome{Err: errors.New("failed to clear PIDs: " + err.Error())}
 		}
+		r.pidManager.ClearPIDList()
 		if err := r.ebpfProbe.SetPrintAll(false); err != nil {
 			r.logger.Errorf("Failed to set print_all false after clear: %v", err)
+			return CommandOutcome{Err: errors.New("failed to set print_all false: " + err.Error())}
 		}
 	case CommandSetPrintAll:
 		if err := r.ebpfProbe.SetPrintAll(cmd.PrintAll); err != nil {
 			r.logger.Errorf("Failed to set print_all=%v: %v", cmd.PrintAll, err)
+			return CommandOutcome{Err: errors.New("failed to set print_all: " + err.Error())}
+		}
+	case CommandSetRateLimit:
+		if err := r.ebpfProbe.SetRateLimit(cmd.RateLimit); err != nil {
+			r.logger.Errorf("Failed to set rate limit %+v: %v", cmd.RateLimit, err)
+			return CommandOutcome{Err: errors.New("failed to set rate limit: " + err.Error())}
+		}
+		r.logger.Infof("Rate limit set: %+v", cmd.RateLimit)
+	case CommandEnableSyscall:
+		if _, err := r.ebpfProbe.EnableSyscall(cmd.Syscall); err != nil {
+			r.logger.Errorf("Failed to enable syscall %s: %v", cmd.Syscall, err)
+			return CommandOutcome{Err: errors.New("failed to enable syscall: " + err.Error())}
+		}
+	case CommandDisableSyscall:
+		if err := r.ebpfProbe.DisableSyscall(cmd.Syscall); err != nil {
+			r.logger.Errorf("Failed to disable syscall %s: %v", cmd.Syscall, err)
+			return CommandOutcome{Err: errors.New("failed to disable syscall: " + err.Error())}
 		}
+	case CommandRemovePID:
+		// PIDs absent from the kernel map must not linger in the local list either
+		removed := make([]uint32, 0, len(cmd.PIDs))
+		for _, pid := range cmd.PIDs {
+			err := r.ebpfProbe.RemoveTargetPID(pid)
+			if err != nil && !errors.Is(err, ErrPIDNotTargeted) {
+				r.logger.Errorf("Failed to remove PID %d: %v", pid, err)
+				return CommandOutcome{Err: errors.New("failed to remove PID: " + err.Error()), Removed: removed}
+			}
+			r.pidManager.RemovePIDs([]uint32{pid})
+			if err == nil {
+				removed = append(removed, pid)
+			}
+		}
+		return CommandOutcome{Removed: removed}
+	case CommandAddComm:
+		if err := r.ebpfProbe.AddTargetComm(cmd.Comm); err != nil {
+			r.logger.Errorf("Failed to add comm %q: %v", cmd.Comm, err)
+			return CommandOutcome{Err: errors.New("failed to add comm: " + err.Error())}
+		}
+		if err := r.ebpfProbe.SetPrintAll(false); err != nil {
+			r.logger.Errorf("Failed to set print_all false after add: %v", err)
+			return CommandOutcome{Err: errors.New("failed to set print_all false: " + err.Error())}
+		}
+	case CommandRemoveComm:
+		removed := make([]string, 0, len(cmd.Comms))
+		for _, comm := range cmd.Comms {
+			err := r.ebpfProbe.RemoveTargetComm(comm)
+			if errors.Is(err, ErrCommNotTargeted) {
+				continue
+			}
+			if err != nil {
+				r.logger.Errorf("Failed to remove comm %q: %v", comm, err)
+				return CommandOutcome{Err: errors.New("failed to remove comm: " + err.Error()), RemovedComms: removed}
+			}
+			removed = append(removed, comm)
+		}
+		return CommandOutcome{RemovedComms: removed}
+	case CommandAddCgroup:
+		if err := r.ebpfProbe.AddTargetCgroup(cmd.Cgroup.ID, cmd.Cgroup.Descendants); err != nil {
+			r.logger.Errorf("Failed to add cgroup %s (%d): %v", cmd.Cgroup.Path, cmd.Cgroup.ID, err)
+			return CommandOutcome{Err: errors.New("failed to add cgroup: " + err.Error())}
+		}
+		r.mu.Lock()
+		r.cgroupPaths[cmd.Cgroup.ID] = cmd.Cgroup.Path
+		r.mu.Unlock()
+		if err := r.ebpfProbe.SetPrintAll(false); err != nil {
+			r.logger.Errorf("Failed to set print_all false after add: %v", err)
+			return CommandOutcome{Err: errors.New("failed to set print_all false: " + err.Error())}
+		}
+	case CommandRemoveCgroup:
+		removed := make([]uint64, 0, len(cmd.CgroupIDs))
+		for _, id := range cmd.CgroupIDs {
+			err := r.ebpfProbe.RemoveTargetCgroup(id)
+			if errors.Is(err, ErrCgroupNotTargeted) {
+				continue
+			}
+			if err != nil {
+				r.logger.Errorf("Failed to remove cgroup %d: %v", id, err)
+				return CommandOutcome{Err: errors.New("failed to remove cgroup: " + err.Error()), RemovedCgroups: removed}
+			}
+			r.mu.Lock()
+			delete(r.cgroupPaths, id)
+			r.mu.Unlock()
+			removed = append(removed, id)
+		}
+		return CommandOutcome{RemovedCgroups: removed}
+	case CommandTrackChild:
+		// Without BTF, thread creation is tracked by tp_sched_process_fork too;
+		// threads are already matched through their process, so their entry is
+		// dropped. A child gone before this check was normally removed by
+		// tp_sched_process_exit, but the entry is dropped anyway in case that
+		// tracepoint is not attached.
+		thread, err := r.threadCheck(cmd.PID)
+		if err != nil || thread {
+			if err != nil {
+				r.logger.Debugf("PID %d inherited from %d is gone: %v", cmd.PID, cmd.ParentPID, err)
+			}
+			if err := r.ebpfProbe.RemoveTargetPID(cmd.PID); err != nil && !errors.Is(err, ErrPIDNotTargeted) {
+				r.logger.Errorf("Failed to remove PID %d inherited from %d: %v", cmd.PID, cmd.ParentPID, err)
+				return CommandOutcome{Err: errors.New("failed to remove inherited PID: " + err.Error())}
+			}
+			return CommandOutcome{Removed: []uint32{cmd.PID}}
+		}
+		r.pidManager.AddInherited(cmd.PID, cmd.ParentPID)
+		r.logger.Infof("PID %d inherited from followed PID %d", cmd.PID, cmd.ParentPID)
+	case CommandTargetExited:
+		r.pidManager.MarkExited(cmd.PID, cmd.Comm, cmd.ExitedAt)
+		r.logger.Infof("Target PID %d (%s) exited and was removed", cmd.PID, cmd.Comm)
+	case CommandExcludePID:
+		if err := r.ebpfProbe.AddExcludedPID(cmd.PID); err != nil {
+			r.logger.Errorf("Failed to exclude PID %d: %v", cmd.PID, err)
+			return CommandOutcome{Err: errors.New("failed to exclude PID: " + err.Error())}
+		}
+	case CommandExcludeComm:
+		if err := r.ebpfProbe.AddExcludedComm(cmd.Comm); err != nil {
+			r.logger.Errorf("Failed to exclude comm %q: %v", cmd.Comm, err)
+			return CommandOutcome{Err: errors.New("failed to exclude comm: " + err.Error())}
+		}
+	case CommandExcludeCgroup:
+		if err := r.ebpfProbe.AddExcludedCgroup(cmd.Cgroup.ID, cmd.Cgroup.Descendants); err != nil {
+			r.logger.Errorf("Failed to exclude cgroup %s (%d): %v", cmd.Cgroup.Path, cmd.Cgroup.ID, err)
+			return CommandOutcome{Err: errors.New("failed to exclude cgroup: " + err.Error())}
+		}
+		r.mu.Lock()
+		r.excludedCgroupPaths[cmd.Cgroup.ID] = cmd.Cgroup.Path
+		r.mu.Unlock()
+	case CommandRemoveExclusions:
+		return r.removeExclusions(cmd)
 	default:
 		r.logger.Warnf("Unknown command kind: %v", cmd.Kind)
+		return CommandOutcome{Err: errors.New("unknown command kind: " + cmd.Kind.String())}
 	}
+	return CommandOutcome{}
+}
+
+// removeExclusions applies CommandRemoveExclusions, skipping entries that were not excluded
+func (r *EBpfController) removeExclusions(cmd MonitorCommand) CommandOutcome {
+	outcome := CommandOutcome{
+		Removed:        make([]uint32, 0, len(cmd.PIDs)),
+		RemovedComms:   make([]string, 0, len(cmd.Comms)),
+		RemovedCgroups: make([]uint64, 0, len(cmd.CgroupIDs)),
+	}
+	for _, pid := range cmd.PIDs {
+		err := r.ebpfProbe.RemoveExcludedPID(pid)
+		if errors.Is(err, ErrPIDNotExcluded) {
+			continue
+		}
+		if err != nil {
+			r.logger.Errorf("Failed to remove excluded PID %d: %v", pid, err)
+			outcome.Err = errors.New("failed to remove excluded PID: " + err.Error())
+			return outcome
+		}
+		outcome.Removed = append(outcome.Removed, pid)
+	}
+	for _, comm := range cmd.Comms {
+		err := r.ebpfProbe.RemoveExcludedComm(comm)
+		if errors.Is(err, ErrCommNotExcluded) {
+			continue
+		}
+		if err != nil {
+			r.logger.Errorf("Failed to remove excluded comm %q: %v", comm, err)
+			outcome.Err = errors.New("failed to remove excluded comm: " + err.Error())
+			return outcome
+		}
+		outcome.RemovedComms = append(outcome.RemovedComms, comm)
+	}
+	for _, id := range cmd.CgroupIDs {
+		err := r.ebpfProbe.RemoveExcludedCgroup(id)
+		if errors.Is(err, ErrCgroupNotExcluded) {
+			continue
+		}
+		if err != nil {
+			r.logger.Errorf("Failed to remove excluded cgroup %d: %v", id, err)
+			outcome.Err = errors.New("failed to remove excluded cgroup: " + err.Error())
+			return outcome
+		}
+		r.mu.Lock()
+		delete(r.excludedCgroupPaths, id)
+		r.mu.Unlock()
+		outcome.RemovedCgroups = append(outcome.RemovedCgroups, id)
+	}
+	return outcome
 }
 
 // Query helpers pass-through to EBpfController for current state
@@ -81,10 +438,203 @@ func (r *EBpfController) GetTargetPIDs() ([]uint32, error) {
 	return r.ebpfProbe.GetTargetPIDs()
 }
 
+// GetTargets returns target PIDs with their follow/inherited flags, as stored in the kernel
+func (r *EBpfController) GetTargets() ([]TargetPID, error) {
+	return r.ebpfProbe.GetTargets()
+}
+
+// HandleProcessEvent queues the bookkeeping for a process lifecycle event.
+// It is called from the event reader, so it never blocks.
+func (r *EBpfController) HandleProcessEvent(event Data) {
+	var cmd MonitorCommand
+	switch event.EventType {
+	case evtProcessFork:
+		cmd = MonitorCommand{Kind: CommandTrackChild, PID: event.ChildPid, ParentPID: event.Pid}
+	case evtProcessExit:
+		cmd = MonitorCommand{Kind: CommandTargetExited, PID: event.Pid, Comm: event.Comm, ExitedAt: time.Now()}
+	default:
+		return
+	}
+	select {
+	case r.cmdCh <- cmd:
+	default:
+		r.logger.Warnf("command queue full, dropping %s(%d)", cmd.Kind, cmd.PID)
+	}
+}
+
+func (r *EBpfController) GetTargetComms() ([]string, error) {
+	return r.ebpfProbe.GetTargetComms()
+}
+
+// GetTargetCgroups returns the target cgroups with the paths they were added with
+func (r *EBpfController) GetTargetCgroups() ([]CgroupTarget, error) {
+	targets, err := r.ebpfProbe.GetTargetCgroups()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := range targets {
+		targets[i].Path = r.cgroupPaths[targets[i].ID]
+	}
+	return targets, nil
+}
+
+// CgroupIDForPath finds the ID a target was added with, which still works
+// once the cgroup directory is gone
+func (r *EBpfController) CgroupIDForPath(path string) (uint64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for id, p := range r.cgroupPaths {
+		if p == path {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// GetExclusions returns the content of the skip maps, with the paths excluded cgroups were added with
+func (r *EBpfController) GetExclusions() (Exclusions, error) {
+	exclusions := Exclusions{SelfPID: selfPID()}
+	var err error
+	if exclusions.PIDs, err = r.ebpfProbe.GetExcludedPIDs(); err != nil {
+		return exclusions, err
+	}
+	if exclusions.Comms, err = r.ebpfProbe.GetExcludedComms(); err != nil {
+		return exclusions, err
+	}
+	if exclusions.Cgroups, err = r.ebpfProbe.GetExcludedCgroups(); err != nil {
+		return exclusions, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := range exclusions.Cgroups {
+		exclusions.Cgroups[i].Path = r.excludedCgroupPaths[exclusions.Cgroups[i].ID]
+	}
+	return exclusions, nil
+}
+
+// Snapshot reads the state to persist from the probe, without start times
+func (r *EBpfController) Snapshot() (PersistedState, error) {
+	var state PersistedState
+	targets, err := r.ebpfProbe.GetTargets()
+	if err != nil {
+		return state, err
+	}
+	state.Targets = make([]PersistedPID, 0, len(targets))
+	for _, t := range targets {
+		state.Targets = append(state.Targets, PersistedPID{PID: t.PID, FollowChildren: t.FollowChildren, Inherited: t.Inherited, ParentPID: t.ParentPID})
+	}
+	if state.PrintAll, err = r.ebpfProbe.GetPrintAllState(); err != nil {
+		return state, err
+	}
+	if state.Comms, err = r.ebpfProbe.GetTargetComms(); err != nil {
+		return state, err
+	}
+	if state.Cgroups, err = r.GetTargetCgroups(); err != nil {
+		return state, err
+	}
+	if state.RateLimit, err = r.ebpfProbe.GetRateLimit(); err != nil {
+		return state, err
+	}
+
+	exclusions, err := r.GetExclusions()
+	if err != nil {
+		return state, err
+	}
+	state.Exclusions = PersistedExclusions{
+		PIDs:    make([]PersistedPID, 0, len(exclusions.PIDs)),
+		Comms:   exclusions.Comms,
+		Cgroups: exclusions.Cgroups,
+	}
+	for _, pid := range exclusions.PIDs {
+		if pid != exclusions.SelfPID {
+			state.Exclusions.PIDs = append(state.Exclusions.PIDs, PersistedPID{PID: pid})
+		}
+	}
+	return state, nil
+}
+
+// ExcludedCgroupIDForPath is CgroupIDForPath for excluded cgroups
+func (r *EBpfController) ExcludedCgroupIDForPath(path string) (uint64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for id, p := range r.excludedCgroupPaths {
+		if p == path {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
 func (r *EBpfController) GetPrintAllState() (bool, error) {
 	return r.ebpfProbe.GetPrintAllState()
 }
 
+// GetRateLimit returns the sampling and token-bucket limits in effect
+func (r *EBpfController) GetRateLimit() (RateLimitConfig, error) {
+	return r.ebpfProbe.GetRateLimit()
+}
+
+// GetSuppressedCounts returns the matching calls not emitted because of the limits
+func (r *EBpfController) GetSuppressedCounts() (SuppressedCounts, error) {
+	return r.ebpfProbe.GetSuppressedCounts()
+}
+
+// GetStats returns per-PID syscall counts and rates; pid 0 means all PIDs
+func (r *EBpfController) GetStats(pid uint32) ([]PIDStats, error) {
+	counts, err := r.ebpfProbe.GetSyscallCounts()
+	if err != nil {
+		return nil, err
+	}
+	stats := r.stats.Update(counts, time.Now())
+	if pid == 0 {
+		return stats, nil
+	}
+	for _, ps := range stats {
+		if ps.PID == pid {
+			return []PIDStats{ps}, nil
+		}
+	}
+	return []PIDStats{}, nil
+}
+
+// GetExitStats returns per-PID latency, return value and errno aggregates; pid 0 means all PIDs
+func (r *EBpfController) GetExitStats(pid uint32) ([]PIDExitStats, error) {
+	exits, err := r.ebpfProbe.GetExitCounts()
+	if err != nil {
+		return nil, err
+	}
+	errnos, err := r.ebpfProbe.GetErrnoCounts()
+	if err != nil {
+		return nil, err
+	}
+	stats := buildExitStats(exits, errnos)
+	if pid == 0 {
+		return stats, nil
+	}
+	for _, ps := range stats {
+		if ps.PID == pid {
+			return []PIDExitStats{ps}, nil
+		}
+	}
+	return []PIDExitStats{}, nil
+}
+
+// GetSyscalls returns the syscall registry with the enabled state of each entry
+func (r *EBpfController) GetSyscalls() []SyscallInfo {
+	return listSyscalls(r.ebpfProbe.Info().Attachments)
+}
+
+func (r *EBpfController) GetProbeInfo() ProbeInfo {
+	return r.ebpfProbe.Info()
+}
+
+// ReaderStats reports the events dropped, lost and delivered between the kernel and the sinks
+func (r *EBpfController) ReaderStats() (ReaderStats, error) {
+	return r.ebpfProbe.ReaderStats()
+}
+
 func (r *EBpfController) Stop() error {
 	select {
 	case <-r.stopCh:
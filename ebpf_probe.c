@@ -7,14 +7,83 @@
 
 typedef unsigned int u32;
 typedef unsigned long long u64;
+typedef long long s64;
 
 // Event type identifiers
 #define EVT_READ  1
 #define EVT_WRITE 2
 
+// Process lifecycle events, outside the syscall registry range
+#define EVT_PROCESS_FORK 1001
+#define EVT_PROCESS_EXIT 1002
+
+// target_pids value flags
+#define TARGET_FOLLOW    1 // children forked by this process become targets
+#define TARGET_INHERITED 2 // added by tp_sched_process_fork, not by userspace
+
+#define COMM_LEN 16
+
+// Filter rule that let an event through, reported in data_t.match
+#define MATCH_NONE 0
+#define MATCH_ALL  1
+#define MATCH_PID  2
+#define MATCH_COMM 3
+#define MATCH_CGROUP 4
+
+// Deepest cgroup level checked for descendant matching
+#define MAX_CGROUP_DEPTH 16
+
+// Why an event never reached userspace, index of dropped_events
+#define DROP_RINGBUF_RESERVE 0 // ring buffer full
+#define DROP_PERF_OUTPUT     1 // bpf_perf_event_output failed, usually a full per-CPU buffer
+#define DROP_REASONS         2
+
+// Why handle_sys_call did not emit a matching call, index of suppressed_events
+#define SUPPRESS_SAMPLED      0 // not the 1-in-N sample
+#define SUPPRESS_PID_LIMIT    1 // per-PID token bucket empty
+#define SUPPRESS_GLOBAL_LIMIT 2 // global token bucket empty
+#define SUPPRESS_REASONS      3
+
+#define NS_PER_SEC 1000000000ULL
+
+// /proc reports start times in USER_HZ (100) ticks
+#define NS_PER_PROC_TICK (NS_PER_SEC / 100)
+
+// The fields of kernel types read by the tp_btf programs. CO-RE relocates them
+// against the running kernel's BTF at load, so no vmlinux.h is needed.
+#pragma clang attribute push(__attribute__((preserve_access_index)), apply_to = record)
+typedef struct {
+    int counter;
+} atomic_t;
+
+struct signal_struct {
+    atomic_t live; // threads of the group that have not reached do_exit
+};
+
+struct task_struct {
+    int pid;
+    int tgid;
+    u64 start_boottime; // ns after boot, the starttime of /proc/<pid>/stat
+    struct signal_struct *signal;
+};
+#pragma clang attribute pop
+
+// arg0/arg2 are the raw first and third syscall arguments (fd and byte count for read/write);
+// userspace decides per syscall whether they are meaningful
 struct data_t {
     u32 pid;
     u32 event_type;
+    u32 tid;
+    u32 uid;
+    u32 gid;
+    u32 cpu;
+    u64 cgroup_id;
+    u64 timestamp_ns;
+    u64 arg0;
+    u64 arg2;
+    char comm[COMM_LEN];
+    u32 match;
+    u32 sample_rate; // 1-in-N sampling in effect when the event was emitted
 };
 
 struct {
@@ -24,6 +93,30 @@ struct {
     __uint(max_entries, 1024);
 } events SEC(".maps");
 
+// Preferred transport (Linux 5.8+): one buffer shared by all CPUs, ordered, no per-CPU waste.
+// max_entries is the size in bytes and is overridden from userspace before load.
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1 << 22);
+} ringbuf_events SEC(".maps");
+
+// Set by userspace before load: 1 = ringbuf_events, 0 = events (perf fallback)
+const volatile u32 use_ringbuf = 0;
+
+// Set by userspace before loading the kprobes: 1 when syscalls go through arch
+// wrappers (__x64_sys_*) whose only argument is the user pt_regs
+const volatile u32 syscall_wrapper = 1;
+
+// Set by userspace before load: 1 when bpf_get_current_ancestor_cgroup_id is
+// available to tracing programs, enabling descendant matching of target_cgroups
+const volatile u32 cgroup_ancestors = 0;
+
+// Set by userspace before load from the tracepoint format file:
+// offset of child_pid in the sched_process_fork record
+const volatile u32 fork_child_pid_offset = 44;
+
+// PIDs never reported: the monitor itself plus API-managed exclusions.
+// Exclusions apply in both modes, before target matching.
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(max_entries, 1024);
@@ -31,13 +124,56 @@ struct {
     __type(value, u32);
 } skip_pid SEC(".maps");
 
+struct target_t {
+    u32 flags;
+    u32 parent;     // forking PID for inherited targets
+    u64 start_time; // process start in /proc/<pid>/stat ticks, 0 if unknown; tells a recycled PID apart
+};
+
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(max_entries, 1024);
     __type(key, u32);
-    __type(value, u32);
+    __type(value, struct target_t);
 } target_pids SEC(".maps");
 
+// NUL-padded task name, as returned by bpf_get_current_comm
+struct comm_key {
+    char comm[COMM_LEN];
+};
+
+// Task names to monitor in target list mode, next to target_pids
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, struct comm_key);
+    __type(value, u32);
+} target_comms SEC(".maps");
+
+// cgroup v2 IDs to monitor in target list mode; value 1 = include descendant cgroups
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u64);
+    __type(value, u32);
+} target_cgroups SEC(".maps");
+
+// Task names never reported, next to skip_pid
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, struct comm_key);
+    __type(value, u32);
+} skip_comms SEC(".maps");
+
+// cgroup v2 IDs never reported; value 1 = include descendant cgroups
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u64);
+    __type(value, u32);
+} skip_cgroups SEC(".maps");
+
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(max_entries, 1);
@@ -45,49 +181,697 @@ struct {
     __type(value, u32);
 } print_all_flag SEC(".maps");
 
-int handle_sys_call(struct pt_regs *ctx, u32 event_type)
+// Syscall number -> event type, for tp_sys_enter (syscalls:sys_enter_* tracepoints)
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 512);
+    __type(key, u32);
+    __type(value, u32);
+} syscall_events SEC(".maps");
+
+// Syscall number -> event type, for raw_tp_sys_enter (raw_syscalls:sys_enter).
+// Kept apart from syscall_events so a syscall is never counted by both programs.
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 512);
+    __type(key, u32);
+    __type(value, u32);
+} raw_syscall_events SEC(".maps");
+
+// Context layout shared by syscalls:sys_enter_* and raw_syscalls:sys_enter.
+// __syscall_nr is an int in the former and the low half of the long id in the latter.
+struct sys_enter_ctx {
+    u64 common;
+    int syscall_nr;
+    u32 pad;
+    u64 args[6];
+};
+
+// Context layout shared by syscalls:sys_exit_* and raw_syscalls:sys_exit
+struct sys_exit_ctx {
+    u64 common;
+    int syscall_nr;
+    u32 pad;
+    long ret;
+};
+
+struct stats_key {
+    u32 pid;
+    u32 event_type;
+};
+
+// Entry time of the syscall each thread is currently in, keyed by pid_tgid.
+// Only traced (matching) entries are recorded, so exits pair with them alone.
+struct inflight_t {
+    u64 start_ns;
+    u32 event_type;
+    u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u64);
+    __type(value, struct inflight_t);
+} inflight SEC(".maps");
+
+struct exit_stats_t {
+    u64 calls;
+    u64 errors;
+    u64 total_ns;
+    u64 max_ns;
+    u64 bytes; // sum of non-negative return values
+};
+
+// Per-CPU completed-syscall aggregates
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct stats_key);
+    __type(value, struct exit_stats_t);
+} syscall_exits SEC(".maps");
+
+struct errno_key {
+    u32 pid;
+    u32 event_type;
+    u32 err;
+};
+
+// Per-CPU failure counts by errno
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct errno_key);
+    __type(value, u64);
+} syscall_errors SEC(".maps");
+
+// Per-CPU counts of events emit_event could not hand to userspace, by DROP_* reason
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, DROP_REASONS);
+    __type(key, u32);
+    __type(value, u64);
+} dropped_events SEC(".maps");
+
+static __always_inline void inc_dropped(u32 reason)
+{
+    u64 *count = bpf_map_lookup_elem(&dropped_events, &reason);
+    if (count) {
+        (*count)++;
+    }
+}
+
+// Runtime rate limiting and sampling, set by userspace; all zero = emit everything.
+// Rates are events per second and bursts the bucket size; a zero rate is unlimited.
+struct rate_config_t {
+    u64 global_rate;
+    u64 global_burst;
+    u64 pid_rate;
+    u64 pid_burst;
+    u32 sample_every; // keep 1 in N matching calls; 0 and 1 keep all
+    u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, struct rate_config_t);
+} rate_config SEC(".maps");
+
+// Token bucket; tokens are scaled by NS_PER_SEC so refills need no division.
+// tokens is only changed by atomic adds and is signed, so takes racing on other
+// CPUs can overdraw it for a moment but never wrap it around to a full bucket.
+struct bucket_t {
+    s64 tokens;
+    u64 last_ns;
+};
+
+// Global bucket, shared by all CPUs
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, struct bucket_t);
+} global_bucket SEC(".maps");
+
+// Per-PID buckets; LRU so exited PIDs age out
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u32);
+    __type(value, struct bucket_t);
+} pid_buckets SEC(".maps");
+
+// Per-CPU position in the 1-in-N sampling cycle
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, u32);
+} sample_counter SEC(".maps");
+
+// Per-CPU counts of matching calls not emitted, by SUPPRESS_* reason.
+// They are still in syscall_counts, so rates can be extrapolated from the samples.
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, SUPPRESS_REASONS);
+    __type(key, u32);
+    __type(value, u64);
+} suppressed_events SEC(".maps");
+
+static __always_inline void inc_suppressed(u32 reason)
+{
+    u64 *count = bpf_map_lookup_elem(&suppressed_events, &reason);
+    if (count) {
+        (*count)++;
+    }
+}
+
+// Refills b for the time elapsed since its last call and takes one token if there is one.
+// A new bucket (last_ns == 0) starts full.
+//
+// The bucket is shared between CPUs, so the token is taken by an atomic add and checked
+// afterwards: a take that finds the bucket overdrawn gives its token back and fails, so
+// concurrent takes never get more tokens than the bucket held. (Using the value returned
+// by __sync_fetch_and_add would need BPF_FETCH, Linux 5.12.) Two CPUs refilling from the
+// same last_ns both credit the interval; that excess is bounded by the time between calls
+// and by the capacity.
+static __always_inline int take_token(struct bucket_t *b, u64 rate, u64 burst, u64 now)
+{
+    s64 capacity = burst * NS_PER_SEC;
+    u64 last = b->last_ns;
+    if (now > last) {
+        s64 refill = capacity;
+        if (last != 0) {
+            u64 elapsed = now - last;
+            // Bounds the product below; a bucket is full again well within 10s at the allowed rates
+            if (elapsed > 10 * NS_PER_SEC) {
+                elapsed = 10 * NS_PER_SEC;
+            }
+            refill = elapsed * rate;
+        }
+        b->last_ns = now;
+        __sync_fetch_and_add(&b->tokens, refill);
+        s64 tokens = b->tokens;
+        if (tokens > capacity) {
+            __sync_fetch_and_add(&b->tokens, capacity - tokens);
+        }
+    }
+
+    __sync_fetch_and_add(&b->tokens, -(s64)NS_PER_SEC);
+    if (b->tokens < 0) {
+        __sync_fetch_and_add(&b->tokens, NS_PER_SEC);
+        return 0;
+    }
+    return 1;
+}
+
+// Decides whether a matching call is emitted: 1-in-N sampling first, then the
+// per-PID bucket (so a noisy process cannot drain the global one), then the global bucket
+static __always_inline int rate_allowed(u32 pid, u32 *sample_rate)
+{
+    u32 zero = 0;
+    struct rate_config_t *cfg = bpf_map_lookup_elem(&rate_config, &zero);
+    *sample_rate = 1;
+    if (!cfg) {
+        return 1;
+    }
+
+    if (cfg->sample_every > 1) {
+        *sample_rate = cfg->sample_every;
+        u32 *n = bpf_map_lookup_elem(&sample_counter, &zero);
+        if (n) {
+            u32 pos = *n;
+            *n = pos + 1 >= cfg->sample_every ? 0 : pos + 1;
+            if (pos != 0) {
+                inc_suppressed(SUPPRESS_SAMPLED);
+                return 0;
+            }
+        }
+    }
+
+    u64 now = bpf_ktime_get_ns();
+    if (cfg->pid_rate) {
+        struct bucket_t *b = bpf_map_lookup_elem(&pid_buckets, &pid);
+        if (!b) {
+            struct bucket_t fresh = {};
+            bpf_map_update_elem(&pid_buckets, &pid, &fresh, BPF_NOEXIST);
+            b = bpf_map_lookup_elem(&pid_buckets, &pid);
+        }
+        if (b && !take_token(b, cfg->pid_rate, cfg->pid_burst, now)) {
+            inc_suppressed(SUPPRESS_PID_LIMIT);
+            return 0;
+        }
+    }
+    if (cfg->global_rate) {
+        struct bucket_t *b = bpf_map_lookup_elem(&global_bucket, &zero);
+        if (b && !take_token(b, cfg->global_rate, cfg->global_burst, now)) {
+            inc_suppressed(SUPPRESS_GLOBAL_LIMIT);
+            return 0;
+        }
+    }
+    return 1;
+}
+
+// Per-CPU syscall counters for every matching call; LRU so exited PIDs age out
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct stats_key);
+    __type(value, u64);
+} syscall_counts SEC(".maps");
+
+static __always_inline void count_sys_call(u32 pid, u32 event_type)
+{
+    struct stats_key key = {};
+    key.pid = pid;
+    key.event_type = event_type;
+
+    u64 *count = bpf_map_lookup_elem(&syscall_counts, &key);
+    if (count) {
+        *count += 1;
+        return;
+    }
+
+    u64 one = 1;
+    if (bpf_map_update_elem(&syscall_counts, &key, &one, BPF_NOEXIST)) {
+        // Another CPU inserted the key first
+        count = bpf_map_lookup_elem(&syscall_counts, &key);
+        if (count) {
+            *count += 1;
+        }
+    }
+}
+
+static __always_inline void fill_data(struct data_t *data, u64 pid_tgid, u32 event_type, u32 match, u64 arg0, u64 arg2, u32 sample_rate)
+{
+    u64 uid_gid = bpf_get_current_uid_gid();
+
+    data->pid = pid_tgid >> 32;
+    data->event_type = event_type;
+    data->tid = (u32)pid_tgid;
+    data->uid = (u32)uid_gid;
+    data->gid = uid_gid >> 32;
+    data->cpu = bpf_get_smp_processor_id();
+    data->cgroup_id = bpf_get_current_cgroup_id();
+    data->timestamp_ns = bpf_ktime_get_ns();
+    data->arg0 = arg0;
+    data->arg2 = arg2;
+    data->match = match;
+    data->sample_rate = sample_rate;
+    bpf_get_current_comm(&data->comm, sizeof(data->comm));
+}
+
+// The current cgroup is in the map, or one of its ancestors is, with descendants included
+static __always_inline int cgroup_in(void *cgroups)
+{
+    u64 cgid = bpf_get_current_cgroup_id();
+    if (bpf_map_lookup_elem(cgroups, &cgid)) {
+        return 1;
+    }
+    if (!cgroup_ancestors) {
+        return 0;
+    }
+
+#pragma unroll
+    for (int level = 0; level < MAX_CGROUP_DEPTH; level++) {
+        u64 ancestor = bpf_get_current_ancestor_cgroup_id(level);
+        // 0 past the current cgroup's own level
+        if (ancestor == 0 || ancestor == cgid) {
+            break;
+        }
+        u32 *descendants = bpf_map_lookup_elem(cgroups, &ancestor);
+        if (descendants && *descendants) {
+            return 1;
+        }
+    }
+    return 0;
+}
+
+// Target list mode: the PID is checked first, then the task name, then the cgroup
+static __always_inline u32 target_match(u32 pid)
+{
+    if (bpf_map_lookup_elem(&target_pids, &pid)) {
+        return MATCH_PID;
+    }
+
+    // Zero-initialized so the key stays NUL-padded past the name
+    struct comm_key key = {};
+    bpf_get_current_comm(&key.comm, sizeof(key.comm));
+    if (bpf_map_lookup_elem(&target_comms, &key)) {
+        return MATCH_COMM;
+    }
+
+    if (cgroup_in(&target_cgroups)) {
+        return MATCH_CGROUP;
+    }
+    return MATCH_NONE;
+}
+
+// Exclusions: the PID is checked first, then the task name, then the cgroup
+static __always_inline int excluded(u32 pid)
+{
+    if (bpf_map_lookup_elem(&skip_pid, &pid)) {
+        return 1;
+    }
+
+    struct comm_key key = {};
+    bpf_get_current_comm(&key.comm, sizeof(key.comm));
+    if (bpf_map_lookup_elem(&skip_comms, &key)) {
+        return 1;
+    }
+
+    return cgroup_in(&skip_cgroups);
+}
+
+// Sends one record to userspace through the transport selected at load time
+static __always_inline void emit_event(void *ctx, u64 pid_tgid, u32 event_type, u32 match, u64 arg0, u64 arg2, u32 sample_rate)
+{
+    if (use_ringbuf) {
+        struct data_t *rec = bpf_ringbuf_reserve(&ringbuf_events, sizeof(*rec), 0);
+        if (!rec) {
+            inc_dropped(DROP_RINGBUF_RESERVE);
+            return;
+        }
+        fill_data(rec, pid_tgid, event_type, match, arg0, arg2, sample_rate);
+        bpf_ringbuf_submit(rec, 0);
+        return;
+    }
+
+    struct data_t data = {};
+    fill_data(&data, pid_tgid, event_type, match, arg0, arg2, sample_rate);
+    int ret = bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, &data, sizeof(data));
+    if (ret) {
+        inc_dropped(DROP_PERF_OUTPUT);
+    }
+}
+
+static __always_inline int handle_sys_call(void *ctx, u32 event_type, u64 arg0, u64 arg2)
 {
-    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    u32 pid = pid_tgid >> 32;
 
-    // Skip self
-    u32 *skip_val = bpf_map_lookup_elem(&skip_pid, &pid);
-    if (skip_val) {
+    // Skip self and excluded processes
+    if (excluded(pid)) {
         return 0;
     }
 
     // Check print_all flag
     u32 flag_key = 0;
     u32 *print_all = bpf_map_lookup_elem(&print_all_flag, &flag_key);
+    u32 match = MATCH_ALL;
 
     if (print_all && *print_all != 1) {
-        // Only allow if PID is targeted
-        u32 *target_val = bpf_map_lookup_elem(&target_pids, &pid);
-        if (!target_val) {
+        // Only allow if the PID, the task name or the cgroup is targeted
+        match = target_match(pid);
+        if (match == MATCH_NONE) {
             return 0;
         }
     }
 
-    struct data_t data = {};
-    data.pid = pid;
-    data.event_type = event_type;
-    int ret = bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, &data, sizeof(data));
-    if (ret) {
-        // optional: inc_dropped();
+    count_sys_call(pid, event_type);
+
+    struct inflight_t start = {};
+    start.start_ns = bpf_ktime_get_ns();
+    start.event_type = event_type;
+    bpf_map_update_elem(&inflight, &pid_tgid, &start, BPF_ANY);
+
+    // Counted and timed above even when suppressed here
+    u32 sample_rate;
+    if (!rate_allowed(pid, &sample_rate)) {
         return 0;
     }
+    emit_event(ctx, pid_tgid, event_type, match, arg0, arg2, sample_rate);
     return 0;
 }
 
+static __always_inline void count_errno(u32 pid, u32 event_type, u32 err)
+{
+    struct errno_key key = {};
+    key.pid = pid;
+    key.event_type = event_type;
+    key.err = err;
+
+    u64 *count = bpf_map_lookup_elem(&syscall_errors, &key);
+    if (count) {
+        *count += 1;
+        return;
+    }
+    u64 one = 1;
+    if (bpf_map_update_elem(&syscall_errors, &key, &one, BPF_NOEXIST)) {
+        count = bpf_map_lookup_elem(&syscall_errors, &key);
+        if (count) {
+            *count += 1;
+        }
+    }
+}
+
+// Pairs an exit with the recorded entry of the same thread and aggregates it
+static __always_inline int handle_sys_exit(long ret)
+{
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct inflight_t *start = bpf_map_lookup_elem(&inflight, &pid_tgid);
+    if (!start) {
+        return 0;
+    }
+
+    u64 duration = bpf_ktime_get_ns() - start->start_ns;
+    struct stats_key key = {};
+    key.pid = pid_tgid >> 32;
+    key.event_type = start->event_type;
+    bpf_map_delete_elem(&inflight, &pid_tgid);
+
+    struct exit_stats_t *st = bpf_map_lookup_elem(&syscall_exits, &key);
+    if (!st) {
+        struct exit_stats_t zero = {};
+        bpf_map_update_elem(&syscall_exits, &key, &zero, BPF_NOEXIST);
+        st = bpf_map_lookup_elem(&syscall_exits, &key);
+        if (!st) {
+            return 0;
+        }
+    }
+
+    st->calls += 1;
+    st->total_ns += duration;
+    if (duration > st->max_ns) {
+        st->max_ns = duration;
+    }
+    if (ret < 0) {
+        st->errors += 1;
+        count_errno(key.pid, key.event_type, (u32)-ret);
+    } else {
+        st->bytes += ret;
+    }
+    return 0;
+}
+
+static __always_inline int handle_sys_enter(struct sys_enter_ctx *ctx, void *events_map)
+{
+    u32 nr = ctx->syscall_nr;
+    u32 *event_type = bpf_map_lookup_elem(events_map, &nr);
+    if (!event_type) {
+        return 0;
+    }
+
+    // Read through a helper: syscalls with fewer than 3 arguments have a shorter
+    // tracepoint record, and direct ctx access past it would make the attach fail
+    u64 args[3] = {};
+    bpf_probe_read(&args, sizeof(args), &ctx->args[0]);
+    return handle_sys_call(ctx, *event_type, args[0], args[2]);
+}
+
+// First and third argument from the user registers saved at syscall entry
+static __always_inline void read_syscall_args(struct pt_regs *regs, u64 *arg0, u64 *arg2)
+{
+    bpf_probe_read(arg0, sizeof(*arg0), &PT_REGS_PARM1(regs));
+    bpf_probe_read(arg2, sizeof(*arg2), &PT_REGS_PARM3(regs));
+}
+
+static __always_inline void kprobe_syscall_args(struct pt_regs *ctx, u64 *arg0, u64 *arg2)
+{
+    if (syscall_wrapper) {
+        read_syscall_args((struct pt_regs *)PT_REGS_PARM1(ctx), arg0, arg2);
+        return;
+    }
+    *arg0 = PT_REGS_PARM1(ctx);
+    *arg2 = PT_REGS_PARM3(ctx);
+}
+
+// Preferred: attached to syscalls:sys_enter_<name> for every traced syscall
+SEC("tracepoint/syscalls/sys_enter")
+int tp_sys_enter(struct sys_enter_ctx *ctx)
+{
+    return handle_sys_enter(ctx, &syscall_events);
+}
+
+// Fallback when per-syscall tracepoints are missing: one hook for all syscalls
+SEC("tracepoint/raw_syscalls/sys_enter")
+int raw_tp_sys_enter(struct sys_enter_ctx *ctx)
+{
+    return handle_sys_enter(ctx, &raw_syscall_events);
+}
+
+SEC("tracepoint/syscalls/sys_exit")
+int tp_sys_exit(struct sys_exit_ctx *ctx)
+{
+    return handle_sys_exit(ctx->ret);
+}
+
+SEC("tracepoint/raw_syscalls/sys_exit")
+int raw_tp_sys_exit(struct sys_exit_ctx *ctx)
+{
+    return handle_sys_exit(ctx->ret);
+}
+
+// fentry targets are resolved at load time (__x64_sys_read, __arm64_sys_read, ...)
+// ctx[0] is the wrapper's only argument, the user pt_regs
+SEC("fentry/sys_read")
+int fentry_sys_read(u64 *ctx)
+{
+    u64 arg0 = 0, arg2 = 0;
+    read_syscall_args((struct pt_regs *)ctx[0], &arg0, &arg2);
+    return handle_sys_call(ctx, EVT_READ, arg0, arg2);
+}
+
+SEC("fentry/sys_write")
+int fentry_sys_write(u64 *ctx)
+{
+    u64 arg0 = 0, arg2 = 0;
+    read_syscall_args((struct pt_regs *)ctx[0], &arg0, &arg2);
+    return handle_sys_call(ctx, EVT_WRITE, arg0, arg2);
+}
+
+// ctx[1] is the wrapper's return value
+SEC("fexit/sys_read")
+int fexit_sys_read(u64 *ctx)
+{
+    return handle_sys_exit((long)ctx[1]);
+}
+
+SEC("fexit/sys_write")
+int fexit_sys_write(u64 *ctx)
+{
+    return handle_sys_exit((long)ctx[1]);
+}
+
+// Last resort: kprobes on the symbol found in /proc/kallsyms
 SEC("kprobe/sys_read")
 int sys_read_call(struct pt_regs *ctx)
 {
-    return handle_sys_call(ctx, EVT_READ);
+    u64 arg0 = 0, arg2 = 0;
+    kprobe_syscall_args(ctx, &arg0, &arg2);
+    return handle_sys_call(ctx, EVT_READ, arg0, arg2);
 }
 
-SEC("kprobe/sys_read")
+SEC("kprobe/sys_write")
 int sys_write_call(struct pt_regs *ctx)
 {
-    return handle_sys_call(ctx, EVT_WRITE);
+    u64 arg0 = 0, arg2 = 0;
+    kprobe_syscall_args(ctx, &arg0, &arg2);
+    return handle_sys_call(ctx, EVT_WRITE, arg0, arg2);
+}
+
+SEC("kretprobe/sys_read")
+int sys_read_ret(struct pt_regs *ctx)
+{
+    return handle_sys_exit(PT_REGS_RC(ctx));
+}
+
+SEC("kretprobe/sys_write")
+int sys_write_ret(struct pt_regs *ctx)
+{
+    return handle_sys_exit(PT_REGS_RC(ctx));
+}
+
+// Children of targets flagged TARGET_FOLLOW become targets themselves, recursively.
+// Runs in the context of the forking task.
+static __always_inline int track_fork(void *ctx, u64 pid_tgid, u32 child, u64 start_time)
+{
+    u32 parent = pid_tgid >> 32;
+
+    struct target_t *target = bpf_map_lookup_elem(&target_pids, &parent);
+    if (!target || !(target->flags & TARGET_FOLLOW)) {
+        return 0;
+    }
+    if (child == 0 || child == parent) {
+        return 0;
+    }
+
+    struct target_t inherited = {};
+    inherited.flags = TARGET_FOLLOW | TARGET_INHERITED;
+    inherited.parent = parent;
+    inherited.start_time = start_time;
+    // Explicit targets keep their own flags
+    if (bpf_map_update_elem(&target_pids, &child, &inherited, BPF_NOEXIST)) {
+        return 0;
+    }
+
+    emit_event(ctx, pid_tgid, EVT_PROCESS_FORK, MATCH_PID, child, 0, 1);
+    return 0;
+}
+
+// Preferred (Linux 5.5+ with BTF): ctx[1] is the child task, so new threads,
+// already matched through their process, are left out here
+SEC("tp_btf/sched_process_fork")
+int btf_sched_process_fork(u64 *ctx)
+{
+    struct task_struct *child = (struct task_struct *)ctx[1];
+    if (child->pid != child->tgid) {
+        return 0;
+    }
+    return track_fork(ctx, bpf_get_current_pid_tgid(), child->pid, child->start_boottime / NS_PER_PROC_TICK);
+}
+
+// Fallback: the record only has the child's ID, so thread creation is tracked
+// too and userspace drops those entries, and the start time is unknown
+SEC("tracepoint/sched/sched_process_fork")
+int tp_sched_process_fork(void *ctx)
+{
+    u32 child = 0;
+    bpf_probe_read(&child, sizeof(child), (char *)ctx + fork_child_pid_offset);
+    return track_fork(ctx, bpf_get_current_pid_tgid(), child, 0);
+}
+
+// Exited targets leave the target list, so a recycled PID is not monitored by mistake.
+// Only the exit of the whole thread group is reported. tp_sched_process_fork also
+// tracks thread creation, so another thread may own an entry keyed by its TID until
+// userspace drops it; that entry is deleted silently when the thread exits.
+static __always_inline int track_exit(void *ctx, u64 pid_tgid, int group_dead)
+{
+    u32 pid = pid_tgid >> 32;
+    u32 tid = (u32)pid_tgid;
+    if (pid != tid) {
+        bpf_map_delete_elem(&target_pids, &tid);
+    }
+    if (!group_dead) {
+        return 0;
+    }
+
+    if (bpf_map_delete_elem(&target_pids, &pid)) {
+        return 0;
+    }
+
+    emit_event(ctx, pid_tgid, EVT_PROCESS_EXIT, MATCH_PID, 0, 0, 1);
+    return 0;
+}
+
+// Preferred (Linux 5.5+ with BTF): the group is dead when its last thread exits, which
+// is not the leader if the leader called pthread_exit or another thread ran execve.
+// do_exit decrements signal->live before this tracepoint, so the last thread sees 0.
+SEC("tp_btf/sched_process_exit")
+int btf_sched_process_exit(u64 *ctx)
+{
+    struct task_struct *task = (struct task_struct *)ctx[0];
+    return track_exit(ctx, bpf_get_current_pid_tgid(), task->signal->live.counter == 0);
+}
+
+// Fallback: the exit of the leader stands for the exit of the group
+SEC("tracepoint/sched/sched_process_exit")
+int tp_sched_process_exit(void *ctx)
+{
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    return track_exit(ctx, pid_tgid, (u32)pid_tgid == pid_tgid >> 32);
 }
 
 char _license[] SEC("license") = "GPL";
@@ -5,42 +5,262 @@ import (
 	"encoding/binary"
 	"errors"
 	"os"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
 )
 
-// Data structure matching the C struct
-// struct data_t { u32 pid; u32 event_type; } in ebpf_probe.c
+// Data is a decoded struct data_t from ebpf_probe.c
 type Data struct {
-	Pid       uint32
-	EventType uint32
+	Pid         uint32 `json:"pid"`
+	Tid         uint32 `json:"tid"`
+	EventType   uint32 `json:"event_type"`
+	Syscall     string `json:"syscall"`
+	Uid         uint32 `json:"uid"`
+	Gid         uint32 `json:"gid"`
+	Comm        string `json:"comm"`
+	CgroupID    uint64 `json:"cgroup_id"`
+	TimestampNs uint64 `json:"timestamp_ns"` // bpf_ktime_get_ns: monotonic, since boot
+	CPU         uint32 `json:"cpu"`
+	FD          int64  `json:"fd"`    // -1 when the syscall has no fd argument
+	Count       uint64 `json:"count"` // requested byte count, 0 when not applicable
+	Match       string `json:"match"` // filter rule that let the event through: all, pid, comm or cgroup
+	ChildPid    uint32 `json:"child_pid,omitempty"` // process_fork: the new target
+	SampleRate  uint32 `json:"sample_rate"`         // 1-in-N sampling in effect; each event stands for N calls
 }
 
+// dataSize is sizeof(struct data_t)
+const dataSize = 80
+
+// dropReasons is DROP_REASONS in ebpf_probe.c, the number of dropped_events entries
+const dropReasons = 2
+
+// Filter rules reported in struct data_t.match (MATCH_* in ebpf_probe.c)
+const (
+	matchAll    = 1
+	matchPID    = 2
+	matchComm   = 3
+	matchCgroup = 4
+)
+
+// matchRuleName maps a MATCH_* value to the name used in events and the API
+func matchRuleName(rule uint32) string {
+	switch rule {
+	case matchAll:
+		return "all"
+	case matchPID:
+		return "pid"
+	case matchComm:
+		return "comm"
+	case matchCgroup:
+		return "cgroup"
+	default:
+		return "unknown"
+	}
+}
+
+// commKeyLen is COMM_LEN in ebpf_probe.c, including the trailing NUL
+const commKeyLen = 16
+
+// commKey matches struct comm_key in ebpf_probe.c
+type commKey [commKeyLen]byte
+
+// newCommKey validates a task name and NUL-pads it like bpf_get_current_comm
+func newCommKey(comm string) (commKey, error) {
+	var key commKey
+	if comm == "" {
+		return key, errors.New("comm must not be empty")
+	}
+	if len(comm) >= commKeyLen {
+		return key, errors.New("comm must be at most 15 bytes: " + comm)
+	}
+	copy(key[:], comm)
+	return key, nil
+}
+
+// decodeData parses a raw struct data_t sample; false if the sample is too short
+func decodeData(sample []byte) (Data, bool) {
+	if len(sample) < dataSize {
+		return Data{}, false
+	}
+	le := binary.LittleEndian
+	event := Data{
+		Pid:         le.Uint32(sample[0:4]),
+		EventType:   le.Uint32(sample[4:8]),
+		Tid:         le.Uint32(sample[8:12]),
+		Uid:         le.Uint32(sample[12:16]),
+		Gid:         le.Uint32(sample[16:20]),
+		CPU:         le.Uint32(sample[20:24]),
+		CgroupID:    le.Uint64(sample[24:32]),
+		TimestampNs: le.Uint64(sample[32:40]),
+		Comm:        commString(sample[56:72]),
+		Match:       matchRuleName(le.Uint32(sample[72:76])),
+		SampleRate:  le.Uint32(sample[76:80]),
+	}
+	if event.SampleRate == 0 {
+		event.SampleRate = 1
+	}
+	event.Syscall = eventTypeName(event.EventType)
+	event.FD, event.Count = syscallArgs(event.EventType, le.Uint64(sample[40:48]), le.Uint64(sample[48:56]))
+	if event.EventType == evtProcessFork {
+		event.ChildPid = uint32(le.Uint64(sample[40:48]))
+	}
+	return event, true
+}
+
+// deliverSample decodes one raw sample and passes the event to handler. The eBPF
+// reader and capture replay share it, so both decode and count the same way.
+func deliverSample(sample []byte, handler EventHandler, decodeErrors, delivered *atomic.Uint64, logger Logger) {
+	event, ok := decodeData(sample)
+	if !ok {
+		decodeErrors.Add(1)
+		logger.Debugf("Dropping malformed %d-byte sample", len(sample))
+		return
+	}
+	delivered.Add(1)
+	handler(event)
+}
+
+// commString converts a NUL-padded task comm to a string
+func commString(raw []byte) string {
+	if i := bytes.IndexByte(raw, 0); i >= 0 {
+		raw = raw[:i]
+	}
+	return string(raw)
+}
+
+// Event types with dedicated programs in ebpf_probe.c; see syscallRegistry for all of them
 const (
 	evtRead  = 1
 	evtWrite = 2
 )
 
+// syscallCountsKey matches struct stats_key in ebpf_probe.c
+type syscallCountsKey struct {
+	Pid       uint32
+	EventType uint32
+}
+
+// syscallExitValue matches struct exit_stats_t in ebpf_probe.c
+type syscallExitValue struct {
+	Calls   uint64
+	Errors  uint64
+	TotalNs uint64
+	MaxNs   uint64
+	Bytes   uint64
+}
+
+// syscallErrnoKey matches struct errno_key in ebpf_probe.c
+type syscallErrnoKey struct {
+	Pid       uint32
+	EventType uint32
+	Errno     uint32
+}
+
+// logEvent is the default EventHandler: one log line per event
+func logEvent(logger Logger, event Data) {
+	if event.EventType == evtProcessFork {
+		logger.Infof("%d - process forked child %d (comm=%q, now a target)", event.Pid, event.ChildPid, event.Comm)
+		return
+	}
+	if event.EventType == evtProcessExit {
+		logger.Infof("%d - target process exited (comm=%q, removed from targets)", event.Pid, event.Comm)
+		return
+	}
+	if _, ok := lookupEventType(event.EventType); !ok {
+		logger.Infof("%d - unknown event %d", event.Pid, event.EventType)
+		return
+	}
+	logger.Infof("%d - hello sys_%s was called (tid=%d comm=%q uid=%d gid=%d cgroup=%d cpu=%d fd=%d count=%d ts=%d match=%s)",
+		event.Pid, event.Syscall, event.Tid, event.Comm, event.Uid, event.Gid, event.CgroupID, event.CPU, event.FD, event.Count, event.TimestampNs, event.Match)
+}
+
+// ProbeOptions tunes the eBPF probe; zero values select the defaults
+type ProbeOptions struct {
+	// Transport forces TransportRingBuf or TransportPerf; empty picks the best supported one
+	Transport string `json:"transport" yaml:"transport"`
+	// RingBufferSize in bytes, shared by all CPUs (power of two, multiple of the page size)
+	RingBufferSize int `json:"ring_buffer_size" yaml:"ring_buffer_size"`
+	// PerfBufferSize in bytes, per CPU (fallback transport)
+	PerfBufferSize int `json:"perf_buffer_size" yaml:"perf_buffer_size"`
+	// AttachMode forces AttachTracepoint, AttachFentry or AttachKprobe; empty picks the best per syscall
+	AttachMode string `json:"attach_mode" yaml:"attach_mode"`
+	// Syscalls to trace at startup, by registry name; empty means read and write
+	Syscalls []string `json:"syscalls" yaml:"syscalls"`
+	// PinPath, on a bpffs (e.g. /sys/fs/bpf/ebpf-game), keeps target_pids, skip_pid
+	// and print_all_flag across restarts; empty disables pinning
+	PinPath string `json:"pin_path" yaml:"pin_path"`
+	// MapSizes overrides max_entries of the maps listed in resizableMaps
+	MapSizes map[string]uint32 `json:"map_sizes" yaml:"map_sizes"`
+}
+
+// resizableMaps are the maps whose max_entries ProbeOptions.MapSizes may override
+var resizableMaps = []string{
+	"target_pids", "target_comms", "target_cgroups",
+	"skip_pid", "skip_comms", "skip_cgroups",
+	"syscall_counts", "inflight", "syscall_exits", "syscall_errors",
+}
+
+func isResizableMap(name string) bool {
+	for _, m := range resizableMaps {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// configureMapSizes applies ProbeOptions.MapSizes to the spec before load
+func configureMapSizes(spec *ebpf.CollectionSpec, sizes map[string]uint32) error {
+	for name, size := range sizes {
+		ms, ok := spec.Maps[name]
+		if !ok || !isResizableMap(name) {
+			return errors.New("map " + name + " cannot be resized")
+		}
+		if size == 0 {
+			return errors.New("map " + name + " needs at least one entry")
+		}
+		ms.MaxEntries = size
+	}
+	return nil
+}
+
 // EBpfProbe handles eBPF monitoring
 type EBpfProbe struct {
-	objs      *ebpf_probeObjects
-	readLink  link.Link
-	writeLink link.Link
-	rd        *perf.Reader
-	logger    Logger
-	stopCh    chan struct{}
+	objs            *ebpf_probeObjects
+	attacher        *attacher
+	rd              eventReader
+	transport       string
+	cgroupAncestors bool
+	followChildren  bool
+	pinPath         string
+	reusedPins      bool
+	logger          Logger
+	handler         EventHandler
+	stopCh          chan struct{}
+	lostSamples     atomic.Uint64
+	readErrors      atomic.Uint64
+	decodeErrors    atomic.Uint64
+	delivered       atomic.Uint64
+	recorder        *CaptureWriter
+}
+
+// syscallSymbolCandidates lists the per-arch kernel function names of a syscall
+func syscallSymbolCandidates(base string) []string {
+	return []string{
+		"__x64_sys_" + base,
+		"__arm64_sys_" + base,
+		"__arm_sys_" + base,
+		"sys_" + base,
+	}
 }
 
 func findSyscallSymbol(base string, logger Logger) (string, error) {
-    candidates := []string{
-        "__x64_sys_" + base,
-        "__arm64_sys_" + base,
-        "__arm_sys_" + base,
-        "sys_" + base,
-    }
+    candidates := syscallSymbolCandidates(base)
     data, err := os.ReadFile("/proc/kallsyms")
     if err != nil {
         return "", err
@@ -55,82 +275,250 @@ func findSyscallSymbol(base string, logger Logger) (string, error) {
 }
 
 // NewEBpfProbe creates a new eBPF monitor instance
-func NewEBpfProbe(logger Logger) (*EBpfProbe, error) {
-	// Load the eBPF program
+func NewEBpfProbe(logger Logger, opts ProbeOptions) (*EBpfProbe, error) {
+	if opts.RingBufferSize == 0 {
+		opts.RingBufferSize = defaultRingBufferSize
+	}
+	if opts.PerfBufferSize == 0 {
+		opts.PerfBufferSize = defaultPerfBufferSize
+	}
+	if len(opts.Syscalls) == 0 {
+		opts.Syscalls = defaultSyscalls
+	}
+	for _, name := range opts.Syscalls {
+		if _, ok := lookupSyscall(name); !ok {
+			logger.Errorf("unknown syscall in configuration: %s", name)
+			return nil, errors.New("unknown syscall in configuration: " + name)
+		}
+	}
+
+	// Choose the event transport before loading: the ring buffer map cannot be created on kernels < 5.8
+	transport, err := selectTransport(opts.Transport, logger)
+	if err != nil {
+		logger.Errorf("failed to select event transport: %v", err)
+		return nil, errors.New("failed to select event transport: " + err.Error())
+	}
+
+	spec, err := loadEbpf_probe()
+	if err != nil {
+		logger.Errorf("failed to load eBPF spec: %v", err)
+		return nil, errors.New("failed to load eBPF spec: " + err.Error())
+	}
+	if err := configureTransport(spec, transport, opts); err != nil {
+		logger.Errorf("failed to configure %s transport: %v", transport, err)
+		return nil, errors.New("failed to configure " + transport + " transport: " + err.Error())
+	}
+
+	cgroupAncestors, err := configureCgroupTargeting(spec, logger)
+	if err != nil {
+		logger.Errorf("failed to configure cgroup targeting: %v", err)
+		return nil, errors.New("failed to configure cgroup targeting: " + err.Error())
+	}
+
+	if err := configureProcessTracking(spec, logger); err != nil {
+		logger.Errorf("failed to configure process tracking: %v", err)
+		return nil, errors.New("failed to configure process tracking: " + err.Error())
+	}
+
+	if err := configureMapSizes(spec, opts.MapSizes); err != nil {
+		logger.Errorf("failed to configure map sizes: %v", err)
+		return nil, errors.New("failed to configure map sizes: " + err.Error())
+	}
+
+	// Pinning is optional: without it the state file still restores the targets
+	pinPath := opts.PinPath
+	reusedPins := false
+	if pinPath != "" {
+		reusedPins, err = configurePinning(spec, pinPath)
+		if err != nil {
+			logger.Warnf("Cannot pin maps under %s, targets are restored from the state file only: %v", pinPath, err)
+			pinPath = ""
+		}
+	}
+
+	// Load the eBPF maps; programs are loaded by the attacher as they get attached
 	objs := ebpf_probeObjects{}
-	if err := loadEbpf_probeObjects(&objs, nil); err != nil {
+	att, err := newAttacher(spec, &objs.ebpf_probeMaps, opts.AttachMode, pinPath, logger)
+	if err != nil && errors.Is(err, ebpf.ErrMapIncompatible) {
+		// Left by a build with another map layout
+		logger.Warnf("Pinned maps under %s do not match this build, discarding them: %v", pinPath, err)
+		if rmErr := removePinnedMaps(pinPath); rmErr != nil {
+			logger.Errorf("failed to remove pinned maps: %v", rmErr)
+			return nil, errors.New("failed to remove pinned maps: " + rmErr.Error())
+		}
+		reusedPins = false
+		objs = ebpf_probeObjects{}
+		att, err = newAttacher(spec, &objs.ebpf_probeMaps, opts.AttachMode, pinPath, logger)
+	}
+	if err != nil {
 		logger.Errorf("failed to load eBPF objects: %v", err)
 		return nil, errors.New("failed to load eBPF objects: " + err.Error())
 	}
 
 	// Skip this PID
-	pid := uint32(os.Getpid())
-	err := objs.SkipPid.Update(&pid, &pid, ebpf.UpdateAny)
+	pid := selfPID()
+	skipValue := uint32(skipSelf)
+	err = objs.SkipPid.Update(&pid, &skipValue, ebpf.UpdateAny)
 	if err != nil {
+		att.close()
 		objs.Close()
 		logger.Errorf("failed to set skip PID: %v", err)
 		return nil, errors.New("failed to set skip PID: " + err.Error())
 	}
 
-	// Initialize print_all flag to 0 (disabled)
+	// Initialize print_all flag to 0 (disabled), unless a previous run left it pinned
 	flagKey := uint32(0)
 	flagValue := uint32(0)
-	err = objs.PrintAllFlag.Update(&flagKey, &flagValue, ebpf.UpdateAny)
-	if err != nil {
-		objs.Close()
-		logger.Errorf("failed to initialize print_all flag: %v", err)
-		return nil, errors.New("failed to initialize print_all flag: " + err.Error())
+	if !reusedPins || objs.PrintAllFlag.Lookup(&flagKey, &flagValue) != nil {
+		err = objs.PrintAllFlag.Update(&flagKey, &flagValue, ebpf.UpdateAny)
+		if err != nil {
+			att.close()
+			objs.Close()
+			logger.Errorf("failed to initialize print_all flag: %v", err)
+			return nil, errors.New("failed to initialize print_all flag: " + err.Error())
+		}
+	}
+
+	if reusedPins {
+		if err := pruneReusedMaps(&objs, pid, logger); err != nil {
+			att.close()
+			objs.Close()
+			logger.Errorf("failed to validate pinned maps: %v", err)
+			return nil, errors.New("failed to validate pinned maps: " + err.Error())
+		}
 	}
 
 	logger.Infof("Loading eBPF program")
-	logger.Infof("Monitoring sys_read and sys_write calls...")
+	logger.Infof("Monitoring syscalls: %v", opts.Syscalls)
+	logger.Infof("Event transport: %s", transport)
 	logger.Infof("Host PID: %d", pid)
 	logger.Infof("Skipping self PID: %d", pid)
-	logger.Infof("Initial state: No PIDs in target list, print_all disabled")
+	if reusedPins {
+		logger.Infof("Initial state: reused pinned maps under %s, print_all=%v", pinPath, flagValue == 1)
+	} else {
+		logger.Infof("Initial state: No PIDs in target list, print_all disabled")
+	}
+
+	// Attach each syscall with the best mechanism the kernel supports
+	for _, name := range opts.Syscalls {
+		sc, _ := lookupSyscall(name)
+		info, err := att.attach(sc)
+		if err != nil {
+			att.close()
+			objs.Close()
+			logger.Errorf("failed to attach sys_%s: %v", sc.name, err)
+			return nil, errors.New("failed to attach sys_" + sc.name + ": " + err.Error())
+		}
+		logger.Infof("Attached sys_%s via %s (%s)", info.Syscall, info.Mechanism, info.Target)
+	}
 
-	readSym, _ := findSyscallSymbol("read", logger)
-	if readSym == "" {
-		return nil, errors.New("no read syscall symbol found")
+	// Child following is optional: targets without follow_children keep working
+	followChildren := true
+	if err := att.attachProcessTracepoint("btf_sched_process_fork", "tp_sched_process_fork", "sched", "sched_process_fork"); err != nil {
+		logger.Warnf("Cannot attach sched:sched_process_fork, following children disabled: %v", err)
+		followChildren = false
 	}
-	writeSym, _ := findSyscallSymbol("write", logger)
-	if writeSym == "" {
-		return nil, errors.New("no write syscall symbol found")
+	if err := att.attachProcessTracepoint("btf_sched_process_exit", "tp_sched_process_exit", "sched", "sched_process_exit"); err != nil {
+		logger.Warnf("Cannot attach sched:sched_process_exit, exited targets stay in target_pids: %v", err)
 	}
 
-	// Attach kprobes
-	readLink, err := link.Kprobe(readSym, objs.SysReadCall, nil)
+	// Set up the ring buffer or perf buffer reader
+	rd, err := newEventReader(&objs, transport, opts)
 	if err != nil {
+		att.close()
 		objs.Close()
-		logger.Errorf("failed to attach sys_read kprobe: %v", err)
-		return nil, errors.New("failed to attach sys_read kprobe: " + err.Error())
+		logger.Errorf("failed to create %s reader: %v", transport, err)
+		return nil, errors.New("failed to create " + transport + " reader: " + err.Error())
 	}
 
-	writeLink, err := link.Kprobe(writeSym, objs.SysWriteCall, nil)
-	if err != nil {
-		readLink.Close()
-		objs.Close()
-		logger.Errorf("failed to attach sys_write kprobe: %v", err)
-		return nil, errors.New("failed to attach sys_write kprobe: " + err.Error())
+	return &EBpfProbe{
+		objs:            &objs,
+		attacher:        att,
+		rd:              rd,
+		transport:       transport,
+		cgroupAncestors: cgroupAncestors,
+		pinPath:         pinPath,
+		reusedPins:      reusedPins,
+		followChildren:  followChildren,
+		logger:          logger,
+		handler:         func(event Data) { logEvent(logger, event) },
+		stopCh:          make(chan struct{}),
+	}, nil
+}
+
+// SetEventHandler replaces the default log handler. Must be called before Start.
+func (em *EBpfProbe) SetEventHandler(handler EventHandler) {
+	em.handler = handler
+}
+
+// SetRecorder writes every raw sample and lost sample count to a capture file
+// before decoding. Must be called before Start.
+func (em *EBpfProbe) SetRecorder(recorder *CaptureWriter) {
+	em.recorder = recorder
+}
+
+// Info reports how the probe delivers events
+func (em *EBpfProbe) Info() ProbeInfo {
+	return ProbeInfo{Transport: em.transport, Attachments: em.attacher.infos(), PinPath: em.pinPath}
+}
+
+// ReaderStats reports the events dropped by the kernel, lost, unreadable and
+// delivered since the probe was created
+func (em *EBpfProbe) ReaderStats() (ReaderStats, error) {
+	stats := ReaderStats{
+		LostSamples:  em.lostSamples.Load(),
+		ReadErrors:   em.readErrors.Load(),
+		DecodeErrors: em.decodeErrors.Load(),
+		Delivered:    em.delivered.Load(),
 	}
+	if em.objs == nil || em.objs.DroppedEvents == nil {
+		return stats, errors.New("eBPF objects not initialized")
+	}
+
+	var perCPU []uint64
+	for reason := uint32(0); reason < dropReasons; reason++ {
+		if err := em.objs.DroppedEvents.Lookup(reason, &perCPU); err != nil {
+			em.logger.Errorf("error reading dropped events: %v", err)
+			return stats, errors.New("error reading dropped events: " + err.Error())
+		}
+		for _, v := range perCPU {
+			stats.KernelDropped += v
+		}
+	}
+	return stats, nil
+}
+
+// ReusedPinnedMaps reports whether target_pids, skip_pid and print_all_flag
+// were left pinned by a previous run rather than created empty
+func (em *EBpfProbe) ReusedPinnedMaps() bool {
+	return em.reusedPins
+}
 
-	// Set up perf buffer (increase size to reduce drops)
-	rd, err := perf.NewReader(objs.Events, 1<<18) // 256KB
+// EnableSyscall starts tracing a registry syscall
+func (em *EBpfProbe) EnableSyscall(name string) (AttachInfo, error) {
+	sc, ok := lookupSyscall(name)
+	if !ok {
+		return AttachInfo{}, errors.New("unknown syscall: " + name)
+	}
+	info, err := em.attacher.attach(sc)
 	if err != nil {
-		readLink.Close()
-		writeLink.Close()
-		objs.Close()
-		logger.Errorf("failed to create perf reader: %v", err)
-		return nil, errors.New("failed to create perf reader: " + err.Error())
+		return AttachInfo{}, err
 	}
+	em.logger.Infof("Attached sys_%s via %s (%s)", info.Syscall, info.Mechanism, info.Target)
+	return info, nil
+}
 
-	return &EBpfProbe{
-		objs:      &objs,
-		readLink:  readLink,
-		writeLink: writeLink,
-		rd:        rd,
-		logger:    logger,
-		stopCh:    make(chan struct{}),
-	}, nil
+// DisableSyscall stops tracing a registry syscall
+func (em *EBpfProbe) DisableSyscall(name string) error {
+	sc, ok := lookupSyscall(name)
+	if !ok {
+		return errors.New("unknown syscall: " + name)
+	}
+	if err := em.attacher.detach(sc); err != nil {
+		return err
+	}
+	em.logger.Infof("Detached sys_%s", name)
+	return nil
 }
 
 // Start begins monitoring
@@ -144,35 +532,30 @@ func (em *EBpfProbe) Start() {
 			default:
 			}
 
-			record, err := em.rd.Read()
+			sample, lost, err := em.rd.Read()
 			if err != nil {
 				// During shutdown, the reader may return various "file already closed" errors.
-				if errors.Is(err, perf.ErrClosed) || errors.Is(err, os.ErrClosed) || strings.Contains(err.Error(), "file already closed") {
+				if errors.Is(err, perf.ErrClosed) || errors.Is(err, ringbuf.ErrClosed) || errors.Is(err, os.ErrClosed) || strings.Contains(err.Error(), "file already closed") {
 					return
 				}
-				em.logger.Errorf("Error reading perf event: %v", err)
+				em.readErrors.Add(1)
+				em.logger.Errorf("Error reading %s event: %v", em.transport, err)
 				continue
 			}
 
-			if record.LostSamples != 0 {
-				em.logger.Warnf("Lost %d samples", record.LostSamples)
+			if lost != 0 {
+				em.lostSamples.Add(lost)
+				em.logger.Warnf("Lost %d samples", lost)
+				if em.recorder != nil {
+					em.recorder.WriteLost(lost)
+				}
 				continue
 			}
 
-			if len(record.RawSample) >= 8 { // sizeof(Data)
-				var event Data
-				event.Pid = binary.LittleEndian.Uint32(record.RawSample[0:4])
-				event.EventType = binary.LittleEndian.Uint32(record.RawSample[4:8])
-
-				switch event.EventType {
-				case evtRead:
-					em.logger.Infof("%d - hello sys_read was called", event.Pid)
-				case evtWrite:
-					em.logger.Infof("%d - hello sys_write was called", event.Pid)
-				default:
-					em.logger.Infof("%d - unknown event %d", event.Pid, event.EventType)
-				}
+			if em.recorder != nil {
+				em.recorder.WriteSample(sample)
 			}
+			deliverSample(sample, em.handler, &em.decodeErrors, &em.delivered, em.logger)
 		}
 	}()
 }
@@ -189,24 +572,31 @@ func (em *EBpfProbe) Stop() {
 	if em.rd != nil {
 		em.rd.Close()
 	}
-	if em.readLink != nil {
-		em.readLink.Close()
-	}
-	if em.writeLink != nil {
-		em.writeLink.Close()
+	if em.attacher != nil {
+		em.attacher.close()
 	}
 	if em.objs != nil {
 		em.objs.Close()
 	}
 }
 
-// AddTargetPID adds a PID to the target list
-func (em *EBpfProbe) AddTargetPID(pid uint32) error {
+// AddTargetPID adds a PID to the target list; with followChildren the processes
+// it forks are added by the kernel, recursively
+func (em *EBpfProbe) AddTargetPID(pid uint32, followChildren bool) error {
 	if em.objs == nil || em.objs.TargetPids == nil {
 		em.logger.Errorf("eBPF objects not initialized")
 		return errors.New("eBPF objects not initialized")
 	}
-	return em.objs.TargetPids.Update(&pid, &pid, ebpf.UpdateAny)
+	if followChildren && !em.followChildren {
+		return errNoFollowChildren
+	}
+	value := targetValue{}
+	if followChildren {
+		value.Flags = targetFollow
+	}
+	// Lets pruneReusedMaps tell a recycled PID apart after a restart; 0 if already gone
+	value.StartTime, _ = processStartTime(pid)
+	return em.objs.TargetPids.Update(&pid, &value, ebpf.UpdateAny)
 }
 
 // RemoveTargetPID removes a PID from the target list
@@ -215,7 +605,13 @@ func (em *EBpfProbe) RemoveTargetPID(pid uint32) error {
 		em.logger.Errorf("eBPF objects not initialized")
 		return errors.New("eBPF objects not initialized")
 	}
-	return em.objs.TargetPids.Delete(&pid)
+	if err := em.objs.TargetPids.Delete(&pid); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return ErrPIDNotTargeted
+		}
+		return err
+	}
+	return nil
 }
 
 // ClearTargetPIDs clears all target PIDs
@@ -228,7 +624,7 @@ func (em *EBpfProbe) ClearTargetPIDs() error {
 	// Iterate and delete all entries
 	iter := em.objs.TargetPids.Iterate()
 	var key uint32
-	var value uint32
+	var value targetValue
 	for iter.Next(&key, &value) {
 		em.objs.TargetPids.Delete(&key)
 	}
@@ -241,6 +637,281 @@ func (em *EBpfProbe) ClearTargetPIDs() error {
 	return nil
 }
 
+// AddTargetComm adds a task name to the target list
+func (em *EBpfProbe) AddTargetComm(comm string) error {
+	if em.objs == nil || em.objs.TargetComms == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	key, err := newCommKey(comm)
+	if err != nil {
+		return err
+	}
+	value := uint32(1)
+	return em.objs.TargetComms.Update(&key, &value, ebpf.UpdateAny)
+}
+
+// RemoveTargetComm removes a task name from the target list
+func (em *EBpfProbe) RemoveTargetComm(comm string) error {
+	if em.objs == nil || em.objs.TargetComms == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	key, err := newCommKey(comm)
+	if err != nil {
+		return err
+	}
+	if err := em.objs.TargetComms.Delete(&key); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return ErrCommNotTargeted
+		}
+		return err
+	}
+	return nil
+}
+
+// GetTargetComms returns all target task names
+func (em *EBpfProbe) GetTargetComms() ([]string, error) {
+	if em.objs == nil || em.objs.TargetComms == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []string{}, errors.New("eBPF objects not initialized")
+	}
+
+	comms := make([]string, 0)
+	iter := em.objs.TargetComms.Iterate()
+	var key commKey
+	var value uint32
+	for iter.Next(&key, &value) {
+		comms = append(comms, commString(key[:]))
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating target comms: %v", iter.Err())
+		return comms, errors.New("error iterating target comms: " + iter.Err().Error())
+	}
+
+	sort.Strings(comms)
+	return comms, nil
+}
+
+// AddTargetCgroup adds a cgroup v2 ID to the target list, optionally with its descendants
+func (em *EBpfProbe) AddTargetCgroup(id uint64, descendants bool) error {
+	if em.objs == nil || em.objs.TargetCgroups == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	if descendants && !em.cgroupAncestors {
+		return errNoCgroupAncestors
+	}
+	value := uint32(0)
+	if descendants {
+		value = 1
+	}
+	return em.objs.TargetCgroups.Update(&id, &value, ebpf.UpdateAny)
+}
+
+// RemoveTargetCgroup removes a cgroup ID from the target list
+func (em *EBpfProbe) RemoveTargetCgroup(id uint64) error {
+	if em.objs == nil || em.objs.TargetCgroups == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	if err := em.objs.TargetCgroups.Delete(&id); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return ErrCgroupNotTargeted
+		}
+		return err
+	}
+	return nil
+}
+
+// GetTargetCgroups returns all target cgroups, without paths
+func (em *EBpfProbe) GetTargetCgroups() ([]CgroupTarget, error) {
+	if em.objs == nil || em.objs.TargetCgroups == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []CgroupTarget{}, errors.New("eBPF objects not initialized")
+	}
+
+	targets := make([]CgroupTarget, 0)
+	iter := em.objs.TargetCgroups.Iterate()
+	var key uint64
+	var value uint32
+	for iter.Next(&key, &value) {
+		targets = append(targets, CgroupTarget{ID: key, Descendants: value != 0})
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating target cgroups: %v", iter.Err())
+		return targets, errors.New("error iterating target cgroups: " + iter.Err().Error())
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].ID < targets[j].ID })
+	return targets, nil
+}
+
+// AddExcludedPID adds a PID to the skip_pid map
+func (em *EBpfProbe) AddExcludedPID(pid uint32) error {
+	if em.objs == nil || em.objs.SkipPid == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	value := uint32(skipExcluded)
+	return em.objs.SkipPid.Update(&pid, &value, ebpf.UpdateAny)
+}
+
+// RemoveExcludedPID removes a PID from the skip_pid map; the monitor's own PID stays
+func (em *EBpfProbe) RemoveExcludedPID(pid uint32) error {
+	if em.objs == nil || em.objs.SkipPid == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	if pid == selfPID() {
+		return errExcludeSelf
+	}
+	if err := em.objs.SkipPid.Delete(&pid); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return ErrPIDNotExcluded
+		}
+		return err
+	}
+	return nil
+}
+
+// GetExcludedPIDs returns all excluded PIDs, including the monitor's own
+func (em *EBpfProbe) GetExcludedPIDs() ([]uint32, error) {
+	if em.objs == nil || em.objs.SkipPid == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []uint32{}, errors.New("eBPF objects not initialized")
+	}
+
+	pids := make([]uint32, 0)
+	iter := em.objs.SkipPid.Iterate()
+	var key, value uint32
+	for iter.Next(&key, &value) {
+		pids = append(pids, key)
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating excluded PIDs: %v", iter.Err())
+		return pids, errors.New("error iterating excluded PIDs: " + iter.Err().Error())
+	}
+
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, nil
+}
+
+// AddExcludedComm adds a task name to the skip_comms map
+func (em *EBpfProbe) AddExcludedComm(comm string) error {
+	if em.objs == nil || em.objs.SkipComms == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	key, err := newCommKey(comm)
+	if err != nil {
+		return err
+	}
+	value := uint32(1)
+	return em.objs.SkipComms.Update(&key, &value, ebpf.UpdateAny)
+}
+
+// RemoveExcludedComm removes a task name from the skip_comms map
+func (em *EBpfProbe) RemoveExcludedComm(comm string) error {
+	if em.objs == nil || em.objs.SkipComms == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	key, err := newCommKey(comm)
+	if err != nil {
+		return err
+	}
+	if err := em.objs.SkipComms.Delete(&key); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return ErrCommNotExcluded
+		}
+		return err
+	}
+	return nil
+}
+
+// GetExcludedComms returns all excluded task names
+func (em *EBpfProbe) GetExcludedComms() ([]string, error) {
+	if em.objs == nil || em.objs.SkipComms == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []string{}, errors.New("eBPF objects not initialized")
+	}
+
+	comms := make([]string, 0)
+	iter := em.objs.SkipComms.Iterate()
+	var key commKey
+	var value uint32
+	for iter.Next(&key, &value) {
+		comms = append(comms, commString(key[:]))
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating excluded comms: %v", iter.Err())
+		return comms, errors.New("error iterating excluded comms: " + iter.Err().Error())
+	}
+
+	sort.Strings(comms)
+	return comms, nil
+}
+
+// AddExcludedCgroup adds a cgroup v2 ID to the skip_cgroups map, optionally with its descendants
+func (em *EBpfProbe) AddExcludedCgroup(id uint64, descendants bool) error {
+	if em.objs == nil || em.objs.SkipCgroups == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	if descendants && !em.cgroupAncestors {
+		return errNoCgroupAncestors
+	}
+	value := uint32(0)
+	if descendants {
+		value = 1
+	}
+	return em.objs.SkipCgroups.Update(&id, &value, ebpf.UpdateAny)
+}
+
+// RemoveExcludedCgroup removes a cgroup ID from the skip_cgroups map
+func (em *EBpfProbe) RemoveExcludedCgroup(id uint64) error {
+	if em.objs == nil || em.objs.SkipCgroups == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	if err := em.objs.SkipCgroups.Delete(&id); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return ErrCgroupNotExcluded
+		}
+		return err
+	}
+	return nil
+}
+
+// GetExcludedCgroups returns all excluded cgroups, without paths
+func (em *EBpfProbe) GetExcludedCgroups() ([]CgroupTarget, error) {
+	if em.objs == nil || em.objs.SkipCgroups == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []CgroupTarget{}, errors.New("eBPF objects not initialized")
+	}
+
+	cgroups := make([]CgroupTarget, 0)
+	iter := em.objs.SkipCgroups.Iterate()
+	var key uint64
+	var value uint32
+	for iter.Next(&key, &value) {
+		cgroups = append(cgroups, CgroupTarget{ID: key, Descendants: value != 0})
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating excluded cgroups: %v", iter.Err())
+		return cgroups, errors.New("error iterating excluded cgroups: " + iter.Err().Error())
+	}
+
+	sort.Slice(cgroups, func(i, j int) bool { return cgroups[i].ID < cgroups[j].ID })
+	return cgroups, nil
+}
+
 // SetPrintAll sets the print_all flag
 func (em *EBpfProbe) SetPrintAll(enabled bool) error {
 	if em.objs == nil || em.objs.PrintAllFlag == nil {
@@ -258,6 +929,56 @@ func (em *EBpfProbe) SetPrintAll(enabled bool) error {
 	return em.objs.PrintAllFlag.Update(&flagKey, &flagValue, ebpf.UpdateAny)
 }
 
+// SetRateLimit replaces the sampling and token-bucket limits applied by handle_sys_call
+func (em *EBpfProbe) SetRateLimit(cfg RateLimitConfig) error {
+	if em.objs == nil || em.objs.RateConfig == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return errors.New("eBPF objects not initialized")
+	}
+	key := uint32(0)
+	value := cfg.value()
+	return em.objs.RateConfig.Update(&key, &value, ebpf.UpdateAny)
+}
+
+// GetRateLimit returns the limits in the rate_config map
+func (em *EBpfProbe) GetRateLimit() (RateLimitConfig, error) {
+	if em.objs == nil || em.objs.RateConfig == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return RateLimitConfig{}, errors.New("eBPF objects not initialized")
+	}
+	key := uint32(0)
+	var value rateConfigValue
+	if err := em.objs.RateConfig.Lookup(&key, &value); err != nil {
+		return RateLimitConfig{}, err
+	}
+	return value.config(), nil
+}
+
+// GetSuppressedCounts returns the matching calls not emitted, by reason, summed over CPUs
+func (em *EBpfProbe) GetSuppressedCounts() (SuppressedCounts, error) {
+	if em.objs == nil || em.objs.SuppressedEvents == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return SuppressedCounts{}, errors.New("eBPF objects not initialized")
+	}
+
+	var sums [suppressReasons]uint64
+	var perCPU []uint64
+	for reason := uint32(0); reason < suppressReasons; reason++ {
+		if err := em.objs.SuppressedEvents.Lookup(reason, &perCPU); err != nil {
+			em.logger.Errorf("error reading suppressed events: %v", err)
+			return SuppressedCounts{}, errors.New("error reading suppressed events: " + err.Error())
+		}
+		for _, v := range perCPU {
+			sums[reason] += v
+		}
+	}
+	return SuppressedCounts{
+		Sampled:       sums[suppressSampled],
+		PIDLimited:    sums[suppressPIDLimit],
+		GlobalLimited: sums[suppressGlobalLimit],
+	}, nil
+}
+
 // GetTargetPIDs returns all target PIDs
 func (em *EBpfProbe) GetTargetPIDs() ([]uint32, error) {
 	if em.objs == nil || em.objs.TargetPids == nil {
@@ -265,20 +986,36 @@ func (em *EBpfProbe) GetTargetPIDs() ([]uint32, error) {
 		return []uint32{}, errors.New("eBPF objects not initialized")
 	}
 
-	pids := make([]uint32, 0)
+	targets, err := em.GetTargets()
+	pids := make([]uint32, 0, len(targets))
+	for _, target := range targets {
+		pids = append(pids, target.PID)
+	}
+	return pids, err
+}
+
+// GetTargets returns all target PIDs with their follow/inherited flags, sorted by PID
+func (em *EBpfProbe) GetTargets() ([]TargetPID, error) {
+	if em.objs == nil || em.objs.TargetPids == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []TargetPID{}, errors.New("eBPF objects not initialized")
+	}
+
+	targets := make([]TargetPID, 0)
 	iter := em.objs.TargetPids.Iterate()
 	var key uint32
-	var value uint32
+	var value targetValue
 	for iter.Next(&key, &value) {
-		pids = append(pids, key)
+		targets = append(targets, value.target(key))
 	}
 
 	if iter.Err() != nil {
 		em.logger.Errorf("error iterating target PIDs: %v", iter.Err())
-		return pids, errors.New("error iterating target PIDs: " + iter.Err().Error())
+		return targets, errors.New("error iterating target PIDs: " + iter.Err().Error())
 	}
 
-	return pids, nil
+	sort.Slice(targets, func(i, j int) bool { return targets[i].PID < targets[j].PID })
+	return targets, nil
 }
 
 // GetPrintAllState returns the current print_all flag state
@@ -296,4 +1033,91 @@ func (em *EBpfProbe) GetPrintAllState() (bool, error) {
 	}
 
 	return printAllFlag == 1, nil
-}
\ No newline at end of file
+}
+
+// GetSyscallCounts returns the in-kernel syscall counters, summed over CPUs
+func (em *EBpfProbe) GetSyscallCounts() ([]SyscallCount, error) {
+	if em.objs == nil || em.objs.SyscallCounts == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []SyscallCount{}, errors.New("eBPF objects not initialized")
+	}
+
+	counts := make([]SyscallCount, 0)
+	iter := em.objs.SyscallCounts.Iterate()
+	var key syscallCountsKey
+	var perCPU []uint64
+	for iter.Next(&key, &perCPU) {
+		var sum uint64
+		for _, v := range perCPU {
+			sum += v
+		}
+		counts = append(counts, SyscallCount{Pid: key.Pid, EventType: key.EventType, Count: sum})
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating syscall counts: %v", iter.Err())
+		return counts, errors.New("error iterating syscall counts: " + iter.Err().Error())
+	}
+
+	return counts, nil
+}
+
+// GetExitCounts returns the completed-syscall aggregates, combined over CPUs
+func (em *EBpfProbe) GetExitCounts() ([]SyscallExitCount, error) {
+	if em.objs == nil || em.objs.SyscallExits == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []SyscallExitCount{}, errors.New("eBPF objects not initialized")
+	}
+
+	exits := make([]SyscallExitCount, 0)
+	iter := em.objs.SyscallExits.Iterate()
+	var key syscallCountsKey
+	var perCPU []syscallExitValue
+	for iter.Next(&key, &perCPU) {
+		exit := SyscallExitCount{Pid: key.Pid, EventType: key.EventType}
+		for _, v := range perCPU {
+			exit.Calls += v.Calls
+			exit.Errors += v.Errors
+			exit.TotalNs += v.TotalNs
+			exit.Bytes += v.Bytes
+			if v.MaxNs > exit.MaxNs {
+				exit.MaxNs = v.MaxNs
+			}
+		}
+		exits = append(exits, exit)
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating syscall exits: %v", iter.Err())
+		return exits, errors.New("error iterating syscall exits: " + iter.Err().Error())
+	}
+
+	return exits, nil
+}
+
+// GetErrnoCounts returns failed-syscall counts per errno, summed over CPUs
+func (em *EBpfProbe) GetErrnoCounts() ([]SyscallErrnoCount, error) {
+	if em.objs == nil || em.objs.SyscallErrors == nil {
+		em.logger.Errorf("eBPF objects not initialized")
+		return []SyscallErrnoCount{}, errors.New("eBPF objects not initialized")
+	}
+
+	errnos := make([]SyscallErrnoCount, 0)
+	iter := em.objs.SyscallErrors.Iterate()
+	var key syscallErrnoKey
+	var perCPU []uint64
+	for iter.Next(&key, &perCPU) {
+		var sum uint64
+		for _, v := range perCPU {
+			sum += v
+		}
+		errnos = append(errnos, SyscallErrnoCount{Pid: key.Pid, EventType: key.EventType, Errno: key.Errno, Count: sum})
+	}
+
+	if iter.Err() != nil {
+		em.logger.Errorf("error iterating syscall errors: %v", iter.Err())
+		return errnos, errors.New("error iterating syscall errors: " + iter.Err().Error())
+	}
+
+	return errnos, nil
+}
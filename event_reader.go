@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// Event transports between handle_sys_call and userspace
+const (
+	TransportRingBuf = "ringbuf"
+	TransportPerf    = "perf"
+)
+
+const (
+	defaultRingBufferSize = 1 << 22 // 4MB shared by all CPUs
+	defaultPerfBufferSize = 1 << 18 // 256KB per CPU
+)
+
+// eventReader hides whether events come from the ring buffer or the perf buffer
+type eventReader interface {
+	// Read blocks for the next raw sample; lost is the number of samples dropped before it
+	Read() (sample []byte, lost uint64, err error)
+	Close() error
+}
+
+type perfEventReader struct {
+	rd *perf.Reader
+}
+
+func (r *perfEventReader) Read() ([]byte, uint64, error) {
+	record, err := r.rd.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	return record.RawSample, record.LostSamples, nil
+}
+
+func (r *perfEventReader) Close() error {
+	return r.rd.Close()
+}
+
+type ringbufEventReader struct {
+	rd *ringbuf.Reader
+}
+
+func (r *ringbufEventReader) Read() ([]byte, uint64, error) {
+	record, err := r.rd.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	// A full ring buffer makes bpf_ringbuf_reserve fail in the kernel; nothing is reported here
+	return record.RawSample, 0, nil
+}
+
+func (r *ringbufEventReader) Close() error {
+	return r.rd.Close()
+}
+
+// selectTransport resolves the requested transport against what the kernel supports.
+// An empty request means ring buffer if available (Linux 5.8+), perf buffer otherwise.
+func selectTransport(requested string, logger Logger) (string, error) {
+	switch requested {
+	case TransportPerf:
+		return TransportPerf, nil
+	case TransportRingBuf, "":
+		if err := features.HaveMapType(ebpf.RingBuf); err != nil {
+			if requested == TransportRingBuf {
+				return "", errors.New("ring buffer transport not supported by kernel: " + err.Error())
+			}
+			logger.Warnf("BPF ring buffer not supported (%v), falling back to perf buffer", err)
+			return TransportPerf, nil
+		}
+		return TransportRingBuf, nil
+	default:
+		return "", errors.New("unknown event transport: " + requested)
+	}
+}
+
+// configureTransport prepares the collection spec for the chosen transport before load
+func configureTransport(spec *ebpf.CollectionSpec, transport string, opts ProbeOptions) error {
+	rb, ok := spec.Maps["ringbuf_events"]
+	if !ok {
+		return errors.New("ringbuf_events map missing from eBPF spec")
+	}
+
+	useRingbuf := uint32(0)
+	if transport == TransportRingBuf {
+		useRingbuf = 1
+		rb.MaxEntries = uint32(opts.RingBufferSize)
+	} else {
+		// The ring buffer map type does not exist on older kernels: replace it by a
+		// placeholder. handle_sys_call never touches it when use_ringbuf is 0.
+		rb.Type = ebpf.Array
+		rb.KeySize = 4
+		rb.ValueSize = 4
+		rb.MaxEntries = 1
+	}
+
+	return spec.RewriteConstants(map[string]interface{}{
+		"use_ringbuf": useRingbuf,
+	})
+}
+
+// newEventReader opens the userspace reader for the chosen transport
+func newEventReader(objs *ebpf_probeObjects, transport string, opts ProbeOptions) (eventReader, error) {
+	if transport == TransportRingBuf {
+		rd, err := ringbuf.NewReader(objs.RingbufEvents)
+		if err != nil {
+			return nil, err
+		}
+		return &ringbufEventReader{rd: rd}, nil
+	}
+
+	rd, err := perf.NewReader(objs.Events, opts.PerfBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return &perfEventReader{rd: rd}, nil
+}
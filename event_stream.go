@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+// streamClientBuffer is the number of events buffered per stream client
+// before it is considered too slow and disconnected
+const streamClientBuffer = 1024
+
+// EventFilter selects events; zero fields match everything
+type EventFilter struct {
+	PID       uint32
+	EventType uint32
+	Comm      string
+}
+
+// Matches reports whether event passes the filter
+func (f EventFilter) Matches(event Data) bool {
+	if f.PID != 0 && event.Pid != f.PID {
+		return false
+	}
+	if f.EventType != 0 && event.EventType != f.EventType {
+		return false
+	}
+	if f.Comm != "" && event.Comm != f.Comm {
+		return false
+	}
+	return true
+}
+
+// parseEventType accepts a syscall name, a process event name or an event type ID
+func parseEventType(value string) (uint32, bool) {
+	if sc, ok := lookupSyscall(value); ok {
+		return sc.eventType, true
+	}
+	if eventType, ok := lookupProcessEvent(value); ok {
+		return eventType, true
+	}
+	if id, err := strconv.ParseUint(value, 10, 32); err == nil && id != 0 {
+		return uint32(id), true
+	}
+	return 0, false
+}
+
+// StreamSubscriber is one live stream client
+type StreamSubscriber struct {
+	filter EventFilter
+	events chan Data
+}
+
+// Events is closed when the subscriber is removed or dropped for being too slow
+func (s *StreamSubscriber) Events() <-chan Data {
+	return s.events
+}
+
+// StreamStats counts live stream clients
+type StreamStats struct {
+	Clients        int    `json:"clients"`
+	DroppedClients uint64 `json:"dropped_clients"`
+}
+
+// EventBroadcaster fans decoded events out to live stream clients.
+// Publish never blocks: a client whose buffer is full is dropped and counted.
+type EventBroadcaster struct {
+	mu             sync.Mutex
+	subscribers    map[*StreamSubscriber]struct{}
+	droppedClients uint64
+}
+
+// NewEventBroadcaster creates a broadcaster without subscribers
+func NewEventBroadcaster() *EventBroadcaster {
+	return &EventBroadcaster{
+		subscribers: make(map[*StreamSubscriber]struct{}),
+	}
+}
+
+// Subscribe registers a client receiving the events matching filter
+func (b *EventBroadcaster) Subscribe(filter EventFilter) *StreamSubscriber {
+	sub := &StreamSubscriber{
+		filter: filter,
+		events: make(chan Data, streamClientBuffer),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes a client; it is a no-op if the client was already dropped
+func (b *EventBroadcaster) Unsubscribe(sub *StreamSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Publish delivers event to every matching client without blocking
+func (b *EventBroadcaster) Publish(event Data) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+			b.droppedClients++
+		}
+	}
+}
+
+// Name implements EventSink: the broadcaster is the pipeline stage feeding /events/stream
+func (b *EventBroadcaster) Name() string { return "stream" }
+
+// Write implements EventSink
+func (b *EventBroadcaster) Write(event Data) error {
+	b.Publish(event)
+	return nil
+}
+
+// Close implements EventSink; clients are released by their own Unsubscribe
+func (b *EventBroadcaster) Close() error { return nil }
+
+// Stats returns the number of connected and dropped clients
+func (b *EventBroadcaster) Stats() StreamStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return StreamStats{Clients: len(b.subscribers), DroppedClients: b.droppedClients}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBroadcasterDropsSlowClient(t *testing.T) {
+	b := NewEventBroadcaster()
+	slow := b.Subscribe(EventFilter{PID: 100})
+	other := b.Subscribe(EventFilter{PID: 200})
+
+	// The slow client never reads: once its buffer is full it is dropped, without blocking Publish
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < streamClientBuffer+10; i++ {
+			b.Publish(Data{Pid: 100, EventType: evtRead})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a client that does not read")
+	}
+
+	if stats := b.Stats(); stats.Clients != 1 || stats.DroppedClients != 1 {
+		t.Errorf("stats = %+v, want 1 client and 1 dropped", stats)
+	}
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	if received != streamClientBuffer {
+		t.Errorf("slow client got %d buffered events before its channel was closed, want %d", received, streamClientBuffer)
+	}
+	// Unsubscribing a dropped client is a no-op
+	b.Unsubscribe(slow)
+
+	b.Publish(Data{Pid: 200, EventType: evtWrite})
+	select {
+	case event := <-other.Events():
+		if event.Pid != 200 {
+			t.Errorf("other client got %+v", event)
+		}
+	default:
+		t.Error("client with a matching filter got nothing")
+	}
+	b.Unsubscribe(other)
+	if _, ok := <-other.Events(); ok {
+		t.Error("channel still open after Unsubscribe")
+	}
+}
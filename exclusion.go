@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// defaultNoisyComms are the daemons whose own I/O drowns print_all output on a
+// typical container host: container runtimes, journald/syslog and log shippers.
+// ExclusionConfig.NoisyDaemons excludes them.
+var defaultNoisyComms = []string{
+	"dockerd",
+	"containerd",
+	"containerd-shim",
+	"systemd-journal",
+	"rsyslogd",
+	"syslog-ng",
+	"fluent-bit",
+	"filebeat",
+	"promtail",
+	"vector",
+}
+
+// errExcludeSelf is returned when removing the monitor's own PID from the exclusions
+var errExcludeSelf = errors.New("the monitor's own PID is always excluded")
+
+// ExclusionConfig lists processes whose events are never reported, on top of the
+// monitor itself. It is both the startup configuration and the body of POST /exclusions.
+type ExclusionConfig struct {
+	// NoisyDaemons excludes defaultNoisyComms
+	NoisyDaemons bool     `json:"noisy_daemons" yaml:"noisy_daemons"`
+	PIDs         []uint32 `json:"pids,omitempty" yaml:"pids"`
+	Comms        []string `json:"comms,omitempty" yaml:"comms"`
+	// Cgroups are given by Path; the ID is resolved when the exclusion is applied
+	Cgroups []CgroupTarget `json:"cgroups,omitempty" yaml:"cgroups"`
+}
+
+// Exclusions is the content of the skip_pid, skip_comms and skip_cgroups maps
+type Exclusions struct {
+	PIDs    []uint32       `json:"pids"`
+	Comms   []string       `json:"comms"`
+	Cgroups []CgroupTarget `json:"cgroups"`
+	SelfPID uint32         `json:"self_pid"`
+}
+
+// selfPID is the PID excluded by NewEBpfProbe and NewMemoryProbe
+func selfPID() uint32 {
+	return uint32(os.Getpid())
+}
+
+// exclusionCommands turns cfg into one command per exclusion, resolving cgroup paths
+func exclusionCommands(cfg ExclusionConfig) ([]MonitorCommand, error) {
+	comms := cfg.Comms
+	if cfg.NoisyDaemons {
+		comms = append(append([]string{}, defaultNoisyComms...), comms...)
+	}
+
+	cmds := make([]MonitorCommand, 0, len(cfg.PIDs)+len(comms)+len(cfg.Cgroups))
+	for _, pid := range cfg.PIDs {
+		if pid == 0 {
+			return nil, errors.New("excluded PIDs must be positive")
+		}
+		cmds = append(cmds, MonitorCommand{Kind: CommandExcludePID, PID: pid})
+	}
+	for _, comm := range comms {
+		if _, err := newCommKey(comm); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, MonitorCommand{Kind: CommandExcludeComm, Comm: comm})
+	}
+	for _, cg := range cfg.Cgroups {
+		path, id, err := resolveCgroup(cg.Path)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, MonitorCommand{Kind: CommandExcludeCgroup, Cgroup: CgroupTarget{ID: id, Path: path, Descendants: cg.Descendants}})
+	}
+	return cmds, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistoryCapacity is the number of events kept by default
+	defaultHistoryCapacity = 50000
+	// maxHistoryPage bounds the events returned by one GET /events
+	maxHistoryPage = 10000
+)
+
+// HistoryConfig sizes the in-memory event history behind GET /events
+type HistoryConfig struct {
+	// Capacity is the number of events kept; the oldest are evicted first. 0 disables the history.
+	Capacity int `json:"capacity" yaml:"capacity"`
+}
+
+// HistoryEvent is an event with its position in the history and its wall-clock time
+type HistoryEvent struct {
+	Data
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+}
+
+// HistoryQuery selects events of the history; zero fields match everything
+type HistoryQuery struct {
+	Filter EventFilter
+	Since  time.Time
+	Until  time.Time
+	// Cursor is the first sequence number to consider, as returned in HistoryPage.NextCursor
+	Cursor uint64
+	Limit  int
+}
+
+// HistoryPage is one page of query results, oldest first
+type HistoryPage struct {
+	Events []HistoryEvent `json:"events"`
+	// NextCursor continues the query after this page; once HasMore is false it
+	// can be polled for events recorded since
+	NextCursor uint64 `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+	// Gap reports that events the query asked for were already evicted
+	Gap bool `json:"gap"`
+}
+
+// HistoryStats reports the size of the history and how much it evicted
+type HistoryStats struct {
+	Capacity  int       `json:"capacity"`
+	Size      int       `json:"size"`
+	Evicted   uint64    `json:"evicted"`
+	OldestSeq uint64    `json:"oldest_seq,omitempty"`
+	Oldest    time.Time `json:"oldest,omitempty"`
+}
+
+// EventHistory is a fixed-capacity ring of decoded events. Every event gets a
+// sequence number, so cursors stay valid while older events are evicted.
+// It is one more sink of the EventPipeline.
+type EventHistory struct {
+	mu      sync.RWMutex
+	events  []HistoryEvent
+	start   int    // index of the oldest event
+	size    int    // events held
+	nextSeq uint64 // sequence number of the next event, starting at 1
+}
+
+// NewEventHistory creates an empty history of capacity events
+func NewEventHistory(capacity int) *EventHistory {
+	return &EventHistory{events: make([]HistoryEvent, capacity), nextSeq: 1}
+}
+
+func (h *EventHistory) Name() string { return "history" }
+
+// Write records event, evicting the oldest one when full
+func (h *EventHistory) Write(event Data) error {
+	at := eventWallTime(event)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry := HistoryEvent{Data: event, Seq: h.nextSeq, Time: at}
+	h.nextSeq++
+	if h.size < len(h.events) {
+		h.events[(h.start+h.size)%len(h.events)] = entry
+		h.size++
+		return nil
+	}
+	h.events[h.start] = entry
+	h.start = (h.start + 1) % len(h.events)
+	return nil
+}
+
+func (h *EventHistory) Close() error { return nil }
+
+// oldestSeq is the sequence number of the oldest event held, or nextSeq when empty
+func (h *EventHistory) oldestSeq() uint64 {
+	return h.nextSeq - uint64(h.size)
+}
+
+// Stats reports the capacity, size and evictions of the history
+func (h *EventHistory) Stats() HistoryStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats := HistoryStats{
+		Capacity: len(h.events),
+		Size:     h.size,
+		Evicted:  h.oldestSeq() - 1,
+	}
+	if h.size > 0 {
+		stats.OldestSeq = h.events[h.start].Seq
+		stats.Oldest = h.events[h.start].Time
+	}
+	return stats
+}
+
+// Query returns up to q.Limit matching events from q.Cursor on, oldest first
+func (h *EventHistory) Query(q HistoryQuery) HistoryPage {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	limit := q.Limit
+	if limit <= 0 || limit > maxHistoryPage {
+		limit = maxHistoryPage
+	}
+	oldest := h.oldestSeq()
+	first := q.Cursor
+	page := HistoryPage{Events: make([]HistoryEvent, 0)}
+	// Events were evicted that the cursor points to, or that may fall in the time range
+	evicted := oldest > 1
+	switch {
+	case first > 0 && first < oldest:
+		page.Gap = true
+	case first == 0 && evicted && !q.Since.IsZero():
+		page.Gap = h.size == 0 || h.events[h.start].Time.After(q.Since)
+	}
+	if first < oldest {
+		first = oldest
+	}
+	// A cursor past the newest event (e.g. from before a restart) would skip the next ones
+	if first > h.nextSeq {
+		first = h.nextSeq
+	}
+
+	page.NextCursor = first
+	for seq := first; seq < h.nextSeq; seq++ {
+		event := h.events[(h.start+int(seq-oldest))%len(h.events)]
+		page.NextCursor = seq + 1
+		if !q.Since.IsZero() && event.Time.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && event.Time.After(q.Until) {
+			continue
+		}
+		if !q.Filter.Matches(event.Data) {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.NextCursor = seq
+			page.HasMore = true
+			break
+		}
+		page.Events = append(page.Events, event)
+	}
+	return page
+}
+
+// eventWallTime converts the kernel timestamp of event, monotonic since boot, to wall-clock time
+func eventWallTime(event Data) time.Time {
+	at := time.Now()
+	if now := monotonicNowNs(); event.TimestampNs != 0 && event.TimestampNs <= now {
+		at = at.Add(-time.Duration(now - event.TimestampNs))
+	}
+	return at
+}
+
+// parseTimeBound parses an RFC 3339 time or a duration before now (5m is five minutes ago)
+func parseTimeBound(value string, now time.Time) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), true
+	}
+	return time.Time{}, false
+}
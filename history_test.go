@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestHistory writes n events one second apart, the last one a second ago,
+// with PIDs 100 and 200 alternating (odd sequence numbers are PID 100)
+func newTestHistory(t *testing.T, capacity, n int) (*EventHistory, time.Time) {
+	t.Helper()
+	h := NewEventHistory(capacity)
+	now := time.Now()
+	mono := monotonicNowNs()
+	for i := 1; i <= n; i++ {
+		pid := uint32(100)
+		if i%2 == 0 {
+			pid = 200
+		}
+		ago := uint64(n-i+1) * uint64(time.Second)
+		if err := h.Write(Data{Pid: pid, EventType: evtRead, TimestampNs: mono - ago}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h, now
+}
+
+func TestEventHistoryQuery(t *testing.T) {
+	// Capacity 4 and 6 events: 1 and 2 are evicted, 3 to 6 are held (3 at now-4s, 6 at now-1s)
+	for _, tc := range []struct {
+		name       string
+		query      func(now time.Time) HistoryQuery
+		wantSeqs   []uint64
+		wantNext   uint64
+		wantMore   bool
+		wantGap    bool
+		emptyStore bool
+	}{
+		{
+			name:     "everything",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{} },
+			wantSeqs: []uint64{3, 4, 5, 6},
+			wantNext: 7,
+		},
+		{
+			name:     "cursor older than the oldest event",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{Cursor: 1} },
+			wantSeqs: []uint64{3, 4, 5, 6},
+			wantNext: 7,
+			wantGap:  true,
+		},
+		{
+			name:     "cursor at the oldest event",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{Cursor: 3} },
+			wantSeqs: []uint64{3, 4, 5, 6},
+			wantNext: 7,
+		},
+		{
+			name:     "cursor at the next event",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{Cursor: 7} },
+			wantNext: 7,
+		},
+		{
+			name:     "cursor past the next event",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{Cursor: 100} },
+			wantNext: 7,
+		},
+		{
+			name:     "since before the oldest event after eviction",
+			query:    func(now time.Time) HistoryQuery { return HistoryQuery{Since: now.Add(-10 * time.Second)} },
+			wantSeqs: []uint64{3, 4, 5, 6},
+			wantNext: 7,
+			wantGap:  true,
+		},
+		{
+			name:     "since after the oldest event",
+			query:    func(now time.Time) HistoryQuery { return HistoryQuery{Since: now.Add(-2500 * time.Millisecond)} },
+			wantSeqs: []uint64{5, 6},
+			wantNext: 7,
+		},
+		{
+			name:     "until",
+			query:    func(now time.Time) HistoryQuery { return HistoryQuery{Until: now.Add(-2500 * time.Millisecond)} },
+			wantSeqs: []uint64{3, 4},
+			wantNext: 7,
+		},
+		{
+			name:     "limit",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{Limit: 2} },
+			wantSeqs: []uint64{3, 4},
+			wantNext: 5,
+			wantMore: true,
+		},
+		{
+			name:     "last page",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{Cursor: 5, Limit: 2} },
+			wantSeqs: []uint64{5, 6},
+			wantNext: 7,
+		},
+		{
+			name:     "filter with limit",
+			query:    func(time.Time) HistoryQuery { return HistoryQuery{Filter: EventFilter{PID: 200}, Limit: 1} },
+			wantSeqs: []uint64{4},
+			wantNext: 6,
+			wantMore: true,
+		},
+		{
+			name:       "since on an empty history",
+			query:      func(now time.Time) HistoryQuery { return HistoryQuery{Since: now.Add(-time.Hour)} },
+			wantNext:   1,
+			emptyStore: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := 6
+			if tc.emptyStore {
+				n = 0
+			}
+			h, now := newTestHistory(t, 4, n)
+			page := h.Query(tc.query(now))
+			seqs := make([]uint64, 0, len(page.Events))
+			for _, event := range page.Events {
+				seqs = append(seqs, event.Seq)
+			}
+			if len(seqs) != len(tc.wantSeqs) {
+				t.Fatalf("seqs = %v, want %v", seqs, tc.wantSeqs)
+			}
+			for i := range seqs {
+				if seqs[i] != tc.wantSeqs[i] {
+					t.Fatalf("seqs = %v, want %v", seqs, tc.wantSeqs)
+				}
+			}
+			if page.NextCursor != tc.wantNext || page.HasMore != tc.wantMore || page.Gap != tc.wantGap {
+				t.Errorf("next_cursor %d has_more %v gap %v, want %d %v %v",
+					page.NextCursor, page.HasMore, page.Gap, tc.wantNext, tc.wantMore, tc.wantGap)
+			}
+		})
+	}
+}
+
+func TestEventHistoryStats(t *testing.T) {
+	h, _ := newTestHistory(t, 4, 6)
+	stats := h.Stats()
+	if stats.Capacity != 4 || stats.Size != 4 || stats.Evicted != 2 || stats.OldestSeq != 3 {
+		t.Errorf("stats = %+v, want capacity 4, size 4, 2 evicted, oldest 3", stats)
+	}
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// journalMagic starts every segment file; the last digit is the format version
+	journalMagic      = "EBPFJRN1"
+	journalSegmentExt = ".seg"
+	journalIndexExt   = ".idx"
+	// journalFrameSize is the payload length and CRC-32C preceding each record
+	journalFrameSize = 8
+	// journalRecordFixed is the payload size before the three length-prefixed strings
+	journalRecordFixed = 80
+	// journalMaxRecord bounds a payload, so a corrupt length is not taken for a record
+	journalMaxRecord = 1024
+	// journalIndexEvery is the number of records between two index entries
+	journalIndexEvery     = 256
+	journalIndexEntrySize = 24
+	// journalTimeSlack covers events of different CPUs reaching the journal out of order
+	journalTimeSlack         = 5 * time.Second
+	journalFlushInterval     = time.Second
+	journalRetentionInterval = time.Minute
+	// journalSegmentDuration rolls segments over at least hourly, so age retention can free space
+	journalSegmentDuration = time.Hour
+
+	defaultJournalSegmentSizeMB = 64
+	defaultJournalMaxSizeMB     = 1024
+	defaultJournalMaxAgeHours   = 7 * 24
+	// defaultJournalDir is where the journal CLI looks without -dir
+	defaultJournalDir = "/var/lib/ebpf-game/journal"
+)
+
+var journalCRC = crc32.MakeTable(crc32.Castagnoli)
+
+var errJournalClosed = errors.New("journal is closed")
+
+// JournalConfig describes the on-disk event journal
+type JournalConfig struct {
+	// Dir holds the segment and index files; empty disables the journal
+	Dir string `json:"dir" yaml:"dir"`
+	// SegmentSizeMB is the size at which the active segment is closed and a new one started
+	SegmentSizeMB int `json:"segment_size_mb" yaml:"segment_size_mb"`
+	// MaxSizeMB and MaxAgeHours bound what is kept: the oldest segments are removed
+	// first, never the active one. 0 disables the bound.
+	MaxSizeMB   int `json:"max_size_mb" yaml:"max_size_mb"`
+	MaxAgeHours int `json:"max_age_hours" yaml:"max_age_hours"`
+	// QueueSize is the number of events buffered before the journal starts dropping
+	QueueSize int `json:"queue_size" yaml:"queue_size"`
+}
+
+// JournalStats reports the files of the journal and what it did since it was opened
+type JournalStats struct {
+	Dir       string    `json:"dir"`
+	Segments  int       `json:"segments"`
+	SizeBytes int64     `json:"size_bytes"`
+	OldestSeq uint64    `json:"oldest_seq,omitempty"`
+	Oldest    time.Time `json:"oldest,omitempty"`
+	// NextSeq, Written, RemovedSegments and RepairedBytes are only known to the writer
+	NextSeq         uint64 `json:"next_seq,omitempty"`
+	Written         uint64 `json:"written"`
+	RemovedSegments uint64 `json:"removed_segments"`
+	// RepairedBytes were cut from the end of the last segment at open, after a crash mid-write
+	RepairedBytes int64 `json:"repaired_bytes"`
+}
+
+// journalSegment is one segment file, named after the sequence number of its first record
+type journalSegment struct {
+	firstSeq  uint64
+	firstTime time.Time // time of the first record, zero while empty
+	size      int64
+	modTime   time.Time
+}
+
+// journalIndexEntry locates a record of a segment; one is written every journalIndexEvery records
+type journalIndexEntry struct {
+	TimeNs int64
+	Seq    uint64
+	Offset int64
+}
+
+// EventJournal is an append-only, segmented binary journal of events, kept on disk
+// across restarts. Records carry a length and a CRC, so a record torn by a crash is
+// detected and cut when the journal is opened again. A sparse index per segment
+// lets queries seek to a time or cursor without reading whole files.
+// It is one more sink of the EventPipeline.
+type EventJournal struct {
+	cfg      JournalConfig
+	logger   Logger
+	readOnly bool
+
+	mu         sync.Mutex
+	segments   []journalSegment // oldest first; the last one is active
+	seg        *os.File
+	segW       *bufio.Writer
+	idx        *os.File
+	idxW       *bufio.Writer
+	segStarted time.Time // rollover by age of the active segment
+	records    int       // records in the active segment
+	nextSeq    uint64
+	buf        []byte
+	flushErr   bool // a flush failure was logged, until one succeeds
+	closed     bool
+
+	written  uint64
+	removed  uint64
+	repaired int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenJournal opens or creates the journal in cfg.Dir for writing. The last
+// segment is checked record by record and cut after the last valid one.
+func OpenJournal(cfg JournalConfig, logger Logger) (*EventJournal, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		logger.Errorf("failed to create journal directory: %v", err)
+		return nil, errors.New("failed to create journal directory: " + err.Error())
+	}
+	segments, err := listJournalSegments(cfg.Dir)
+	if err != nil {
+		logger.Errorf("failed to list journal segments: %v", err)
+		return nil, errors.New("failed to list journal segments: " + err.Error())
+	}
+
+	j := &EventJournal{
+		cfg:      cfg,
+		logger:   logger,
+		segments: segments,
+		nextSeq:  1,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if len(segments) == 0 {
+		err = j.createSegment()
+	} else {
+		err = j.openActive()
+	}
+	if err != nil {
+		logger.Errorf("failed to open journal: %v", err)
+		return nil, errors.New("failed to open journal: " + err.Error())
+	}
+	j.applyRetention(time.Now())
+
+	go j.run()
+	return j, nil
+}
+
+// OpenJournalReader opens the journal in dir for queries only. It does not repair
+// anything and may be used while another process writes the journal.
+func OpenJournalReader(dir string) (*EventJournal, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, errors.New("cannot open journal: " + err.Error())
+	}
+	return &EventJournal{cfg: JournalConfig{Dir: dir}, readOnly: true}, nil
+}
+
+func (j *EventJournal) Name() string { return "journal" }
+
+// Write appends event, rolling the active segment over when it is full or an hour old.
+// A rollover that failed left no active segment; it is created again here.
+func (j *EventJournal) Write(event Data) error {
+	entry := HistoryEvent{Data: event, Time: eventWallTime(event)}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed || j.readOnly {
+		return errJournalClosed
+	}
+	rolled := false
+	if j.seg == nil {
+		if err := j.createSegment(); err != nil {
+			return err
+		}
+		rolled = true
+	}
+	segmentSize := int64(j.cfg.SegmentSizeMB) << 20
+	active := j.segments[len(j.segments)-1]
+	if j.records > 0 && (active.size >= segmentSize || time.Since(j.segStarted) >= journalSegmentDuration) {
+		if err := j.closeActive(); err != nil {
+			return err
+		}
+		if err := j.createSegment(); err != nil {
+			return err
+		}
+		rolled = true
+	}
+
+	active = j.segments[len(j.segments)-1]
+	entry.Seq = j.nextSeq
+	j.buf = encodeJournalRecord(j.buf[:0], entry)
+	if _, err := j.segW.Write(j.buf); err != nil {
+		return errors.New("failed to write journal record: " + err.Error())
+	}
+	if j.records%journalIndexEvery == 0 {
+		var entryBuf [journalIndexEntrySize]byte
+		putJournalIndexEntry(entryBuf[:], journalIndexEntry{TimeNs: entry.Time.UnixNano(), Seq: entry.Seq, Offset: active.size})
+		if _, err := j.idxW.Write(entryBuf[:]); err != nil {
+			return errors.New("failed to write journal index: " + err.Error())
+		}
+	}
+	if active.firstTime.IsZero() {
+		active.firstTime = entry.Time
+	}
+	active.size += int64(len(j.buf))
+	active.modTime = time.Now()
+	j.segments[len(j.segments)-1] = active
+	j.records++
+	j.nextSeq++
+	j.written++
+
+	if rolled {
+		j.applyRetention(time.Now())
+	}
+	return nil
+}
+
+// Close flushes and syncs the active segment; the journal cannot be written afterwards
+func (j *EventJournal) Close() error {
+	if j.readOnly {
+		return nil
+	}
+	close(j.stop)
+	<-j.done
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.closed = true
+	if j.seg == nil {
+		return nil
+	}
+	return j.closeActive()
+}
+
+// run flushes buffered records every second, bounding what a crash loses, and
+// applies retention every minute
+func (j *EventJournal) run() {
+	defer close(j.done)
+	flush := time.NewTicker(journalFlushInterval)
+	defer flush.Stop()
+	retention := time.NewTicker(journalRetentionInterval)
+	defer retention.Stop()
+
+	for {
+		select {
+		case <-flush.C:
+			j.mu.Lock()
+			if j.seg != nil {
+				if err := j.flush(); err != nil && !j.flushErr {
+					j.logger.Warnf("Failed to flush the journal: %v", err)
+					j.flushErr = true
+				} else if err == nil {
+					j.flushErr = false
+				}
+			}
+			j.mu.Unlock()
+		case <-retention.C:
+			j.mu.Lock()
+			j.applyRetention(time.Now())
+			j.mu.Unlock()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// flush writes the buffered records, then their index entries, to the files
+func (j *EventJournal) flush() error {
+	if err := j.segW.Flush(); err != nil {
+		return err
+	}
+	return j.idxW.Flush()
+}
+
+// closeActive flushes, syncs and closes the files of the active segment
+func (j *EventJournal) closeActive() error {
+	err := j.flush()
+	if err == nil {
+		err = j.seg.Sync()
+	}
+	j.seg.Close()
+	j.idx.Close()
+	j.seg, j.idx = nil, nil
+	if err != nil {
+		return errors.New("failed to close journal segment: " + err.Error())
+	}
+	return nil
+}
+
+// createSegment starts a new active segment at the next sequence number
+func (j *EventJournal) createSegment() error {
+	seg, err := os.OpenFile(j.segmentPath(j.nextSeq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.New("failed to create journal segment: " + err.Error())
+	}
+	if _, err := seg.WriteString(journalMagic); err != nil {
+		seg.Close()
+		return errors.New("failed to create journal segment: " + err.Error())
+	}
+	idx, err := os.OpenFile(j.indexPath(j.nextSeq), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		seg.Close()
+		return errors.New("failed to create journal index: " + err.Error())
+	}
+
+	now := time.Now()
+	j.segments = append(j.segments, journalSegment{firstSeq: j.nextSeq, size: int64(len(journalMagic)), modTime: now})
+	j.setActive(seg, idx, 0, now)
+	return nil
+}
+
+// openActive reopens the last segment after a restart. Every record is checked:
+// the file is cut after the last valid one and its index is rebuilt.
+func (j *EventJournal) openActive() error {
+	active := j.segments[len(j.segments)-1]
+	path := j.segmentPath(active.firstSeq)
+	seg, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(journalMagic))
+	if _, err := io.ReadFull(seg, header); err != nil || string(header) != journalMagic {
+		// Torn while being created: start it over
+		j.logger.Warnf("Journal segment %s has no valid header, starting it over", path)
+		if err := seg.Truncate(0); err != nil {
+			seg.Close()
+			return err
+		}
+		if _, err := seg.WriteAt([]byte(journalMagic), 0); err != nil {
+			seg.Close()
+			return err
+		}
+		j.repaired += active.size
+		active.size = int64(len(journalMagic))
+	}
+
+	var index []byte
+	var entry [journalIndexEntrySize]byte
+	records := 0
+	active.firstTime = time.Time{}
+	j.nextSeq = active.firstSeq
+	reader := newJournalReader(seg, int64(len(journalMagic)), active.size)
+	for {
+		offset := reader.offset
+		event, ok := reader.next()
+		if !ok {
+			break
+		}
+		if records%journalIndexEvery == 0 {
+			putJournalIndexEntry(entry[:], journalIndexEntry{TimeNs: event.Time.UnixNano(), Seq: event.Seq, Offset: offset})
+			index = append(index, entry[:]...)
+		}
+		if active.firstTime.IsZero() {
+			active.firstTime = event.Time
+		}
+		records++
+		j.nextSeq = event.Seq + 1
+	}
+	if cut := active.size - reader.offset; cut > 0 {
+		j.logger.Warnf("Journal segment %s: cut %d bytes after the last valid record", path, cut)
+		if err := seg.Truncate(reader.offset); err != nil {
+			seg.Close()
+			return err
+		}
+		j.repaired += cut
+		active.size = reader.offset
+	}
+	if _, err := seg.Seek(active.size, io.SeekStart); err != nil {
+		seg.Close()
+		return err
+	}
+
+	if err := os.WriteFile(j.indexPath(active.firstSeq), index, 0o644); err != nil {
+		seg.Close()
+		return err
+	}
+	idx, err := os.OpenFile(j.indexPath(active.firstSeq), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		seg.Close()
+		return err
+	}
+
+	j.segments[len(j.segments)-1] = active
+	started := time.Now()
+	if !active.firstTime.IsZero() {
+		started = active.firstTime
+	}
+	j.setActive(seg, idx, records, started)
+	return nil
+}
+
+func (j *EventJournal) setActive(seg, idx *os.File, records int, started time.Time) {
+	j.seg, j.idx = seg, idx
+	j.segW = bufio.NewWriterSize(seg, 64<<10)
+	j.idxW = bufio.NewWriterSize(idx, 4<<10)
+	j.records = records
+	j.segStarted = started
+}
+
+// applyRetention removes the oldest segments while the journal is over
+// MaxSizeMB or they were last written more than MaxAgeHours ago
+func (j *EventJournal) applyRetention(now time.Time) {
+	maxSize := int64(j.cfg.MaxSizeMB) << 20
+	maxAge := time.Duration(j.cfg.MaxAgeHours) * time.Hour
+	var total int64
+	for _, s := range j.segments {
+		total += s.size
+	}
+
+	for len(j.segments) > 1 {
+		oldest := j.segments[0]
+		overSize := maxSize > 0 && total > maxSize
+		expired := maxAge > 0 && now.Sub(oldest.modTime) > maxAge
+		if !overSize && !expired {
+			return
+		}
+		if err := os.Remove(j.segmentPath(oldest.firstSeq)); err != nil && !os.IsNotExist(err) {
+			j.logger.Warnf("Failed to remove journal segment: %v", err)
+			return
+		}
+		os.Remove(j.indexPath(oldest.firstSeq))
+		j.logger.Infof("Journal: removed segment %d (%d bytes, last written %s)", oldest.firstSeq, oldest.size, oldest.modTime.Format(time.RFC3339))
+		total -= oldest.size
+		j.segments = j.segments[1:]
+		j.removed++
+	}
+}
+
+func (j *EventJournal) segmentPath(firstSeq uint64) string {
+	return filepath.Join(j.cfg.Dir, fmt.Sprintf("%020d", firstSeq)+journalSegmentExt)
+}
+
+func (j *EventJournal) indexPath(firstSeq uint64) string {
+	return filepath.Join(j.cfg.Dir, fmt.Sprintf("%020d", firstSeq)+journalIndexExt)
+}
+
+// listSegments returns the segments to read. The writer flushes first, so
+// queries see every record written so far.
+func (j *EventJournal) listSegments(flush bool) ([]journalSegment, error) {
+	if j.readOnly {
+		return listJournalSegments(j.cfg.Dir)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if flush && j.seg != nil {
+		if err := j.flush(); err != nil {
+			return nil, errors.New("failed to flush the journal: " + err.Error())
+		}
+	}
+	return append([]journalSegment(nil), j.segments...), nil
+}
+
+// Stats reports the segments of the journal
+func (j *EventJournal) Stats() (JournalStats, error) {
+	segments, err := j.listSegments(false)
+	if err != nil {
+		return JournalStats{}, err
+	}
+	stats := JournalStats{Dir: j.cfg.Dir, Segments: len(segments)}
+	for _, s := range segments {
+		stats.SizeBytes += s.size
+	}
+	if len(segments) > 0 {
+		stats.OldestSeq = segments[0].firstSeq
+		stats.Oldest = segments[0].firstTime
+	}
+	if !j.readOnly {
+		j.mu.Lock()
+		stats.NextSeq = j.nextSeq
+		stats.Written = j.written
+		stats.RemovedSegments = j.removed
+		stats.RepairedBytes = j.repaired
+		j.mu.Unlock()
+	}
+	return stats, nil
+}
+
+// Query returns up to q.Limit matching events from q.Cursor on, oldest first.
+// Segments outside the time range are skipped and the index is used to seek
+// within the others. Gap is set when segments the query covers were removed.
+func (j *EventJournal) Query(q HistoryQuery) (HistoryPage, error) {
+	page := HistoryPage{Events: make([]HistoryEvent, 0), NextCursor: q.Cursor}
+	segments, err := j.listSegments(true)
+	if err != nil || len(segments) == 0 {
+		return page, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 || limit > maxHistoryPage {
+		limit = maxHistoryPage
+	}
+	oldest := segments[0]
+	switch {
+	case q.Cursor > 0 && q.Cursor < oldest.firstSeq:
+		page.Gap = true
+	case q.Cursor == 0 && oldest.firstSeq > 1 && !q.Since.IsZero():
+		page.Gap = oldest.firstTime.IsZero() || oldest.firstTime.After(q.Since)
+	}
+
+	for i, seg := range segments {
+		if page.NextCursor < seg.firstSeq {
+			page.NextCursor = seg.firstSeq
+		}
+		if i+1 < len(segments) {
+			next := segments[i+1]
+			// Every record is before the cursor or the time range
+			if next.firstSeq <= q.Cursor || (!q.Since.IsZero() && !next.firstTime.IsZero() && next.firstTime.Before(q.Since.Add(-journalTimeSlack))) {
+				continue
+			}
+		}
+		// Every record of this segment and the following ones is after the time range
+		if !q.Until.IsZero() && !seg.firstTime.IsZero() && seg.firstTime.After(q.Until.Add(journalTimeSlack)) {
+			break
+		}
+		done, err := j.querySegment(seg, q, limit, &page)
+		if err != nil || done {
+			return page, err
+		}
+	}
+	return page, nil
+}
+
+// querySegment adds the matching records of seg to page; done reports that the
+// page is full or the time range is over
+func (j *EventJournal) querySegment(seg journalSegment, q HistoryQuery, limit int, page *HistoryPage) (bool, error) {
+	f, err := os.Open(j.segmentPath(seg.firstSeq))
+	if os.IsNotExist(err) {
+		// Removed by retention since the segments were listed
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New("failed to open journal segment: " + err.Error())
+	}
+	defer f.Close()
+
+	// Seek to the last indexed record before both the cursor and the time range
+	offset := int64(len(journalMagic))
+	if q.Cursor > 0 || !q.Since.IsZero() {
+		entries, _ := readJournalIndex(j.indexPath(seg.firstSeq))
+		for _, e := range entries {
+			if q.Cursor > 0 && e.Seq > q.Cursor {
+				break
+			}
+			if !q.Since.IsZero() && time.Unix(0, e.TimeNs).After(q.Since.Add(-journalTimeSlack)) {
+				break
+			}
+			offset = e.Offset
+		}
+	}
+
+	reader := newJournalReader(f, offset, seg.size)
+	for {
+		event, ok := reader.next()
+		if !ok {
+			return false, nil
+		}
+		if event.Seq < q.Cursor {
+			continue
+		}
+		if !q.Until.IsZero() && event.Time.After(q.Until.Add(journalTimeSlack)) {
+			page.NextCursor = event.Seq
+			return true, nil
+		}
+		page.NextCursor = event.Seq + 1
+		if !q.Since.IsZero() && event.Time.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && event.Time.After(q.Until) {
+			continue
+		}
+		if !q.Filter.Matches(event.Data) {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.NextCursor = event.Seq
+			page.HasMore = true
+			return true, nil
+		}
+		page.Events = append(page.Events, event)
+	}
+}
+
+// listJournalSegments finds the segment files of dir, oldest first, with the
+// time of their first record
+func listJournalSegments(dir string) ([]journalSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]journalSegment, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, journalSegmentExt) {
+			continue
+		}
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, journalSegmentExt), 10, 64)
+		if err != nil || firstSeq == 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed meanwhile
+			continue
+		}
+		seg := journalSegment{firstSeq: firstSeq, size: info.Size(), modTime: info.ModTime()}
+		seg.firstTime = segmentFirstTime(filepath.Join(dir, name), seg.size)
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, k int) bool { return segments[i].firstSeq < segments[k].firstSeq })
+	return segments, nil
+}
+
+// segmentFirstTime reads the time of the first record of a segment, zero if it has none
+func segmentFirstTime(path string, size int64) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	header := make([]byte, len(journalMagic))
+	if _, err := io.ReadFull(f, header); err != nil || string(header) != journalMagic {
+		return time.Time{}
+	}
+	event, ok := newJournalReader(f, int64(len(journalMagic)), size).next()
+	if !ok {
+		return time.Time{}
+	}
+	return event.Time
+}
+
+// journalReader reads the records of a segment from an offset up to end. It stops
+// at the first torn or corrupt record, and offset is then where the valid data ends.
+type journalReader struct {
+	r       *bufio.Reader
+	offset  int64
+	payload []byte
+}
+
+func newJournalReader(f *os.File, offset, end int64) *journalReader {
+	if end < offset {
+		end = offset
+	}
+	return &journalReader{
+		r:       bufio.NewReaderSize(io.NewSectionReader(f, offset, end-offset), 64<<10),
+		offset:  offset,
+		payload: make([]byte, journalMaxRecord),
+	}
+}
+
+// next returns the next record; ok is false at the end of the data or at a bad record
+func (r *journalReader) next() (HistoryEvent, bool) {
+	var frame [journalFrameSize]byte
+	if _, err := io.ReadFull(r.r, frame[:]); err != nil {
+		return HistoryEvent{}, false
+	}
+	size := binary.LittleEndian.Uint32(frame[0:4])
+	if size > journalMaxRecord {
+		return HistoryEvent{}, false
+	}
+	payload := r.payload[:size]
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return HistoryEvent{}, false
+	}
+	if crc32.Checksum(payload, journalCRC) != binary.LittleEndian.Uint32(frame[4:8]) {
+		return HistoryEvent{}, false
+	}
+	event, ok := decodeJournalRecord(payload)
+	if !ok {
+		return HistoryEvent{}, false
+	}
+	r.offset += journalFrameSize + int64(size)
+	return event, true
+}
+
+// encodeJournalRecord appends the frame and payload of one record to buf.
+// The payload is the sequence number, the time in Unix nanoseconds, the numeric
+// fields of Data and its syscall, comm and match strings, each after a length byte.
+func encodeJournalRecord(buf []byte, event HistoryEvent) []byte {
+	le := binary.LittleEndian
+	start := len(buf)
+	buf = append(buf, make([]byte, journalFrameSize)...)
+	buf = le.AppendUint64(buf, event.Seq)
+	buf = le.AppendUint64(buf, uint64(event.Time.UnixNano()))
+	for _, v := range []uint32{event.Pid, event.Tid, event.EventType, event.Uid, event.Gid, event.CPU, event.ChildPid, event.SampleRate} {
+		buf = le.AppendUint32(buf, v)
+	}
+	buf = le.AppendUint64(buf, event.CgroupID)
+	buf = le.AppendUint64(buf, event.TimestampNs)
+	buf = le.AppendUint64(buf, uint64(event.FD))
+	buf = le.AppendUint64(buf, event.Count)
+	for _, s := range []string{event.Syscall, event.Comm, event.Match} {
+		if len(s) > 255 {
+			s = s[:255]
+		}
+		buf = append(buf, byte(len(s)))
+		buf = append(buf, s...)
+	}
+
+	payload := buf[start+journalFrameSize:]
+	le.PutUint32(buf[start:], uint32(len(payload)))
+	le.PutUint32(buf[start+4:], crc32.Checksum(payload, journalCRC))
+	return buf
+}
+
+// decodeJournalRecord is the reverse of encodeJournalRecord for one payload
+func decodeJournalRecord(payload []byte) (HistoryEvent, bool) {
+	if len(payload) < journalRecordFixed {
+		return HistoryEvent{}, false
+	}
+	le := binary.LittleEndian
+	var event HistoryEvent
+	event.Seq = le.Uint64(payload[0:8])
+	event.Time = time.Unix(0, int64(le.Uint64(payload[8:16])))
+	for i, v := range []*uint32{&event.Pid, &event.Tid, &event.EventType, &event.Uid, &event.Gid, &event.CPU, &event.ChildPid, &event.SampleRate} {
+		*v = le.Uint32(payload[16+4*i:])
+	}
+	event.CgroupID = le.Uint64(payload[48:56])
+	event.TimestampNs = le.Uint64(payload[56:64])
+	event.FD = int64(le.Uint64(payload[64:72]))
+	event.Count = le.Uint64(payload[72:80])
+
+	rest := payload[journalRecordFixed:]
+	for _, s := range []*string{&event.Syscall, &event.Comm, &event.Match} {
+		if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+			return HistoryEvent{}, false
+		}
+		*s = string(rest[1 : 1+int(rest[0])])
+		rest = rest[1+int(rest[0]):]
+	}
+	return event, len(rest) == 0
+}
+
+func putJournalIndexEntry(buf []byte, e journalIndexEntry) {
+	le := binary.LittleEndian
+	le.PutUint64(buf[0:8], uint64(e.TimeNs))
+	le.PutUint64(buf[8:16], e.Seq)
+	le.PutUint64(buf[16:24], uint64(e.Offset))
+}
+
+// readJournalIndex loads the index of a segment; a torn last entry is ignored
+func readJournalIndex(path string) ([]journalIndexEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	entries := make([]journalIndexEntry, 0, len(data)/journalIndexEntrySize)
+	for ; len(data) >= journalIndexEntrySize; data = data[journalIndexEntrySize:] {
+		entries = append(entries, journalIndexEntry{
+			TimeNs: int64(le.Uint64(data[0:8])),
+			Seq:    le.Uint64(data[8:16]),
+			Offset: int64(le.Uint64(data[16:24])),
+		})
+	}
+	return entries, nil
+}
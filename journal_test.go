@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// journalTestEvents is enough records of writeJournalEvents to fill three 1 MB segments
+const journalTestEvents = 25000
+
+func openTestJournal(t *testing.T, cfg JournalConfig) *EventJournal {
+	t.Helper()
+	logger, err := NewLogger(LogConfig{Kind: LoggerStdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := OpenJournal(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// writeJournalEvents writes n read events one millisecond apart, ending now
+func writeJournalEvents(t *testing.T, j *EventJournal, n int) {
+	t.Helper()
+	base := monotonicNowNs() - uint64(n)*uint64(time.Millisecond)
+	for i := 0; i < n; i++ {
+		event := Data{Pid: 100, EventType: evtRead, Syscall: "read", Comm: "proc", Match: "pid", TimestampNs: base + uint64(i)*uint64(time.Millisecond), FD: 3, Count: uint64(i), SampleRate: 1}
+		if err := j.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// queryAll follows the cursor from q until the last page
+func queryAll(t *testing.T, j *EventJournal, q HistoryQuery) ([]HistoryEvent, []HistoryPage) {
+	t.Helper()
+	var events []HistoryEvent
+	var pages []HistoryPage
+	for {
+		page, err := j.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, page.Events...)
+		pages = append(pages, page)
+		if !page.HasMore {
+			return events, pages
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// checkSeqs checks events are numbered first, first+1, ... and there are n of them
+func checkSeqs(t *testing.T, events []HistoryEvent, first uint64, n int) {
+	t.Helper()
+	if len(events) != n {
+		t.Fatalf("%d events, want %d", len(events), n)
+	}
+	for i, event := range events {
+		if event.Seq != first+uint64(i) {
+			t.Fatalf("event %d has seq %d, want %d", i, event.Seq, first+uint64(i))
+		}
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+journalSegmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestJournalTornTail(t *testing.T) {
+	cfg := JournalConfig{Dir: t.TempDir(), SegmentSizeMB: 1}
+	j := openTestJournal(t, cfg)
+	writeJournalEvents(t, j, 100)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	badChecksum := encodeJournalRecord(nil, HistoryEvent{Seq: 101, Time: time.Now()})
+	badChecksum[4] ^= 0xff
+	for _, tc := range []struct {
+		name string
+		tail []byte
+	}{
+		{"torn frame", []byte{50, 0, 0}},
+		{"torn payload", []byte{50, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7}},
+		{"bad checksum", badChecksum},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files := segmentFiles(t, cfg.Dir)
+			f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(tc.tail)
+			f.Close()
+
+			j := openTestJournal(t, cfg)
+			defer j.Close()
+			stats, _ := j.Stats()
+			if stats.RepairedBytes != int64(len(tc.tail)) {
+				t.Errorf("repaired %d bytes, want %d", stats.RepairedBytes, len(tc.tail))
+			}
+			if stats.NextSeq != 101 {
+				t.Errorf("next seq %d, want 101", stats.NextSeq)
+			}
+			events, _ := queryAll(t, j, HistoryQuery{})
+			checkSeqs(t, events, 1, 100)
+		})
+	}
+
+	// Writing goes on after the last valid record
+	j = openTestJournal(t, cfg)
+	defer j.Close()
+	writeJournalEvents(t, j, 1)
+	events, _ := queryAll(t, j, HistoryQuery{})
+	checkSeqs(t, events, 1, 101)
+}
+
+func TestJournalTornHeader(t *testing.T) {
+	cfg := JournalConfig{Dir: t.TempDir(), SegmentSizeMB: 1}
+	j := openTestJournal(t, cfg)
+	writeJournalEvents(t, j, 100)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash while the next segment was being created left part of its header
+	torn := filepath.Join(cfg.Dir, fmt.Sprintf("%020d", 101)+journalSegmentExt)
+	if err := os.WriteFile(torn, []byte(journalMagic[:4]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	j = openTestJournal(t, cfg)
+	defer j.Close()
+	stats, _ := j.Stats()
+	if stats.RepairedBytes != 4 || stats.Segments != 2 || stats.NextSeq != 101 {
+		t.Errorf("after reopen: %+v, want 4 bytes repaired, 2 segments, next seq 101", stats)
+	}
+	writeJournalEvents(t, j, 10)
+	events, _ := queryAll(t, j, HistoryQuery{})
+	checkSeqs(t, events, 1, 110)
+}
+
+func TestJournalSizeRetention(t *testing.T) {
+	cfg := JournalConfig{Dir: t.TempDir(), SegmentSizeMB: 1, MaxSizeMB: 2}
+	j := openTestJournal(t, cfg)
+	defer j.Close()
+	writeJournalEvents(t, j, journalTestEvents)
+
+	stats, _ := j.Stats()
+	if stats.RemovedSegments == 0 || stats.OldestSeq == 1 {
+		t.Fatalf("no segment removed over max_size_mb: %+v", stats)
+	}
+	// The active segment may hold the journal over the bound, never under one segment
+	if stats.SizeBytes-int64(cfg.SegmentSizeMB)<<20 > int64(cfg.MaxSizeMB)<<20 || stats.Segments != len(segmentFiles(t, cfg.Dir)) {
+		t.Errorf("after retention: %+v with %d files", stats, len(segmentFiles(t, cfg.Dir)))
+	}
+
+	events, pages := queryAll(t, j, HistoryQuery{Cursor: 1, Limit: maxHistoryPage})
+	if !pages[0].Gap {
+		t.Error("no gap reported for a cursor in a removed segment")
+	}
+	checkSeqs(t, events, stats.OldestSeq, journalTestEvents-int(stats.OldestSeq)+1)
+
+	if page, _ := j.Query(HistoryQuery{Since: events[0].Time.Add(-time.Hour)}); !page.Gap {
+		t.Error("no gap reported for a time range starting in a removed segment")
+	}
+	if page, _ := j.Query(HistoryQuery{Since: events[len(events)-1].Time}); page.Gap {
+		t.Error("gap reported for a time range starting in a kept segment")
+	}
+}
+
+func TestJournalAgeRetentionKeepsActiveSegment(t *testing.T) {
+	cfg := JournalConfig{Dir: t.TempDir(), SegmentSizeMB: 1, MaxAgeHours: 1}
+	j := openTestJournal(t, cfg)
+	defer j.Close()
+	writeJournalEvents(t, j, journalTestEvents)
+	if stats, _ := j.Stats(); stats.Segments < 3 || stats.RemovedSegments != 0 {
+		t.Fatalf("before expiry: %+v, want 3 segments, none removed", stats)
+	}
+
+	// Every segment is now older than MaxAgeHours, the active one included
+	j.mu.Lock()
+	j.applyRetention(time.Now().Add(2 * time.Hour))
+	j.mu.Unlock()
+
+	stats, _ := j.Stats()
+	if stats.Segments != 1 || len(segmentFiles(t, cfg.Dir)) != 1 || stats.RemovedSegments < 2 {
+		t.Fatalf("after expiry: %+v with %d files, want the active segment only", stats, len(segmentFiles(t, cfg.Dir)))
+	}
+	writeJournalEvents(t, j, 1)
+	events, pages := queryAll(t, j, HistoryQuery{Cursor: 1})
+	if !pages[0].Gap {
+		t.Error("no gap reported after the expired segments were removed")
+	}
+	checkSeqs(t, events, stats.OldestSeq, journalTestEvents-int(stats.OldestSeq)+2)
+}
+
+func TestJournalQueryAcrossSegments(t *testing.T) {
+	cfg := JournalConfig{Dir: t.TempDir(), SegmentSizeMB: 1}
+	j := openTestJournal(t, cfg)
+	defer j.Close()
+	writeJournalEvents(t, j, journalTestEvents)
+
+	// Cursor pagination returns every record once, in order, over segment boundaries
+	all, pages := queryAll(t, j, HistoryQuery{Limit: 1000})
+	checkSeqs(t, all, 1, journalTestEvents)
+	if len(pages) != journalTestEvents/1000 || pages[0].Gap {
+		t.Errorf("%d pages (gap %v), want %d without gap", len(pages), pages[0].Gap, journalTestEvents/1000)
+	}
+	for _, page := range pages[:len(pages)-1] {
+		if page.NextCursor != page.Events[len(page.Events)-1].Seq+1 {
+			t.Fatalf("next cursor %d after seq %d", page.NextCursor, page.Events[len(page.Events)-1].Seq)
+		}
+	}
+
+	segments, _ := j.listSegments(false)
+	if len(segments) < 3 {
+		t.Fatalf("%d segments, want at least 3", len(segments))
+	}
+	for _, tc := range []struct {
+		name     string
+		from, to uint64 // seqs of the events at Since and Until
+	}{
+		{"within a segment", 10, 200},
+		{"over a boundary", segments[1].firstSeq - 300, segments[1].firstSeq + 300},
+		{"over two boundaries", segments[1].firstSeq - 10, segments[2].firstSeq + 10},
+		{"to the last event", segments[2].firstSeq - 1, journalTestEvents},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			since, until := all[tc.from-1].Time, all[tc.to-1].Time
+			var want []HistoryEvent
+			for _, event := range all {
+				if !event.Time.Before(since) && !event.Time.After(until) {
+					want = append(want, event)
+				}
+			}
+			got, _ := queryAll(t, j, HistoryQuery{Since: since, Until: until, Limit: 100})
+			checkSeqs(t, got, want[0].Seq, len(want))
+		})
+	}
+}
+
+func TestJournalWriteAfterFailedRollover(t *testing.T) {
+	cfg := JournalConfig{Dir: filepath.Join(t.TempDir(), "journal"), SegmentSizeMB: 1}
+	j := openTestJournal(t, cfg)
+	writeJournalEvents(t, j, 10)
+
+	// The next segment cannot be created while the directory is gone
+	moved := cfg.Dir + ".moved"
+	if err := os.Rename(cfg.Dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	j.mu.Lock()
+	j.segStarted = time.Now().Add(-2 * journalSegmentDuration)
+	j.mu.Unlock()
+	if err := j.Write(Data{Pid: 100, EventType: evtRead, SampleRate: 1}); err == nil {
+		t.Fatal("rollover into a missing directory succeeded")
+	}
+
+	if err := os.Rename(moved, cfg.Dir); err != nil {
+		t.Fatal(err)
+	}
+	writeJournalEvents(t, j, 1)
+	if stats, _ := j.Stats(); stats.Segments != 2 || len(segmentFiles(t, cfg.Dir)) != 2 {
+		t.Errorf("after the retried rollover: %+v, want 2 segments", stats)
+	}
+	events, _ := queryAll(t, j, HistoryQuery{})
+	checkSeqs(t, events, 1, 11)
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Write(Data{Pid: 100, EventType: evtRead, SampleRate: 1}); err != errJournalClosed {
+		t.Errorf("write after close returned %v, want %v", err, errJournalClosed)
+	}
+}
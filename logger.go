@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +10,15 @@ import (
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Logger kinds accepted in LogConfig
+const (
+	LoggerStdout = "stdout"
+	LoggerFile   = "file"
+	LoggerBoth   = "both"
+)
+
+const defaultLogPath = "/var/log/ebpf-game/ebpf-game.log"
+
 type Logger interface {
 	Infof(format string, args ...interface{})
 	Warnf(format string, args ...interface{})
@@ -44,29 +54,41 @@ func NewStdoutLogger() Logger {
 
 // NewRotatingFileLogger returns a logger that writes to a rotating file using lumberjack
 func NewRotatingFileLogger(maxSizeMB, maxBackups, maxAgeDays int, compress bool) Logger {
-	w := &lumberjack.Logger{
-		Filename:   "/var/log/ebpf-game/ebpf-game.log",
-		MaxSize:    maxSizeMB,
-		MaxBackups: maxBackups,
-		MaxAge:     maxAgeDays,
-		Compress:   compress,
-	}
-	l := log.New(w, "", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
-	return &baseLogger{logger: l}
+	l, _ := NewLogger(LogConfig{Kind: LoggerFile, Path: defaultLogPath, MaxSizeMB: maxSizeMB, MaxBackups: maxBackups, MaxAgeDays: maxAgeDays, Compress: compress})
+	return l
 }
 
 // NewStdoutAndFileLogger writes to both stdout and a rotating file
 func NewStdoutAndFileLogger(maxSizeMB, maxBackups, maxAgeDays int, compress bool) Logger {
-	fileW := &lumberjack.Logger{
-		Filename:   "/var/log/ebpf-game/ebpf-game.log",
-		MaxSize:    maxSizeMB,
-		MaxBackups: maxBackups,
-		MaxAge:     maxAgeDays,
-		Compress:   compress,
+	l, _ := NewLogger(LogConfig{Kind: LoggerBoth, Path: defaultLogPath, MaxSizeMB: maxSizeMB, MaxBackups: maxBackups, MaxAgeDays: maxAgeDays, Compress: compress})
+	return l
+}
+
+// NewLogger builds the logger described by cfg
+func NewLogger(cfg LogConfig) (Logger, error) {
+	var w io.Writer
+	switch cfg.Kind {
+	case LoggerStdout:
+		w = os.Stdout
+	case LoggerFile:
+		w = newRotatingWriter(cfg)
+	case LoggerBoth:
+		w = io.MultiWriter(os.Stdout, newRotatingWriter(cfg))
+	default:
+		return nil, errors.New("unknown logger kind: " + cfg.Kind)
+	}
+	l := log.New(w, "", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+	return &baseLogger{logger: l, debug: cfg.Debug}, nil
+}
+
+func newRotatingWriter(cfg LogConfig) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAgeDays,
+		Compress:   cfg.Compress,
 	}
-	mw := io.MultiWriter(os.Stdout, fileW)
-	l := log.New(mw, "", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
-	return &baseLogger{logger: l}
 }
 
 // EnableDebug wraps a logger enabling debug prints
@@ -1,17 +1,37 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
 func main() {
-	// Create logger (stdout + rotating file as example)
-	logger := NewStdoutAndFileLogger(10, 5, 7, false)
+	if code, ok := runSubcommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
+	// Defaults < config file (-config / EBPF_GAME_CONFIG) < EBPF_GAME_* variables < flags
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := NewLogger(cfg.Log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// Create application
-	app, err := NewApplication("8080", logger)
+	app, err := NewApplication(cfg, logger)
 	if err != nil {
 		logger.Errorf("Failed to create application: %v", err)
 		os.Exit(1)
@@ -30,4 +50,4 @@ func main() {
 	<-sig
 
 	logger.Infof("Exiting...")
-} 
\ No newline at end of file
+}
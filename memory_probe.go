@@ -0,0 +1,735 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capacities match max_entries of the target and skip maps in ebpf_probe.c
+const (
+	memoryTargetPIDsCapacity    = 1024
+	memoryTargetCommsCapacity   = 1024
+	memoryTargetCgroupsCapacity = 1024
+	memorySkipPIDsCapacity      = 1024
+	memorySkipCommsCapacity     = 1024
+	memorySkipCgroupsCapacity   = 1024
+)
+
+// memoryInflight is the emulated inflight map entry, keyed by TID
+type memoryInflight struct {
+	startNs   uint64
+	eventType uint32
+}
+
+// MemoryProbe is an in-memory Probe.
+// It mirrors the skip_pid / skip_comms / skip_cgroups / target_pids / target_comms / target_cgroups /
+// print_all_flag maps of ebpf_probe.c
+// and applies the same filter as handle_sys_call to events passed to Inject,
+// so everything above the kernel layer can be exercised without CAP_BPF.
+type MemoryProbe struct {
+	logger      Logger
+	mu          sync.RWMutex
+	skipPIDs    map[uint32]struct{}
+	skipComms   map[string]struct{}
+	skipCgroups map[uint64]bool
+	targetPIDs  map[uint32]targetValue
+	targetComms map[string]struct{}
+	// targetCgroups maps a cgroup ID to whether descendants are included;
+	// cgroupParents is the hierarchy, which the kernel knows but Inject cannot
+	targetCgroups map[uint64]bool
+	cgroupParents map[uint64]uint64
+	printAll      bool
+	syscalls      map[string]bool
+	counts        map[syscallCountsKey]uint64
+	inflight      map[uint32]memoryInflight
+	exits         map[syscallCountsKey]SyscallExitCount
+	errnos        map[syscallErrnoKey]uint64
+	handler       EventHandler
+	delivered     uint64
+	stopped       bool
+	// rate_config, the token buckets and sampling cycle of ebpf_probe.c
+	rateLimit     RateLimitConfig
+	sampleCounter uint32
+	globalBucket  tokenBucket
+	pidBuckets    map[uint32]*tokenBucket
+	suppressed    SuppressedCounts
+}
+
+// NewMemoryProbe creates an in-memory probe in the same initial state as
+// NewEBpfProbe: own PID skipped, no targets, print_all disabled.
+func NewMemoryProbe(logger Logger) *MemoryProbe {
+	pid := selfPID()
+	mp := &MemoryProbe{
+		logger:        logger,
+		skipPIDs:      map[uint32]struct{}{pid: {}},
+		skipComms:     make(map[string]struct{}),
+		skipCgroups:   make(map[uint64]bool),
+		targetPIDs:    make(map[uint32]targetValue),
+		targetComms:   make(map[string]struct{}),
+		targetCgroups: make(map[uint64]bool),
+		cgroupParents: make(map[uint64]uint64),
+		syscalls:      make(map[string]bool),
+		counts:        make(map[syscallCountsKey]uint64),
+		inflight:      make(map[uint32]memoryInflight),
+		exits:         make(map[syscallCountsKey]SyscallExitCount),
+		errnos:        make(map[syscallErrnoKey]uint64),
+		pidBuckets:    make(map[uint32]*tokenBucket),
+		handler:       func(event Data) { logEvent(logger, event) },
+	}
+	for _, name := range defaultSyscalls {
+		mp.syscalls[name] = true
+	}
+	return mp
+}
+
+// Start begins delivering injected events
+func (mp *MemoryProbe) Start() {
+	mp.logger.Infof("Starting in-memory probe")
+}
+
+// Stop makes further injected events a no-op
+func (mp *MemoryProbe) Stop() {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.stopped = true
+}
+
+// SetEventHandler replaces the default log handler. Must be called before Start.
+func (mp *MemoryProbe) SetEventHandler(handler EventHandler) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.handler = handler
+}
+
+// Info reports the in-memory transport; enabled syscalls are "attached" to Inject
+func (mp *MemoryProbe) Info() ProbeInfo {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	attachments := make([]AttachInfo, 0, len(mp.syscalls))
+	for _, sc := range syscallRegistry {
+		if mp.syscalls[sc.name] {
+			attachments = append(attachments, memoryAttachInfo(sc.name))
+		}
+	}
+	return ProbeInfo{Transport: "memory", Attachments: attachments}
+}
+
+// ReaderStats only counts delivered events: injected events are never dropped or lost
+func (mp *MemoryProbe) ReaderStats() (ReaderStats, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	return ReaderStats{Delivered: mp.delivered}, nil
+}
+
+func memoryAttachInfo(name string) AttachInfo {
+	return AttachInfo{Syscall: name, Mechanism: "memory", Target: "Inject"}
+}
+
+// EnableSyscall starts delivering injected events of a registry syscall
+func (mp *MemoryProbe) EnableSyscall(name string) (AttachInfo, error) {
+	if _, ok := lookupSyscall(name); !ok {
+		return AttachInfo{}, errors.New("unknown syscall: " + name)
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.syscalls[name] = true
+	return memoryAttachInfo(name), nil
+}
+
+// DisableSyscall drops injected events of a registry syscall
+func (mp *MemoryProbe) DisableSyscall(name string) error {
+	if _, ok := lookupSyscall(name); !ok {
+		return errors.New("unknown syscall: " + name)
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if !mp.syscalls[name] {
+		return errors.New("syscall " + name + " is not enabled")
+	}
+	delete(mp.syscalls, name)
+	return nil
+}
+
+// AddSkipPID adds a PID to the skip list (skip_pid map)
+func (mp *MemoryProbe) AddSkipPID(pid uint32) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.skipPIDs[pid] = struct{}{}
+}
+
+// Inject emulates a syscall of the given type made by pid (single-threaded, fd and count unset).
+// It returns true if the syscall is enabled, the event passed the filters and
+// was delivered to the handler.
+func (mp *MemoryProbe) Inject(pid uint32, eventType uint32) bool {
+	return mp.InjectEvent(Data{Pid: pid, Tid: pid, EventType: eventType, FD: -1})
+}
+
+// InjectEvent is Inject with a fully populated event. Syscall and a zero
+// TimestampNs are filled in the way the kernel and decodeData would.
+func (mp *MemoryProbe) InjectEvent(event Data) bool {
+	mp.mu.Lock()
+	match, ok := mp.matches(event)
+	if mp.stopped || !mp.syscalls[eventTypeName(event.EventType)] || !ok {
+		mp.mu.Unlock()
+		return false
+	}
+	if event.TimestampNs == 0 {
+		event.TimestampNs = monotonicNowNs()
+	}
+	mp.counts[syscallCountsKey{Pid: event.Pid, EventType: event.EventType}]++
+	mp.inflight[event.Tid] = memoryInflight{startNs: event.TimestampNs, eventType: event.EventType}
+	sampleRate, allowed := mp.rateAllowed(event.Pid)
+	if !allowed {
+		mp.mu.Unlock()
+		return false
+	}
+	handler := mp.handler
+	mp.delivered++
+	mp.mu.Unlock()
+
+	event.Syscall = eventTypeName(event.EventType)
+	event.Match = matchRuleName(match)
+	event.SampleRate = sampleRate
+	handler(event)
+	return true
+}
+
+// InjectExit emulates the return of the syscall tid is in, like handle_sys_exit:
+// ret < 0 is -errno. exitNs of 0 means now. It returns false if no traced
+// entry is pending for tid.
+func (mp *MemoryProbe) InjectExit(pid, tid uint32, ret int64, exitNs uint64) bool {
+	if exitNs == 0 {
+		exitNs = monotonicNowNs()
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	start, ok := mp.inflight[tid]
+	if !ok || mp.stopped {
+		return false
+	}
+	delete(mp.inflight, tid)
+
+	key := syscallCountsKey{Pid: pid, EventType: start.eventType}
+	exit := mp.exits[key]
+	exit.Pid, exit.EventType = pid, start.eventType
+	duration := exitNs - start.startNs
+	exit.Calls++
+	exit.TotalNs += duration
+	if duration > exit.MaxNs {
+		exit.MaxNs = duration
+	}
+	if ret < 0 {
+		exit.Errors++
+		mp.errnos[syscallErrnoKey{Pid: pid, EventType: start.eventType, Errno: uint32(-ret)}]++
+	} else {
+		exit.Bytes += uint64(ret)
+	}
+	mp.exits[key] = exit
+	return true
+}
+
+// monotonicNowNs reads the clock used by bpf_ktime_get_ns
+func monotonicNowNs() uint64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return uint64(ts.Nano())
+}
+
+// matches is handle_sys_call's filter and returns the MATCH_* rule that let
+// the event through; callers must hold mp.mu
+func (mp *MemoryProbe) matches(event Data) (uint32, bool) {
+	if _, skip := mp.skipPIDs[event.Pid]; skip {
+		return 0, false
+	}
+	if _, skip := mp.skipComms[event.Comm]; skip {
+		return 0, false
+	}
+	if mp.cgroupIn(mp.skipCgroups, event.CgroupID) {
+		return 0, false
+	}
+	if mp.printAll {
+		return matchAll, true
+	}
+	if _, ok := mp.targetPIDs[event.Pid]; ok {
+		return matchPID, true
+	}
+	if _, ok := mp.targetComms[event.Comm]; ok {
+		return matchComm, true
+	}
+	if mp.cgroupIn(mp.targetCgroups, event.CgroupID) {
+		return matchCgroup, true
+	}
+	return 0, false
+}
+
+// cgroupIn is cgroup_in: id is in cgroups, or one of its ancestors is with
+// descendants included; callers must hold mp.mu
+func (mp *MemoryProbe) cgroupIn(cgroups map[uint64]bool, id uint64) bool {
+	if _, ok := cgroups[id]; ok {
+		return true
+	}
+	ancestor := id
+	for depth := 0; depth < maxCgroupDepth; depth++ {
+		parent, ok := mp.cgroupParents[ancestor]
+		if !ok {
+			break
+		}
+		if cgroups[parent] {
+			return true
+		}
+		ancestor = parent
+	}
+	return false
+}
+
+// SetCgroupParent records that cgroup child is directly below parent,
+// so that descendant cgroup targets can be emulated
+func (mp *MemoryProbe) SetCgroupParent(child, parent uint64) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.cgroupParents[child] = parent
+}
+
+// AddTargetPID adds a PID to the target list; with followChildren the
+// processes passed to InjectFork become targets, recursively
+func (mp *MemoryProbe) AddTargetPID(pid uint32, followChildren bool) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if len(mp.targetPIDs) >= memoryTargetPIDsCapacity {
+		if _, ok := mp.targetPIDs[pid]; !ok {
+			return errors.New("target_pids map is full")
+		}
+	}
+	value := targetValue{}
+	if followChildren {
+		value.Flags = targetFollow
+	}
+	mp.targetPIDs[pid] = value
+	return nil
+}
+
+// InjectFork emulates tp_sched_process_fork: if parent is a target following
+// its children, child becomes an inherited target and a process_fork event
+// is delivered. It returns true if child was added.
+func (mp *MemoryProbe) InjectFork(parent, child uint32) bool {
+	mp.mu.Lock()
+	if mp.stopped || !mp.inherit(parent, child) {
+		mp.mu.Unlock()
+		return false
+	}
+	handler := mp.handler
+	mp.delivered++
+	mp.mu.Unlock()
+
+	handler(Data{
+		Pid:         parent,
+		Tid:         parent,
+		EventType:   evtProcessFork,
+		Syscall:     eventTypeName(evtProcessFork),
+		TimestampNs: monotonicNowNs(),
+		FD:          -1,
+		Match:       matchRuleName(matchPID),
+		SampleRate:  1,
+		ChildPid:    child,
+	})
+	return true
+}
+
+// inherit is the map update of tp_sched_process_fork; mp.mu must be held.
+// It returns true if child was added.
+func (mp *MemoryProbe) inherit(parent, child uint32) bool {
+	target, ok := mp.targetPIDs[parent]
+	if !ok || target.Flags&targetFollow == 0 || child == 0 || child == parent {
+		return false
+	}
+	if _, exists := mp.targetPIDs[child]; exists || len(mp.targetPIDs) >= memoryTargetPIDsCapacity {
+		return false
+	}
+	mp.targetPIDs[child] = targetValue{Flags: targetFollow | targetInherited, Parent: parent}
+	return true
+}
+
+// InjectProcessExit emulates sched_process_exit for the last thread of process pid:
+// a targeted pid is removed and a process_exit event is delivered. It returns true
+// if pid was a target.
+func (mp *MemoryProbe) InjectProcessExit(pid uint32, comm string) bool {
+	mp.mu.Lock()
+	if _, ok := mp.targetPIDs[pid]; mp.stopped || !ok {
+		mp.mu.Unlock()
+		return false
+	}
+	delete(mp.targetPIDs, pid)
+	handler := mp.handler
+	mp.delivered++
+	mp.mu.Unlock()
+
+	handler(Data{
+		Pid:         pid,
+		Tid:         pid,
+		EventType:   evtProcessExit,
+		Syscall:     eventTypeName(evtProcessExit),
+		TimestampNs: monotonicNowNs(),
+		Comm:        comm,
+		FD:          -1,
+		Match:       matchRuleName(matchPID),
+		SampleRate:  1,
+	})
+	return true
+}
+
+// InjectThreadExit emulates sched_process_exit for a thread whose process lives on:
+// the entry inherited under its TID, if any, is removed without an event, and the
+// process keeps its own. It returns true if tid had an entry.
+func (mp *MemoryProbe) InjectThreadExit(tid uint32) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if _, ok := mp.targetPIDs[tid]; mp.stopped || !ok {
+		return false
+	}
+	delete(mp.targetPIDs, tid)
+	return true
+}
+
+// RemoveTargetPID removes a PID from the target list
+func (mp *MemoryProbe) RemoveTargetPID(pid uint32) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if _, ok := mp.targetPIDs[pid]; !ok {
+		return ErrPIDNotTargeted
+	}
+	delete(mp.targetPIDs, pid)
+	return nil
+}
+
+// ClearTargetPIDs clears all target PIDs
+func (mp *MemoryProbe) ClearTargetPIDs() error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.targetPIDs = make(map[uint32]targetValue)
+	return nil
+}
+
+// AddTargetComm adds a task name to the target list
+func (mp *MemoryProbe) AddTargetComm(comm string) error {
+	if _, err := newCommKey(comm); err != nil {
+		return err
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if len(mp.targetComms) >= memoryTargetCommsCapacity {
+		if _, ok := mp.targetComms[comm]; !ok {
+			return errors.New("target_comms map is full")
+		}
+	}
+	mp.targetComms[comm] = struct{}{}
+	return nil
+}
+
+// RemoveTargetComm removes a task name from the target list
+func (mp *MemoryProbe) RemoveTargetComm(comm string) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if _, ok := mp.targetComms[comm]; !ok {
+		return ErrCommNotTargeted
+	}
+	delete(mp.targetComms, comm)
+	return nil
+}
+
+// GetTargetComms returns all target task names
+func (mp *MemoryProbe) GetTargetComms() ([]string, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	comms := make([]string, 0, len(mp.targetComms))
+	for comm := range mp.targetComms {
+		comms = append(comms, comm)
+	}
+	sort.Strings(comms)
+	return comms, nil
+}
+
+// AddTargetCgroup adds a cgroup ID to the target list, optionally with its descendants
+func (mp *MemoryProbe) AddTargetCgroup(id uint64, descendants bool) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if len(mp.targetCgroups) >= memoryTargetCgroupsCapacity {
+		if _, ok := mp.targetCgroups[id]; !ok {
+			return errors.New("target_cgroups map is full")
+		}
+	}
+	mp.targetCgroups[id] = descendants
+	return nil
+}
+
+// RemoveTargetCgroup removes a cgroup ID from the target list
+func (mp *MemoryProbe) RemoveTargetCgroup(id uint64) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if _, ok := mp.targetCgroups[id]; !ok {
+		return ErrCgroupNotTargeted
+	}
+	delete(mp.targetCgroups, id)
+	return nil
+}
+
+// GetTargetCgroups returns all target cgroups, without paths
+func (mp *MemoryProbe) GetTargetCgroups() ([]CgroupTarget, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	targets := make([]CgroupTarget, 0, len(mp.targetCgroups))
+	for id, descendants := range mp.targetCgroups {
+		targets = append(targets, CgroupTarget{ID: id, Descendants: descendants})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].ID < targets[j].ID })
+	return targets, nil
+}
+
+// AddExcludedPID adds a PID to the skip list
+func (mp *MemoryProbe) AddExcludedPID(pid uint32) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if len(mp.skipPIDs) >= memorySkipPIDsCapacity {
+		if _, ok := mp.skipPIDs[pid]; !ok {
+			return errors.New("skip_pid map is full")
+		}
+	}
+	mp.skipPIDs[pid] = struct{}{}
+	return nil
+}
+
+// RemoveExcludedPID removes a PID from the skip list; the monitor's own PID stays
+func (mp *MemoryProbe) RemoveExcludedPID(pid uint32) error {
+	if pid == selfPID() {
+		return errExcludeSelf
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if _, ok := mp.skipPIDs[pid]; !ok {
+		return ErrPIDNotExcluded
+	}
+	delete(mp.skipPIDs, pid)
+	return nil
+}
+
+// GetExcludedPIDs returns all excluded PIDs, including the monitor's own
+func (mp *MemoryProbe) GetExcludedPIDs() ([]uint32, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	pids := make([]uint32, 0, len(mp.skipPIDs))
+	for pid := range mp.skipPIDs {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, nil
+}
+
+// AddExcludedComm adds a task name to the skip list
+func (mp *MemoryProbe) AddExcludedComm(comm string) error {
+	if _, err := newCommKey(comm); err != nil {
+		return err
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if len(mp.skipComms) >= memorySkipCommsCapacity {
+		if _, ok := mp.skipComms[comm]; !ok {
+			return errors.New("skip_comms map is full")
+		}
+	}
+	mp.skipComms[comm] = struct{}{}
+	return nil
+}
+
+// RemoveExcludedComm removes a task name from the skip list
+func (mp *MemoryProbe) RemoveExcludedComm(comm string) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if _, ok := mp.skipComms[comm]; !ok {
+		return ErrCommNotExcluded
+	}
+	delete(mp.skipComms, comm)
+	return nil
+}
+
+// GetExcludedComms returns all excluded task names
+func (mp *MemoryProbe) GetExcludedComms() ([]string, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	comms := make([]string, 0, len(mp.skipComms))
+	for comm := range mp.skipComms {
+		comms = append(comms, comm)
+	}
+	sort.Strings(comms)
+	return comms, nil
+}
+
+// AddExcludedCgroup adds a cgroup ID to the skip list, optionally with its descendants
+func (mp *MemoryProbe) AddExcludedCgroup(id uint64, descendants bool) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if len(mp.skipCgroups) >= memorySkipCgroupsCapacity {
+		if _, ok := mp.skipCgroups[id]; !ok {
+			return errors.New("skip_cgroups map is full")
+		}
+	}
+	mp.skipCgroups[id] = descendants
+	return nil
+}
+
+// RemoveExcludedCgroup removes a cgroup ID from the skip list
+func (mp *MemoryProbe) RemoveExcludedCgroup(id uint64) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if _, ok := mp.skipCgroups[id]; !ok {
+		return ErrCgroupNotExcluded
+	}
+	delete(mp.skipCgroups, id)
+	return nil
+}
+
+// GetExcludedCgroups returns all excluded cgroups, without paths
+func (mp *MemoryProbe) GetExcludedCgroups() ([]CgroupTarget, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	cgroups := make([]CgroupTarget, 0, len(mp.skipCgroups))
+	for id, descendants := range mp.skipCgroups {
+		cgroups = append(cgroups, CgroupTarget{ID: id, Descendants: descendants})
+	}
+	sort.Slice(cgroups, func(i, j int) bool { return cgroups[i].ID < cgroups[j].ID })
+	return cgroups, nil
+}
+
+// rateAllowed is rate_allowed of ebpf_probe.c; mp.mu must be held
+func (mp *MemoryProbe) rateAllowed(pid uint32) (uint32, bool) {
+	cfg := mp.rateLimit
+	sampleRate := uint32(1)
+	if cfg.SampleEvery > 1 {
+		sampleRate = cfg.SampleEvery
+		pos := mp.sampleCounter
+		mp.sampleCounter = pos + 1
+		if mp.sampleCounter >= cfg.SampleEvery {
+			mp.sampleCounter = 0
+		}
+		if pos != 0 {
+			mp.suppressed.Sampled++
+			return sampleRate, false
+		}
+	}
+
+	now := monotonicNowNs()
+	if cfg.PIDRate != 0 {
+		b, ok := mp.pidBuckets[pid]
+		if !ok {
+			b = &tokenBucket{}
+			mp.pidBuckets[pid] = b
+		}
+		if !b.take(cfg.PIDRate, cfg.PIDBurst, now) {
+			mp.suppressed.PIDLimited++
+			return sampleRate, false
+		}
+	}
+	if cfg.GlobalRate != 0 && !mp.globalBucket.take(cfg.GlobalRate, cfg.GlobalBurst, now) {
+		mp.suppressed.GlobalLimited++
+		return sampleRate, false
+	}
+	return sampleRate, true
+}
+
+// SetRateLimit replaces the emulated sampling and token-bucket limits
+func (mp *MemoryProbe) SetRateLimit(cfg RateLimitConfig) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.rateLimit = cfg
+	return nil
+}
+
+// GetRateLimit returns the emulated limits
+func (mp *MemoryProbe) GetRateLimit() (RateLimitConfig, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	return mp.rateLimit, nil
+}
+
+// GetSuppressedCounts returns the injected calls not delivered because of the limits
+func (mp *MemoryProbe) GetSuppressedCounts() (SuppressedCounts, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	return mp.suppressed, nil
+}
+
+// SetPrintAll sets the print_all flag
+func (mp *MemoryProbe) SetPrintAll(enabled bool) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.printAll = enabled
+	return nil
+}
+
+// GetTargetPIDs returns all target PIDs
+func (mp *MemoryProbe) GetTargetPIDs() ([]uint32, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	pids := make([]uint32, 0, len(mp.targetPIDs))
+	for pid := range mp.targetPIDs {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, nil
+}
+
+// GetTargets returns all target PIDs with their follow/inherited flags, sorted by PID
+func (mp *MemoryProbe) GetTargets() ([]TargetPID, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	targets := make([]TargetPID, 0, len(mp.targetPIDs))
+	for pid, value := range mp.targetPIDs {
+		targets = append(targets, value.target(pid))
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].PID < targets[j].PID })
+	return targets, nil
+}
+
+// GetPrintAllState returns the current print_all flag state
+func (mp *MemoryProbe) GetPrintAllState() (bool, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	return mp.printAll, nil
+}
+
+// GetSyscallCounts returns the emulated syscall_counts map
+func (mp *MemoryProbe) GetSyscallCounts() ([]SyscallCount, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	counts := make([]SyscallCount, 0, len(mp.counts))
+	for key, count := range mp.counts {
+		counts = append(counts, SyscallCount{Pid: key.Pid, EventType: key.EventType, Count: count})
+	}
+	return counts, nil
+}
+
+// GetExitCounts returns the emulated syscall_exits map
+func (mp *MemoryProbe) GetExitCounts() ([]SyscallExitCount, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	exits := make([]SyscallExitCount, 0, len(mp.exits))
+	for _, exit := range mp.exits {
+		exits = append(exits, exit)
+	}
+	return exits, nil
+}
+
+// GetErrnoCounts returns the emulated syscall_errors map
+func (mp *MemoryProbe) GetErrnoCounts() ([]SyscallErrnoCount, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	errnos := make([]SyscallErrnoCount, 0, len(mp.errnos))
+	for key, count := range mp.errnos {
+		errnos = append(errnos, SyscallErrnoCount{Pid: key.Pid, EventType: key.EventType, Errno: key.Errno, Count: count})
+	}
+	return errnos, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func newTestMemoryProbe(t *testing.T) (*MemoryProbe, *[]Data) {
+	t.Helper()
+	logger, err := NewLogger(LogConfig{Kind: LoggerStdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := NewMemoryProbe(logger)
+	var delivered []Data
+	probe.SetEventHandler(func(event Data) { delivered = append(delivered, event) })
+	return probe, &delivered
+}
+
+func TestMemoryProbeFilter(t *testing.T) {
+	self := uint32(os.Getpid())
+	for _, tc := range []struct {
+		name    string
+		setup   func(p *MemoryProbe)
+		pid     uint32
+		deliver bool
+	}{
+		{
+			name: "no target",
+			pid:  100,
+		},
+		{
+			name:    "target pid",
+			setup:   func(p *MemoryProbe) { p.AddTargetPID(100, false) },
+			pid:     100,
+			deliver: true,
+		},
+		{
+			name:  "other pid",
+			setup: func(p *MemoryProbe) { p.AddTargetPID(100, false) },
+			pid:   101,
+		},
+		{
+			name:  "removed target pid",
+			setup: func(p *MemoryProbe) { p.AddTargetPID(100, false); p.RemoveTargetPID(100) },
+			pid:   100,
+		},
+		{
+			name:    "print_all",
+			setup:   func(p *MemoryProbe) { p.SetPrintAll(true) },
+			pid:     100,
+			deliver: true,
+		},
+		{
+			name:  "own pid skipped in print_all",
+			setup: func(p *MemoryProbe) { p.SetPrintAll(true) },
+			pid:   self,
+		},
+		{
+			name: "skipped pid wins over target pid",
+			setup: func(p *MemoryProbe) {
+				p.AddSkipPID(100)
+				p.AddTargetPID(100, false)
+			},
+			pid: 100,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			probe, delivered := newTestMemoryProbe(t)
+			if tc.setup != nil {
+				tc.setup(probe)
+			}
+			ok := probe.Inject(tc.pid, evtRead)
+			want := 0
+			if tc.deliver {
+				want = 1
+			}
+			if ok != tc.deliver || len(*delivered) != want {
+				t.Fatalf("Inject = %v with %d events delivered, want delivered: %v", ok, len(*delivered), tc.deliver)
+			}
+			if ok && ((*delivered)[0].Pid != tc.pid || (*delivered)[0].EventType != evtRead) {
+				t.Errorf("delivered %+v", (*delivered)[0])
+			}
+		})
+	}
+}
+
+func TestMemoryProbeStop(t *testing.T) {
+	probe, delivered := newTestMemoryProbe(t)
+	probe.SetPrintAll(true)
+	probe.Stop()
+	if probe.Inject(100, evtRead) || len(*delivered) != 0 {
+		t.Error("event delivered after Stop")
+	}
+}
+
+func TestMemoryProbeSyscalls(t *testing.T) {
+	openat, _ := lookupSyscall("openat")
+	probe, delivered := newTestMemoryProbe(t)
+	probe.SetPrintAll(true)
+
+	if probe.Inject(100, openat.eventType) {
+		t.Error("event of a syscall that is not enabled delivered")
+	}
+	if _, err := probe.EnableSyscall("openat"); err != nil {
+		t.Fatalf("EnableSyscall: %v", err)
+	}
+	if !probe.Inject(100, openat.eventType) {
+		t.Error("event of an enabled syscall not delivered")
+	}
+	if err := probe.DisableSyscall("openat"); err != nil {
+		t.Fatalf("DisableSyscall: %v", err)
+	}
+	if probe.Inject(100, openat.eventType) {
+		t.Error("event of a disabled syscall delivered")
+	}
+	if _, err := probe.EnableSyscall("nosuchcall"); err == nil {
+		t.Error("EnableSyscall of an unknown syscall succeeded")
+	}
+	if len(*delivered) != 1 {
+		t.Errorf("%d events delivered, want 1", len(*delivered))
+	}
+}
+
+func TestMemoryProbeInjectEvent(t *testing.T) {
+	probe, delivered := newTestMemoryProbe(t)
+	probe.AddTargetPID(100, false)
+
+	if !probe.InjectEvent(Data{Pid: 100, Tid: 101, Comm: "worker", FD: 3, Count: 64, EventType: evtWrite}) {
+		t.Fatal("event of a target PID not delivered")
+	}
+	event := (*delivered)[0]
+	if event.Syscall != "write" || event.TimestampNs == 0 {
+		t.Errorf("event not filled in like the kernel: %+v", event)
+	}
+	if event.Tid != 101 || event.Comm != "worker" || event.FD != 3 || event.Count != 64 {
+		t.Errorf("injected fields not kept: %+v", event)
+	}
+}
+
+func TestMemoryProbeMatch(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		setup     func(p *MemoryProbe)
+		event     Data
+		wantMatch string // empty: the event is filtered out
+	}{
+		{
+			name:      "target pid",
+			setup:     func(p *MemoryProbe) { p.AddTargetPID(100, false) },
+			event:     Data{Pid: 100, EventType: evtRead},
+			wantMatch: "pid",
+		},
+		{
+			name:      "target comm",
+			setup:     func(p *MemoryProbe) { p.AddTargetComm("nginx") },
+			event:     Data{Pid: 100, Comm: "nginx", EventType: evtWrite},
+			wantMatch: "comm",
+		},
+		{
+			name:  "other comm",
+			setup: func(p *MemoryProbe) { p.AddTargetComm("nginx") },
+			event: Data{Pid: 100, Comm: "postgres", EventType: evtWrite},
+		},
+		{
+			name:      "print_all",
+			setup:     func(p *MemoryProbe) { p.SetPrintAll(true) },
+			event:     Data{Pid: 100, EventType: evtRead},
+			wantMatch: "all",
+		},
+		{
+			name:      "target cgroup",
+			setup:     func(p *MemoryProbe) { p.AddTargetCgroup(10, false) },
+			event:     Data{Pid: 100, CgroupID: 10, EventType: evtRead},
+			wantMatch: "cgroup",
+		},
+		{
+			name: "descendant cgroup",
+			setup: func(p *MemoryProbe) {
+				p.AddTargetCgroup(10, true)
+				p.SetCgroupParent(11, 10)
+				p.SetCgroupParent(12, 11)
+			},
+			event:     Data{Pid: 100, CgroupID: 12, EventType: evtRead},
+			wantMatch: "cgroup",
+		},
+		{
+			name: "descendant of a cgroup targeted alone",
+			setup: func(p *MemoryProbe) {
+				p.AddTargetCgroup(10, false)
+				p.SetCgroupParent(11, 10)
+			},
+			event: Data{Pid: 100, CgroupID: 11, EventType: evtRead},
+		},
+		{
+			name: "pid rule before comm",
+			setup: func(p *MemoryProbe) {
+				p.AddTargetPID(100, false)
+				p.AddTargetComm("nginx")
+			},
+			event:     Data{Pid: 100, Comm: "nginx", EventType: evtRead},
+			wantMatch: "pid",
+		},
+		{
+			name: "skipped comm wins over target pid",
+			setup: func(p *MemoryProbe) {
+				p.AddTargetPID(100, false)
+				p.AddExcludedComm("nginx")
+			},
+			event: Data{Pid: 100, Comm: "nginx", EventType: evtRead},
+		},
+		{
+			name: "skipped cgroup wins over print_all",
+			setup: func(p *MemoryProbe) {
+				p.SetPrintAll(true)
+				p.AddExcludedCgroup(10, true)
+				p.SetCgroupParent(11, 10)
+			},
+			event: Data{Pid: 100, CgroupID: 11, EventType: evtRead},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			probe, delivered := newTestMemoryProbe(t)
+			tc.setup(probe)
+			ok := probe.InjectEvent(tc.event)
+			want := 0
+			if tc.wantMatch != "" {
+				want = 1
+			}
+			if ok != (want == 1) || len(*delivered) != want {
+				t.Fatalf("InjectEvent = %v with %d events delivered, want delivered: %v", ok, len(*delivered), tc.wantMatch != "")
+			}
+			if ok && (*delivered)[0].Match != tc.wantMatch {
+				t.Errorf("match = %q, want %q", (*delivered)[0].Match, tc.wantMatch)
+			}
+		})
+	}
+}
+
+func TestMemoryProbeFork(t *testing.T) {
+	probe, delivered := newTestMemoryProbe(t)
+	probe.AddTargetPID(100, true)
+	probe.AddTargetPID(200, false)
+
+	if !probe.InjectFork(100, 101) {
+		t.Fatal("child of a followed target not inherited")
+	}
+	if probe.InjectFork(200, 201) {
+		t.Error("child of a target that is not followed inherited")
+	}
+	if !probe.InjectFork(101, 102) {
+		t.Error("grandchild of a followed target not inherited")
+	}
+	if probe.InjectFork(100, 200) {
+		t.Error("explicit target overwritten by an inherited entry")
+	}
+
+	targets, _ := probe.GetTargets()
+	want := map[uint32]TargetPID{
+		100: {PID: 100, FollowChildren: true},
+		101: {PID: 101, FollowChildren: true, Inherited: true, ParentPID: 100},
+		102: {PID: 102, FollowChildren: true, Inherited: true, ParentPID: 101},
+		200: {PID: 200},
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("targets = %+v", targets)
+	}
+	for _, target := range targets {
+		if target != want[target.PID] {
+			t.Errorf("target %+v, want %+v", target, want[target.PID])
+		}
+	}
+	if !probe.Inject(102, evtRead) {
+		t.Error("event of an inherited target not delivered")
+	}
+
+	if len(*delivered) != 3 || (*delivered)[0].EventType != evtProcessFork || (*delivered)[0].ChildPid != 101 {
+		t.Errorf("delivered %+v, want fork of 101, fork of 102, read", *delivered)
+	}
+}
+
+func TestMemoryProbeExit(t *testing.T) {
+	probe, delivered := newTestMemoryProbe(t)
+	probe.AddTargetPID(100, true)
+	probe.InjectFork(100, 101)
+
+	if !probe.InjectProcessExit(101, "worker") {
+		t.Error("exit of a target not reported")
+	}
+	if probe.InjectProcessExit(101, "worker") {
+		t.Error("exit of a PID that is no longer a target reported")
+	}
+	if probe.InjectProcessExit(300, "other") {
+		t.Error("exit of a PID that is not a target reported")
+	}
+	if probe.Inject(101, evtRead) {
+		t.Error("event of an exited target delivered")
+	}
+	if !probe.Inject(100, evtRead) {
+		t.Error("event of the parent of an exited child not delivered")
+	}
+
+	kinds := []uint32{}
+	for _, event := range *delivered {
+		kinds = append(kinds, event.EventType)
+	}
+	if len(kinds) != 3 || kinds[0] != evtProcessFork || kinds[1] != evtProcessExit || kinds[2] != evtRead {
+		t.Errorf("delivered event types %v, want fork, exit, read", kinds)
+	}
+	if (*delivered)[1].Comm != "worker" {
+		t.Errorf("exit event comm %q, want worker", (*delivered)[1].Comm)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// metricsContentType is the Prometheus text exposition format
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+	// defaultMetricsMaxTargets is the default cap on PIDs with their own series
+	defaultMetricsMaxTargets = 200
+	// metricsOtherPID labels the events of PIDs beyond the cap
+	metricsOtherPID = "other"
+)
+
+// MetricsConfig tunes GET /metrics
+type MetricsConfig struct {
+	// MaxTargets caps the PIDs with their own ebpf_game_target_syscalls_total series;
+	// the syscalls of further PIDs are summed under pid="other". 0 disables per-target series.
+	MaxTargets int `json:"max_targets" yaml:"max_targets"`
+}
+
+// EventMetrics counts the events delivered by the probe, by type and per target PID.
+// A PID keeps its series until its process_exit event or, since print_all mode has no
+// exit events, until a scrape finds its process gone; either frees the slot.
+//
+// Under 1-in-N sampling each event stands for N calls, so the per-target event counts
+// undercount; the estimated counts scale each event by its sample rate instead. Calls
+// suppressed by the rate limits are in neither.
+type EventMetrics struct {
+	mu         sync.Mutex
+	maxTargets int
+	byType     map[uint32]uint64
+	targets    map[uint32]map[uint32]*targetCounts // PID -> syscall event type -> counts
+	other      map[uint32]*targetCounts
+}
+
+// targetCounts are the events of one syscall and the calls they stand for
+type targetCounts struct {
+	events uint64
+	calls  uint64
+}
+
+func (c *targetCounts) add(event Data) {
+	c.events++
+	c.calls += uint64(event.SampleRate)
+}
+
+// NewEventMetrics creates empty counters with at most maxTargets per-target PIDs
+func NewEventMetrics(maxTargets int) *EventMetrics {
+	return &EventMetrics{
+		maxTargets: maxTargets,
+		byType:     make(map[uint32]uint64),
+		targets:    make(map[uint32]map[uint32]*targetCounts),
+		other:      make(map[uint32]*targetCounts),
+	}
+}
+
+// Observe counts one event
+func (m *EventMetrics) Observe(event Data) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byType[event.EventType]++
+
+	if isProcessEvent(event.EventType) {
+		if event.EventType == evtProcessExit {
+			delete(m.targets, event.Pid)
+		}
+		return
+	}
+	counts, ok := m.targets[event.Pid]
+	if !ok {
+		if len(m.targets) >= m.maxTargets {
+			if m.maxTargets > 0 {
+				countOf(m.other, event.EventType).add(event)
+			}
+			return
+		}
+		counts = make(map[uint32]*targetCounts)
+		m.targets[event.Pid] = counts
+	}
+	countOf(counts, event.EventType).add(event)
+}
+
+func countOf(counts map[uint32]*targetCounts, eventType uint32) *targetCounts {
+	c, ok := counts[eventType]
+	if !ok {
+		c = &targetCounts{}
+		counts[eventType] = c
+	}
+	return c
+}
+
+// write appends the event families to w
+func (m *EventMetrics) write(w *metricsWriter) {
+	pids := m.writeCounts(w)
+
+	// After rendering, so the last counts of a gone process are still scraped once.
+	// The stats run without the lock, which Observe needs on the reader goroutine.
+	var gone []uint32
+	for _, pid := range pids {
+		if _, err := os.Stat("/proc/" + strconv.FormatUint(uint64(pid), 10)); os.IsNotExist(err) {
+			gone = append(gone, pid)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pid := range gone {
+		delete(m.targets, pid)
+	}
+	w.family("ebpf_game_target_series", "gauge", "PIDs that currently have their own per-target series.")
+	w.sample("ebpf_game_target_series", float64(len(m.targets)))
+	w.family("ebpf_game_target_series_limit", "gauge", "Maximum number of PIDs with their own per-target series.")
+	w.sample("ebpf_game_target_series_limit", float64(m.maxTargets))
+}
+
+// writeCounts appends the event counters and returns the PIDs with their own series
+func (m *EventMetrics) writeCounts(w *metricsWriter) []uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.family("ebpf_game_events_total", "counter", "Events received from the probe by type.")
+	for _, eventType := range sortedKeys(m.byType) {
+		w.sample("ebpf_game_events_total", float64(m.byType[eventType]), "type", eventTypeName(eventType))
+	}
+
+	pids := sortedKeys(m.targets)
+	w.family("ebpf_game_target_syscalls_total", "counter", "Syscall events per target PID, capped at metrics.max_targets PIDs; the rest are under pid=\"other\". Sampled out calls are not counted.")
+	m.writeTargets(w, "ebpf_game_target_syscalls_total", pids, func(c *targetCounts) uint64 { return c.events })
+	w.family("ebpf_game_target_syscalls_estimated_total", "counter", "Syscalls per target PID estimated from the events scaled by their sample rate; rate limited calls are not counted.")
+	m.writeTargets(w, "ebpf_game_target_syscalls_estimated_total", pids, func(c *targetCounts) uint64 { return c.calls })
+	return pids
+}
+
+// writeTargets writes one sample per target PID and syscall, then the pid="other" ones
+func (m *EventMetrics) writeTargets(w *metricsWriter, name string, pids []uint32, value func(*targetCounts) uint64) {
+	for _, pid := range pids {
+		counts := m.targets[pid]
+		label := strconv.FormatUint(uint64(pid), 10)
+		for _, eventType := range sortedKeys(counts) {
+			w.sample(name, float64(value(counts[eventType])), "pid", label, "syscall", eventTypeName(eventType))
+		}
+	}
+	for _, eventType := range sortedKeys(m.other) {
+		w.sample(name, float64(value(m.other[eventType])), "pid", metricsOtherPID, "syscall", eventTypeName(eventType))
+	}
+}
+
+func sortedKeys[V any](m map[uint32]V) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// metricsWriter renders the Prometheus text exposition format
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+// family writes the HELP and TYPE lines that precede the samples of name
+func (w *metricsWriter) family(name, typ, help string) {
+	w.buf.WriteString("# HELP " + name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help) + "\n")
+	w.buf.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// sample writes one sample; labels are name, value pairs
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			w.buf.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
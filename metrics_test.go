@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// noSuchPID is above the largest pid_max, so /proc never has it
+const noSuchPID = 1<<22 + 1
+
+func TestEventMetricsTargets(t *testing.T) {
+	m := NewEventMetrics(2)
+	self := selfPID()
+	m.Observe(Data{Pid: self, EventType: evtRead, SampleRate: 1})
+	m.Observe(Data{Pid: self, EventType: evtRead, SampleRate: 4})
+	m.Observe(Data{Pid: noSuchPID, EventType: evtWrite, SampleRate: 1})
+	m.Observe(Data{Pid: 7, EventType: evtWrite, SampleRate: 10})
+
+	w := &metricsWriter{}
+	m.write(w)
+	out := w.buf.String()
+	pid := strconv.FormatUint(uint64(self), 10)
+	for _, line := range []string{
+		`ebpf_game_target_syscalls_total{pid="` + pid + `",syscall="read"} 2`,
+		`ebpf_game_target_syscalls_estimated_total{pid="` + pid + `",syscall="read"} 5`,
+		`ebpf_game_target_syscalls_total{pid="other",syscall="write"} 1`,
+		`ebpf_game_target_syscalls_estimated_total{pid="other",syscall="write"} 10`,
+		// The gone PID is scraped a last time, then dropped
+		`ebpf_game_target_syscalls_total{pid="4194305",syscall="write"} 1`,
+		`ebpf_game_target_series 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	w = &metricsWriter{}
+	m.write(w)
+	if strings.Contains(w.buf.String(), `pid="4194305"`) {
+		t.Error("series of a gone PID still scraped")
+	}
+}
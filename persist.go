@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// skip_pid values; the kernel only checks that the key exists
+const (
+	skipExcluded = 1 // added through the exclusions
+	skipSelf     = 2 // the monitor's own PID
+)
+
+// stateSaveInterval is how often the state file is refreshed when the state changed
+const stateSaveInterval = 2 * time.Second
+
+// pinnedMaps are kept in bpffs across restarts when ProbeOptions.PinPath is set
+var pinnedMaps = []string{"target_pids", "skip_pid", "print_all_flag"}
+
+// configurePinning marks pinnedMaps to be pinned by name under pinPath, which must
+// be on a bpffs. It reports whether a previous run left them there to be reused.
+func configurePinning(spec *ebpf.CollectionSpec, pinPath string) (bool, error) {
+	if err := os.MkdirAll(pinPath, 0o700); err != nil {
+		return false, errors.New("cannot create pin directory: " + err.Error())
+	}
+	var fs unix.Statfs_t
+	if err := unix.Statfs(pinPath, &fs); err != nil {
+		return false, errors.New("cannot access pin directory: " + err.Error())
+	}
+	if fs.Type != unix.BPF_FS_MAGIC {
+		return false, errors.New(pinPath + " is not on a bpf filesystem")
+	}
+
+	for _, name := range pinnedMaps {
+		if _, ok := spec.Maps[name]; !ok {
+			return false, errors.New("map " + name + " missing from eBPF spec")
+		}
+	}
+	reused := false
+	for _, name := range pinnedMaps {
+		spec.Maps[name].Pinning = ebpf.PinByName
+		if _, err := os.Stat(filepath.Join(pinPath, name)); err == nil {
+			reused = true
+		}
+	}
+	return reused, nil
+}
+
+// removePinnedMaps unpins maps left by a build whose map layout differs
+func removePinnedMaps(pinPath string) error {
+	for _, name := range pinnedMaps {
+		if err := os.Remove(filepath.Join(pinPath, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneReusedMaps drops what a previous run left in the pinned maps that no longer
+// applies: that run's own PID, targets whose process is gone or was replaced, and
+// excluded PIDs whose process is gone
+func pruneReusedMaps(objs *ebpf_probeObjects, self uint32, logger Logger) error {
+	var staleTargets, staleSkipped []uint32
+
+	var pid uint32
+	var target targetValue
+	iter := objs.TargetPids.Iterate()
+	for iter.Next(&pid, &target) {
+		if !target.alive(pid) {
+			staleTargets = append(staleTargets, pid)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return errors.New("error iterating target PIDs: " + err.Error())
+	}
+
+	var value uint32
+	iter = objs.SkipPid.Iterate()
+	for iter.Next(&pid, &value) {
+		if pid == self {
+			continue
+		}
+		if value == skipSelf {
+			staleSkipped = append(staleSkipped, pid)
+			continue
+		}
+		if _, err := processStartTime(pid); err != nil {
+			staleSkipped = append(staleSkipped, pid)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return errors.New("error iterating skipped PIDs: " + err.Error())
+	}
+
+	// Deleted after iterating: deleting while iterating a hash map may restart the walk
+	for i := range staleTargets {
+		if err := objs.TargetPids.Delete(&staleTargets[i]); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return err
+		}
+	}
+	for i := range staleSkipped {
+		if err := objs.SkipPid.Delete(&staleSkipped[i]); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return err
+		}
+	}
+	logger.Infof("Pinned maps: dropped exited or replaced targets %v and stale skipped PIDs %v", staleTargets, staleSkipped)
+	return nil
+}
+
+// PersistedPID is a PID saved with the start time of its process, so that a
+// PID recycled while the monitor was down is not restored
+type PersistedPID struct {
+	PID            uint32 `json:"pid"`
+	FollowChildren bool   `json:"follow_children,omitempty"`
+	Inherited      bool   `json:"inherited,omitempty"`
+	ParentPID      uint32 `json:"parent_pid,omitempty"`
+	StartTime      uint64 `json:"start_time,omitempty"`
+}
+
+// PersistedExclusions is the exclusions part of PersistedState, without the monitor's own PID
+type PersistedExclusions struct {
+	PIDs    []PersistedPID `json:"pids"`
+	Comms   []string       `json:"comms"`
+	Cgroups []CgroupTarget `json:"cgroups"`
+}
+
+// PersistedState is the controller state saved to the state file
+type PersistedState struct {
+	SavedAt    time.Time           `json:"saved_at"`
+	PrintAll   bool                `json:"print_all"`
+	Targets    []PersistedPID      `json:"targets"`
+	Comms      []string            `json:"comms"`
+	Cgroups    []CgroupTarget      `json:"cgroups"`
+	Exclusions PersistedExclusions `json:"exclusions"`
+	RateLimit  RateLimitConfig     `json:"rate_limit"`
+}
+
+// fillStartTimes records the current start time of every saved PID
+func (s *PersistedState) fillStartTimes() {
+	for _, pids := range [][]PersistedPID{s.Targets, s.Exclusions.PIDs} {
+		for i := range pids {
+			// A process gone since the snapshot is saved without start time
+			pids[i].StartTime, _ = processStartTime(pids[i].PID)
+		}
+	}
+}
+
+// alive reports whether the process p was saved for is still running
+func (p PersistedPID) alive() bool {
+	start, err := processStartTime(p.PID)
+	if err != nil {
+		return false
+	}
+	return p.StartTime == 0 || p.StartTime == start
+}
+
+// alive reports whether the process a target_pids entry was added for still runs
+// under pid, like PersistedPID.alive
+func (v targetValue) alive(pid uint32) bool {
+	return PersistedPID{PID: pid, StartTime: v.StartTime}.alive()
+}
+
+// loadState reads the state file; a missing file is not an error and returns nil
+func loadState(path string) (*PersistedState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state PersistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.New("malformed state file " + path + ": " + err.Error())
+	}
+	return &state, nil
+}
+
+// saveState replaces the state file atomically, so a crash never leaves it truncated
+func saveState(path string, state PersistedState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// restoreCommands turns a saved state into the commands that rebuild it.
+// PIDs whose process exited or was replaced are left out. With pinned maps the
+// kernel already holds the PIDs and print_all, so only the stale PIDs are removed.
+func restoreCommands(state *PersistedState, pinned bool, logger Logger) []MonitorCommand {
+	var cmds []MonitorCommand
+
+	var stale, staleExcluded []uint32
+	for _, t := range state.Targets {
+		if !t.alive() {
+			stale = append(stale, t.PID)
+			continue
+		}
+		if !pinned {
+			// The kernel only inherits children on fork: restored children become followed targets
+			cmds = append(cmds, MonitorCommand{Kind: CommandAddPID, PID: t.PID, FollowChildren: t.FollowChildren})
+		}
+	}
+	for _, p := range state.Exclusions.PIDs {
+		if !p.alive() {
+			staleExcluded = append(staleExcluded, p.PID)
+			continue
+		}
+		if !pinned {
+			cmds = append(cmds, MonitorCommand{Kind: CommandExcludePID, PID: p.PID})
+		}
+	}
+	if len(stale) > 0 || len(staleExcluded) > 0 {
+		logger.Infof("Not restoring PIDs whose process is gone or was replaced: targets %v, exclusions %v", stale, staleExcluded)
+		if pinned {
+			cmds = append(cmds,
+				MonitorCommand{Kind: CommandRemovePID, PIDs: stale},
+				MonitorCommand{Kind: CommandRemoveExclusions, PIDs: staleExcluded})
+		}
+	}
+
+	for _, comm := range state.Comms {
+		cmds = append(cmds, MonitorCommand{Kind: CommandAddComm, Comm: comm})
+	}
+	for _, comm := range state.Exclusions.Comms {
+		cmds = append(cmds, MonitorCommand{Kind: CommandExcludeComm, Comm: comm})
+	}
+	for _, cgroups := range []struct {
+		kind    CommandKind
+		targets []CgroupTarget
+	}{{CommandAddCgroup, state.Cgroups}, {CommandExcludeCgroup, state.Exclusions.Cgroups}} {
+		for _, cg := range cgroups.targets {
+			// A cgroup recreated under the same path gets a new ID
+			if cg.Path != "" {
+				path, id, err := resolveCgroup(cg.Path)
+				if err != nil {
+					logger.Warnf("Not restoring cgroup %s: %v", cg.Path, err)
+					continue
+				}
+				cg.Path, cg.ID = path, id
+			}
+			cmds = append(cmds, MonitorCommand{Kind: cgroups.kind, Cgroup: cg})
+		}
+	}
+
+	// rate_config is not pinned
+	if state.RateLimit.Enabled() {
+		cmds = append(cmds, MonitorCommand{Kind: CommandSetRateLimit, RateLimit: state.RateLimit})
+	}
+
+	// Last: adding targets turns print_all off
+	if !pinned || len(state.Comms) > 0 || len(state.Cgroups) > 0 {
+		cmds = append(cmds, MonitorCommand{Kind: CommandSetPrintAll, PrintAll: state.PrintAll})
+	}
+	return cmds
+}
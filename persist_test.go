@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestTargetValueAlive(t *testing.T) {
+	self := selfPID()
+	start, err := processStartTime(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		pid   uint32
+		value targetValue
+		want  bool
+	}{
+		{"same process", self, targetValue{StartTime: start}, true},
+		{"start time unknown", self, targetValue{}, true},
+		{"recycled PID", self, targetValue{StartTime: start + 1}, false},
+		{"gone process", noSuchPID, targetValue{}, false},
+	} {
+		if got := tc.value.alive(tc.pid); got != tc.want {
+			t.Errorf("%s: alive = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
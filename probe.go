@@ -0,0 +1,83 @@
+package main
+
+import "errors"
+
+var (
+	// ErrPIDNotTargeted is returned by RemoveTargetPID when the PID is not in the target list
+	ErrPIDNotTargeted = errors.New("PID is not in the target list")
+	// ErrCommNotTargeted is returned by RemoveTargetComm when the task name is not in the target list
+	ErrCommNotTargeted = errors.New("comm is not in the target list")
+	// ErrCgroupNotTargeted is returned by RemoveTargetCgroup when the cgroup is not in the target list
+	ErrCgroupNotTargeted = errors.New("cgroup is not in the target list")
+	// ErrPIDNotExcluded is returned by RemoveExcludedPID when the PID is not excluded
+	ErrPIDNotExcluded = errors.New("PID is not excluded")
+	// ErrCommNotExcluded is returned by RemoveExcludedComm when the task name is not excluded
+	ErrCommNotExcluded = errors.New("comm is not excluded")
+	// ErrCgroupNotExcluded is returned by RemoveExcludedCgroup when the cgroup is not excluded
+	ErrCgroupNotExcluded = errors.New("cgroup is not excluded")
+)
+
+// EventHandler receives every event that passed the probe's filters
+type EventHandler func(event Data)
+
+// ProbeInfo describes how a probe is wired to the kernel
+type ProbeInfo struct {
+	Transport   string       `json:"transport"`
+	Attachments []AttachInfo `json:"attachments"`
+	PinPath     string       `json:"pin_path,omitempty"`
+}
+
+// ReaderStats accounts for events between the kernel and the event handler
+type ReaderStats struct {
+	// KernelDropped counts events the kernel could not queue (full ring buffer or
+	// failed bpf_perf_event_output), summed over CPUs
+	KernelDropped uint64 `json:"kernel_dropped"`
+	// LostSamples is reported by the perf buffer when userspace falls behind; the
+	// same samples are usually in KernelDropped too
+	LostSamples  uint64 `json:"lost_samples"`
+	ReadErrors   uint64 `json:"read_errors"`
+	DecodeErrors uint64 `json:"decode_errors"`
+	// Delivered counts events passed to the event handler
+	Delivered uint64 `json:"delivered"`
+}
+
+// Probe is the kernel-facing side of the monitor.
+// EBpfProbe implements it on top of the loaded eBPF objects, MemoryProbe
+// emulates it in-process so the controller and API can run without root.
+type Probe interface {
+	Start()
+	Stop()
+	SetEventHandler(handler EventHandler)
+	Info() ProbeInfo
+	ReaderStats() (ReaderStats, error)
+	EnableSyscall(name string) (AttachInfo, error)
+	DisableSyscall(name string) error
+	AddTargetPID(pid uint32, followChildren bool) error
+	RemoveTargetPID(pid uint32) error
+	ClearTargetPIDs() error
+	AddTargetComm(comm string) error
+	RemoveTargetComm(comm string) error
+	GetTargetComms() ([]string, error)
+	AddTargetCgroup(id uint64, descendants bool) error
+	RemoveTargetCgroup(id uint64) error
+	GetTargetCgroups() ([]CgroupTarget, error)
+	AddExcludedPID(pid uint32) error
+	RemoveExcludedPID(pid uint32) error
+	GetExcludedPIDs() ([]uint32, error)
+	AddExcludedComm(comm string) error
+	RemoveExcludedComm(comm string) error
+	GetExcludedComms() ([]string, error)
+	AddExcludedCgroup(id uint64, descendants bool) error
+	RemoveExcludedCgroup(id uint64) error
+	GetExcludedCgroups() ([]CgroupTarget, error)
+	SetPrintAll(enabled bool) error
+	SetRateLimit(cfg RateLimitConfig) error
+	GetRateLimit() (RateLimitConfig, error)
+	GetSuppressedCounts() (SuppressedCounts, error)
+	GetTargetPIDs() ([]uint32, error)
+	GetTargets() ([]TargetPID, error)
+	GetPrintAllState() (bool, error)
+	GetSyscallCounts() ([]SyscallCount, error)
+	GetExitCounts() ([]SyscallExitCount, error)
+	GetErrnoCounts() ([]SyscallErrnoCount, error)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// Process lifecycle event types (EVT_PROCESS_* in ebpf_probe.c), outside the syscall registry range
+const (
+	evtProcessFork = 1001
+	evtProcessExit = 1002
+)
+
+// target_pids value flags (TARGET_* in ebpf_probe.c)
+const (
+	targetFollow    = 1
+	targetInherited = 2
+)
+
+// defaultForkChildPIDOffset is the offset of child_pid in sched_process_fork
+// records on kernels whose format file cannot be read
+const defaultForkChildPIDOffset = 44
+
+// tracingRoots are the usual tracefs mount points
+var tracingRoots = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// errNoFollowChildren is returned when child following is requested but the
+// sched_process_fork tracepoint could not be attached
+var errNoFollowChildren = errors.New("following children is unavailable: sched:sched_process_fork is not attached")
+
+// targetValue matches struct target_t in ebpf_probe.c
+type targetValue struct {
+	Flags  uint32
+	Parent uint32
+	// StartTime is the process start time of /proc/<pid>/stat, 0 if unknown
+	StartTime uint64
+}
+
+// maxExitedTargets bounds the history of exited targets kept by the PIDManager
+const maxExitedTargets = 100
+
+// TargetPID is one entry of the target_pids map
+type TargetPID struct {
+	PID            uint32 `json:"pid"`
+	FollowChildren bool   `json:"follow_children"`
+	Inherited      bool   `json:"inherited"`
+	ParentPID      uint32 `json:"parent_pid,omitempty"`
+}
+
+// ExitedTarget is a target PID removed because its process exited
+type ExitedTarget struct {
+	TargetPID
+	Comm     string    `json:"comm,omitempty"`
+	ExitedAt time.Time `json:"exited_at"`
+}
+
+func (v targetValue) target(pid uint32) TargetPID {
+	return TargetPID{
+		PID:            pid,
+		FollowChildren: v.Flags&targetFollow != 0,
+		Inherited:      v.Flags&targetInherited != 0,
+		ParentPID:      v.Parent,
+	}
+}
+
+// isProcessEvent tells process lifecycle events apart from syscall events
+func isProcessEvent(eventType uint32) bool {
+	return eventType == evtProcessFork || eventType == evtProcessExit
+}
+
+// processEventName names process lifecycle events in logs, stats and the API
+func processEventName(eventType uint32) (string, bool) {
+	switch eventType {
+	case evtProcessFork:
+		return "process_fork", true
+	case evtProcessExit:
+		return "process_exit", true
+	}
+	return "", false
+}
+
+// lookupProcessEvent is the reverse of processEventName
+func lookupProcessEvent(name string) (uint32, bool) {
+	for _, eventType := range []uint32{evtProcessFork, evtProcessExit} {
+		if n, _ := processEventName(eventType); n == name {
+			return eventType, true
+		}
+	}
+	return 0, false
+}
+
+// tracepointFieldOffset reads the offset of field from the tracepoint format file
+func tracepointFieldOffset(group, name, field string) (uint32, error) {
+	var lastErr error
+	for _, root := range tracingRoots {
+		f, err := os.Open(root + "/events/" + group + "/" + name + "/format")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		defer f.Close()
+
+		// e.g. "	field:pid_t child_pid;	offset:44;	size:4;	signed:1;"
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			parts := strings.Split(scanner.Text(), ";")
+			if len(parts) < 2 || !strings.HasSuffix(strings.TrimSpace(parts[0]), " "+field) {
+				continue
+			}
+			offset := strings.TrimPrefix(strings.TrimSpace(parts[1]), "offset:")
+			value, err := strconv.ParseUint(offset, 10, 32)
+			if err != nil {
+				return 0, errors.New("malformed offset for " + field + ": " + parts[1])
+			}
+			return uint32(value), nil
+		}
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("field " + field + " not found in " + group + ":" + name)
+	}
+	return 0, lastErr
+}
+
+// configureProcessTracking rewrites the record offsets of the sched tracepoints before load
+func configureProcessTracking(spec *ebpf.CollectionSpec, logger Logger) error {
+	offset, err := tracepointFieldOffset("sched", "sched_process_fork", "child_pid")
+	if err != nil {
+		logger.Warnf("Cannot read sched_process_fork format, assuming child_pid at offset %d: %v", defaultForkChildPIDOffset, err)
+		offset = defaultForkChildPIDOffset
+	}
+	return spec.RewriteConstants(map[string]interface{}{"fork_child_pid_offset": offset})
+}
+
+// isThread reports whether id is a thread of another process rather than a
+// process (thread group leader), according to /proc
+func isThread(id uint32) (bool, error) {
+	f, err := os.Open("/proc/" + strconv.FormatUint(uint64(id), 10) + "/status")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Tgid:") {
+			continue
+		}
+		tgid, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "Tgid:")), 10, 32)
+		if err != nil {
+			return false, err
+		}
+		return uint32(tgid) != id, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("no Tgid in /proc status of " + strconv.FormatUint(uint64(id), 10))
+}
+
+// processStartTime reads the start time of pid in clock ticks after boot from
+// /proc/<pid>/stat; it tells a recycled PID apart from the process it was saved for
+func processStartTime(pid uint32) (uint64, error) {
+	data, err := os.ReadFile("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	// comm (field 2) may hold spaces and parentheses: count fields after the last ')'
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return 0, errors.New("malformed /proc stat of " + strconv.FormatUint(uint64(pid), 10))
+	}
+	// fields[0] is field 3 (state), starttime is field 22
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return 0, errors.New("malformed /proc stat of " + strconv.FormatUint(uint64(pid), 10))
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
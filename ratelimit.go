@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Suppression reasons (SUPPRESS_* in ebpf_probe.c), indexes of suppressed_events
+const (
+	suppressSampled     = 0
+	suppressPIDLimit    = 1
+	suppressGlobalLimit = 2
+	suppressReasons     = 3
+)
+
+const (
+	// maxRateLimit bounds rates and bursts so the scaled token math in take_token cannot overflow
+	maxRateLimit = 1000000
+	// nsPerSec is NS_PER_SEC in ebpf_probe.c, the scale of bucket tokens
+	nsPerSec = uint64(1000000000)
+	// maxRefillNs caps the refill interval like take_token
+	maxRefillNs = 10 * nsPerSec
+)
+
+// RateLimitConfig limits the syscall events emitted by the kernel. Suppressed calls
+// are still counted in the per-PID stats and by reason in SuppressedCounts.
+// The zero value emits everything.
+type RateLimitConfig struct {
+	// GlobalRate is the events per second emitted for all processes; 0 is unlimited
+	GlobalRate uint64 `json:"global_rate" yaml:"global_rate"`
+	// GlobalBurst is the global bucket size; 0 means GlobalRate (one second of events)
+	GlobalBurst uint64 `json:"global_burst" yaml:"global_burst"`
+	// PIDRate is the events per second emitted for each process; 0 is unlimited
+	PIDRate  uint64 `json:"pid_rate" yaml:"pid_rate"`
+	PIDBurst uint64 `json:"pid_burst" yaml:"pid_burst"`
+	// SampleEvery keeps 1 in N matching calls, before the limits; 0 and 1 keep all
+	SampleEvery uint32 `json:"sample_every" yaml:"sample_every"`
+}
+
+// rateConfigValue matches struct rate_config_t in ebpf_probe.c
+type rateConfigValue struct {
+	GlobalRate  uint64
+	GlobalBurst uint64
+	PIDRate     uint64
+	PIDBurst    uint64
+	SampleEvery uint32
+	_           uint32
+}
+
+func (cfg RateLimitConfig) value() rateConfigValue {
+	return rateConfigValue{
+		GlobalRate:  cfg.GlobalRate,
+		GlobalBurst: cfg.GlobalBurst,
+		PIDRate:     cfg.PIDRate,
+		PIDBurst:    cfg.PIDBurst,
+		SampleEvery: cfg.SampleEvery,
+	}
+}
+
+func (v rateConfigValue) config() RateLimitConfig {
+	return RateLimitConfig{
+		GlobalRate:  v.GlobalRate,
+		GlobalBurst: v.GlobalBurst,
+		PIDRate:     v.PIDRate,
+		PIDBurst:    v.PIDBurst,
+		SampleEvery: v.SampleEvery,
+	}
+}
+
+// Normalize fills the default bursts and clears the bursts of unlimited buckets
+func (cfg RateLimitConfig) Normalize() RateLimitConfig {
+	if cfg.GlobalRate == 0 {
+		cfg.GlobalBurst = 0
+	} else if cfg.GlobalBurst == 0 {
+		cfg.GlobalBurst = cfg.GlobalRate
+	}
+	if cfg.PIDRate == 0 {
+		cfg.PIDBurst = 0
+	} else if cfg.PIDBurst == 0 {
+		cfg.PIDBurst = cfg.PIDRate
+	}
+	if cfg.SampleEvery == 1 {
+		cfg.SampleEvery = 0
+	}
+	return cfg
+}
+
+// Validate checks the bounds the kernel relies on
+func (cfg RateLimitConfig) Validate() error {
+	for _, f := range []struct {
+		name  string
+		value uint64
+	}{
+		{"global_rate", cfg.GlobalRate}, {"global_burst", cfg.GlobalBurst},
+		{"pid_rate", cfg.PIDRate}, {"pid_burst", cfg.PIDBurst},
+		{"sample_every", uint64(cfg.SampleEvery)},
+	} {
+		if f.value > maxRateLimit {
+			return errors.New(f.name + " must be at most " + strconv.Itoa(maxRateLimit) + ", got " + strconv.FormatUint(f.value, 10))
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether any limit or sampling is set
+func (cfg RateLimitConfig) Enabled() bool {
+	return cfg.GlobalRate != 0 || cfg.PIDRate != 0 || cfg.SampleEvery > 1
+}
+
+// SuppressedCounts are the matching calls not emitted, by reason, summed over CPUs
+type SuppressedCounts struct {
+	Sampled       uint64 `json:"sampled"`
+	PIDLimited    uint64 `json:"pid_limited"`
+	GlobalLimited uint64 `json:"global_limited"`
+}
+
+// Total is the number of suppressed calls for any reason
+func (s SuppressedCounts) Total() uint64 {
+	return s.Sampled + s.PIDLimited + s.GlobalLimited
+}
+
+// tokenBucket mirrors struct bucket_t and take_token in ebpf_probe.c for MemoryProbe
+type tokenBucket struct {
+	tokens uint64
+	lastNs uint64
+}
+
+func (b *tokenBucket) take(rate, burst, now uint64) bool {
+	capacity := burst * nsPerSec
+	if b.lastNs == 0 {
+		b.tokens = capacity
+	} else if now > b.lastNs {
+		elapsed := now - b.lastNs
+		if elapsed > maxRefillNs {
+			elapsed = maxRefillNs
+		}
+		b.tokens += elapsed * rate
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+	}
+	b.lastNs = now
+
+	if b.tokens < nsPerSec {
+		return false
+	}
+	b.tokens -= nsPerSec
+	return true
+}
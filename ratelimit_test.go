@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// takeN calls take n times at now and returns how many succeeded
+func takeN(b *tokenBucket, rate, burst, now uint64, n int) int {
+	taken := 0
+	for i := 0; i < n; i++ {
+		if b.take(rate, burst, now) {
+			taken++
+		}
+	}
+	return taken
+}
+
+func TestTokenBucketTake(t *testing.T) {
+	const start = 1000 * nsPerSec
+	for _, tc := range []struct {
+		name        string
+		rate, burst uint64
+		// after draining the bucket at start, elapsed ns pass before the takes
+		elapsed uint64
+		want    int
+	}{
+		{name: "no refill", rate: 5, burst: 10, elapsed: 0, want: 0},
+		{name: "partial token", rate: 5, burst: 10, elapsed: nsPerSec / 10, want: 0},
+		{name: "refill", rate: 5, burst: 10, elapsed: nsPerSec, want: 5},
+		{name: "refill rounds down", rate: 5, burst: 10, elapsed: nsPerSec + nsPerSec/2, want: 7},
+		{name: "burst cap", rate: 5, burst: 10, elapsed: 3 * nsPerSec, want: 10},
+		{name: "elapsed clamp", rate: 1, burst: 100, elapsed: 60 * nsPerSec, want: 10},
+		{name: "elapsed clamp without overflow", rate: maxRateLimit, burst: maxRateLimit, elapsed: math.MaxUint64 - start},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &tokenBucket{}
+			// The first take fills the bucket to its burst
+			if got := takeN(b, tc.rate, tc.burst, start, int(tc.burst)+1); uint64(got) != tc.burst {
+				t.Fatalf("first takes: %d, want the burst %d", got, tc.burst)
+			}
+			if tc.burst == maxRateLimit {
+				// Too many takes to count: check the bucket is full again instead
+				b.take(tc.rate, tc.burst, start+tc.elapsed)
+				if want := tc.burst*nsPerSec - nsPerSec; b.tokens != want {
+					t.Fatalf("tokens = %d, want %d", b.tokens, want)
+				}
+				return
+			}
+			if got := takeN(b, tc.rate, tc.burst, start+tc.elapsed, int(tc.burst)+1); got != tc.want {
+				t.Errorf("takes after %dns: %d, want %d", tc.elapsed, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketClockGoingBack(t *testing.T) {
+	b := &tokenBucket{}
+	takeN(b, 1, 1, 10*nsPerSec, 1)
+	if b.take(1, 1, 5*nsPerSec) {
+		t.Error("token taken from an empty bucket with an earlier timestamp")
+	}
+	if !b.take(1, 1, 6*nsPerSec) {
+		t.Error("bucket not refilled from the last timestamp")
+	}
+}
+
+func TestMemoryProbeSampling(t *testing.T) {
+	probe, delivered := newTestMemoryProbe(t)
+	probe.SetPrintAll(true)
+	probe.SetRateLimit(RateLimitConfig{SampleEvery: 3})
+
+	for i := 0; i < 9; i++ {
+		probe.Inject(100, evtRead)
+	}
+	if len(*delivered) != 3 {
+		t.Fatalf("%d events delivered out of 9 with 1-in-3 sampling, want 3", len(*delivered))
+	}
+	for _, event := range *delivered {
+		if event.SampleRate != 3 {
+			t.Errorf("sample_rate = %d, want 3", event.SampleRate)
+		}
+	}
+	if counts, _ := probe.GetSuppressedCounts(); counts != (SuppressedCounts{Sampled: 6}) {
+		t.Errorf("suppressed = %+v, want 6 sampled", counts)
+	}
+}
+
+func TestRateLimitValidate(t *testing.T) {
+	// The largest bucket refilled for the longest interval fits in the tokens
+	if capacity := uint64(maxRateLimit) * nsPerSec; (math.MaxUint64-capacity)/maxRefillNs < maxRateLimit {
+		t.Fatalf("maxRateLimit %d lets take overflow", maxRateLimit)
+	}
+
+	atLimit := RateLimitConfig{GlobalRate: maxRateLimit, GlobalBurst: maxRateLimit, PIDRate: maxRateLimit, PIDBurst: maxRateLimit, SampleEvery: maxRateLimit}
+	if err := atLimit.Validate(); err != nil {
+		t.Errorf("limits at maxRateLimit rejected: %v", err)
+	}
+	for _, tc := range []struct {
+		field string
+		cfg   RateLimitConfig
+	}{
+		{"global_rate", RateLimitConfig{GlobalRate: maxRateLimit + 1}},
+		{"global_burst", RateLimitConfig{GlobalRate: 1, GlobalBurst: maxRateLimit + 1}},
+		{"pid_rate", RateLimitConfig{PIDRate: maxRateLimit + 1}},
+		{"pid_burst", RateLimitConfig{PIDRate: 1, PIDBurst: maxRateLimit + 1}},
+		{"sample_every", RateLimitConfig{SampleEvery: maxRateLimit + 1}},
+	} {
+		if err := tc.cfg.Validate(); err == nil {
+			t.Errorf("%s above maxRateLimit accepted", tc.field)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ReplayProbe replays a capture file instead of reading the kernel, so everything
+// above the probe runs without privileges. Samples go through deliverSample like in
+// EBpfProbe.Start; recorded target and mode changes go to the command handler, so the
+// controller and the API see the state of the recording. The maps are emulated by the
+// embedded MemoryProbe, which does not filter replayed events again; replayed forks and
+// exits update its target map like the tracepoints did when they were recorded.
+type ReplayProbe struct {
+	*MemoryProbe
+	path   string
+	speed  float64
+	reader *CaptureReader
+	logger Logger
+
+	handler   EventHandler
+	onCommand func(MonitorCommand)
+	started   bool
+	stopCh    chan struct{}
+	done      chan struct{}
+
+	lostSamples  atomic.Uint64
+	decodeErrors atomic.Uint64
+	delivered    atomic.Uint64
+
+	// Kernel timestamps are shifted to the replay's clock, see rebase
+	firstTs   uint64
+	monoStart uint64
+}
+
+// NewReplayProbe opens the capture at path. speed 1 keeps the recorded timing,
+// higher is faster and 0 replays as fast as possible.
+func NewReplayProbe(logger Logger, path string, speed float64) (*ReplayProbe, error) {
+	reader, err := OpenCapture(path)
+	if err != nil {
+		logger.Errorf("failed to open capture: %v", err)
+		return nil, errors.New("failed to open capture: " + err.Error())
+	}
+	if reader.Header.DataSize != dataSize {
+		logger.Warnf("Capture %s was recorded with %d-byte samples, this build decodes %d", path, reader.Header.DataSize, dataSize)
+	}
+	logger.Infof("Replaying %s, recorded on %s at %s over %s, speed %g", path, reader.Header.Hostname, reader.Header.Created.Format(time.RFC3339), reader.Header.Transport, speed)
+
+	p := &ReplayProbe{
+		MemoryProbe: NewMemoryProbe(logger),
+		path:        path,
+		speed:       speed,
+		reader:      reader,
+		logger:      logger,
+		onCommand:   func(MonitorCommand) {},
+		stopCh:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	p.handler = func(event Data) { logEvent(logger, event) }
+	return p, nil
+}
+
+// SetEventHandler replaces the default log handler. Must be called before Start.
+func (p *ReplayProbe) SetEventHandler(handler EventHandler) {
+	p.handler = handler
+}
+
+// SetCommandHandler receives the recorded target and mode changes. Must be called before Start.
+func (p *ReplayProbe) SetCommandHandler(handler func(MonitorCommand)) {
+	p.onCommand = handler
+}
+
+// Start begins the replay; the probe stays up once the capture is exhausted
+func (p *ReplayProbe) Start() {
+	p.started = true
+	go p.run()
+}
+
+// Stop ends the replay and closes the capture
+func (p *ReplayProbe) Stop() {
+	select {
+	case <-p.stopCh:
+		return
+	default:
+		close(p.stopCh)
+	}
+	if p.started {
+		<-p.done
+	} else {
+		p.reader.Close()
+	}
+	p.MemoryProbe.Stop()
+}
+
+// Info reports the replay transport and the emulated attachments
+func (p *ReplayProbe) Info() ProbeInfo {
+	info := p.MemoryProbe.Info()
+	info.Transport = "replay"
+	return info
+}
+
+// ReaderStats counts the replayed samples like the eBPF reader counts live ones
+func (p *ReplayProbe) ReaderStats() (ReaderStats, error) {
+	return ReaderStats{
+		LostSamples:  p.lostSamples.Load(),
+		DecodeErrors: p.decodeErrors.Load(),
+		Delivered:    p.delivered.Load(),
+	}, nil
+}
+
+func (p *ReplayProbe) run() {
+	defer close(p.done)
+	defer p.reader.Close()
+
+	handler := func(event Data) {
+		event.TimestampNs = p.rebase(event.TimestampNs)
+		if isProcessEvent(event.EventType) {
+			p.applyProcessEvent(event)
+		}
+		p.handler(event)
+	}
+	var first time.Time
+	started := time.Now()
+	records := 0
+	for {
+		record, err := p.reader.Next()
+		if err == io.EOF {
+			p.logger.Infof("Replay of %s finished: %d records", p.path, records)
+			return
+		}
+		if err != nil {
+			p.logger.Warnf("Replay of %s stopped after %d records: %v", p.path, records, err)
+			return
+		}
+		records++
+
+		// Wait until the record is due at the replay speed
+		if first.IsZero() {
+			first = record.Time
+		}
+		if p.speed > 0 {
+			due := started.Add(time.Duration(float64(record.Time.Sub(first)) / p.speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-p.stopCh:
+					return
+				}
+			}
+		}
+		select {
+		case <-p.stopCh:
+			return
+		default:
+		}
+
+		switch record.Kind {
+		case captureSample:
+			deliverSample(record.Payload, handler, &p.decodeErrors, &p.delivered, p.logger)
+		case captureLost:
+			if len(record.Payload) == 8 {
+				p.lostSamples.Add(binary.LittleEndian.Uint64(record.Payload))
+			}
+		case captureState:
+			var state PersistedState
+			if err := json.Unmarshal(record.Payload, &state); err != nil {
+				p.logger.Warnf("Skipping malformed state record: %v", err)
+				continue
+			}
+			p.restoreInherited(state.Targets)
+			for _, cmd := range captureStateCommands(state) {
+				p.onCommand(cmd)
+			}
+		case captureCommand:
+			var cc CaptureCommand
+			if err := json.Unmarshal(record.Payload, &cc); err != nil {
+				p.logger.Warnf("Skipping malformed command record: %v", err)
+				continue
+			}
+			cmd, ok := cc.command()
+			if !ok {
+				p.logger.Warnf("Skipping unknown recorded command %q", cc.Kind)
+				continue
+			}
+			p.onCommand(cmd)
+		default:
+			p.logger.Debugf("Skipping capture record of unknown kind %d", record.Kind)
+		}
+	}
+}
+
+// applyProcessEvent makes the target map change of a recorded process_fork or
+// process_exit event, which the kernel made before emitting it
+func (p *ReplayProbe) applyProcessEvent(event Data) {
+	mp := p.MemoryProbe
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	switch event.EventType {
+	case evtProcessFork:
+		mp.inherit(event.Pid, event.ChildPid)
+	case evtProcessExit:
+		delete(mp.targetPIDs, event.Pid)
+	}
+}
+
+// restoreInherited puts the inherited targets of a recorded state back in the
+// target map, whether or not their parent is still a target
+func (p *ReplayProbe) restoreInherited(targets []PersistedPID) {
+	mp := p.MemoryProbe
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, t := range targets {
+		if !t.Inherited {
+			continue
+		}
+		if _, exists := mp.targetPIDs[t.PID]; !exists && len(mp.targetPIDs) >= memoryTargetPIDsCapacity {
+			p.logger.Warnf("Target map full, skipping recorded inherited PID %d", t.PID)
+			continue
+		}
+		mp.targetPIDs[t.PID] = targetValue{Flags: targetFollow | targetInherited, Parent: t.ParentPID}
+	}
+}
+
+// rebase moves a recorded kernel timestamp onto this host's monotonic clock, keeping
+// the recorded spacing divided by the speed, so wall-clock times derived from it are
+// those of the replay. As fast as possible, events are stamped when delivered.
+func (p *ReplayProbe) rebase(ts uint64) uint64 {
+	if ts == 0 {
+		return 0
+	}
+	if p.firstTs == 0 {
+		p.firstTs = ts
+		p.monoStart = monotonicNowNs()
+	}
+	if p.speed <= 0 || ts < p.firstTs {
+		return monotonicNowNs()
+	}
+	return p.monoStart + uint64(float64(ts-p.firstTs)/p.speed)
+}
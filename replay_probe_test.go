@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// encodeData is the reverse of decodeData: the raw struct data_t sample of event
+func encodeData(event Data) []byte {
+	le := binary.LittleEndian
+	sample := make([]byte, dataSize)
+	for i, v := range []uint32{event.Pid, event.EventType, event.Tid, event.Uid, event.Gid, event.CPU} {
+		le.PutUint32(sample[4*i:], v)
+	}
+	le.PutUint64(sample[24:32], event.CgroupID)
+	le.PutUint64(sample[32:40], event.TimestampNs)
+	arg0, arg2 := uint64(event.FD), event.Count
+	if event.EventType == evtProcessFork {
+		arg0 = uint64(event.ChildPid)
+	}
+	le.PutUint64(sample[40:48], arg0)
+	le.PutUint64(sample[48:56], arg2)
+	copy(sample[56:72], event.Comm)
+	le.PutUint32(sample[72:76], matchPID)
+	le.PutUint32(sample[76:80], event.SampleRate)
+	return sample
+}
+
+// targetState is GET /target_pids without the exit times, which are those of the run
+func targetState(t *testing.T, app *Application) string {
+	t.Helper()
+	code, response := doRequest(t, app, http.MethodGet, "/target_pids", "")
+	if code != http.StatusOK {
+		t.Fatalf("GET /target_pids: status %d", code)
+	}
+	for _, exited := range response["exited"].([]interface{}) {
+		delete(exited.(map[string]interface{}), "exited_at")
+	}
+	state, _ := json.Marshal(map[string]interface{}{
+		"pids":      response["pids"],
+		"targets":   response["targets"],
+		"exited":    response["exited"],
+		"print_all": response["print_all"],
+	})
+	return string(state)
+}
+
+func TestRecordAndReplayTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.bin")
+	app, probe := newTestApplication(t, testConfig())
+	// 703 is a thread on the recording host
+	app.ebpfController.SetThreadCheck(func(id uint32) (bool, error) { return id == 703, nil })
+
+	// An inherited target before the recording starts is part of its state
+	if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", `{"pids": [700], "follow_children": true}`); code != http.StatusOK {
+		t.Fatalf("POST /add_pids: status %d", code)
+	}
+	probe.InjectFork(700, 701)
+	waitFor(t, "701 to be inherited", func() bool { return len(app.pidManager.GetTargets()) == 2 })
+
+	recorder, err := CreateCapture(path, CaptureHeader{Created: time.Now(), Transport: "memory", DataSize: dataSize}, app.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := app.ebpfController.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.WriteState(state)
+	app.ebpfController.SetRecorder(recorder)
+	// Like the eBPF reader, record the raw sample before it is decoded and handled
+	handler := probe.handler
+	probe.SetEventHandler(func(event Data) {
+		recorder.WriteSample(encodeData(event))
+		handler(event)
+	})
+
+	if code, _ := doRequest(t, app, http.MethodPost, "/add_pids", `{"pids": [800, 801]}`); code != http.StatusOK {
+		t.Fatalf("POST /add_pids: status %d", code)
+	}
+	probe.Inject(700, evtRead)
+	probe.InjectFork(700, 702)
+	probe.InjectFork(701, 703)
+	probe.InjectProcessExit(701, "worker")
+	probe.InjectFork(702, 704)
+	probe.InjectProcessExit(704, "worker")
+	// Answered once applied and recorded, after the commands the process events queued before it
+	if code, _ := doRequest(t, app, http.MethodDelete, "/target_pids/801", ""); code != http.StatusOK {
+		t.Fatalf("DELETE /target_pids/801: status %d", code)
+	}
+
+	want := `{"exited":[` +
+		`{"comm":"worker","follow_children":true,"inherited":true,"parent_pid":702,"pid":704},` +
+		`{"comm":"worker","follow_children":true,"inherited":true,"parent_pid":700,"pid":701}],` +
+		`"pids":[700,702,800],"print_all":false,"targets":[` +
+		`{"follow_children":true,"inherited":false,"pid":700},` +
+		`{"follow_children":true,"inherited":true,"parent_pid":700,"pid":702},` +
+		`{"follow_children":false,"inherited":false,"pid":800}]}`
+	if got := targetState(t, app); got != want {
+		t.Fatalf("recorded targets:\n%s\nwant:\n%s", got, want)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replay runs on this host, where none of these PIDs is a thread
+	cfg := testConfig()
+	cfg.Capture.Replay = path
+	replayed, err := NewApplication(cfg, app.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(replayed.Stop)
+	replay := replayed.ebpfProbe.(*ReplayProbe)
+	replay.Start()
+	<-replay.done
+	waitFor(t, "the replayed targets", func() bool { return targetState(t, replayed) == want })
+	if stats, _ := replay.ReaderStats(); stats.Delivered != 6 || stats.DecodeErrors != 0 {
+		t.Errorf("replay reader stats %+v, want 6 delivered", stats)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// EventSink consumes decoded events. Write is only called from the sink's own
+// pipeline goroutine, so implementations need no locking for it.
+type EventSink interface {
+	Name() string
+	Write(event Data) error
+	Close() error
+}
+
+// Sink kinds accepted in SinkConfig
+const (
+	SinkLogger = "logger"
+	SinkJSONL  = "jsonl"
+	SinkRing   = "ring"
+	SinkStdout = "stdout"
+)
+
+const (
+	defaultSinkQueueSize = 4096
+	defaultRingCapacity  = 10000
+	sinkErrorLogInterval = 10 * time.Second
+)
+
+// SinkConfig describes one sink of the pipeline
+type SinkConfig struct {
+	Kind string `json:"kind" yaml:"kind"`
+	// Path of the JSON-lines file (jsonl), rotated like the operational log
+	Path       string `json:"path,omitempty" yaml:"path"`
+	MaxSizeMB  int    `json:"max_size_mb,omitempty" yaml:"max_size_mb"`
+	MaxBackups int    `json:"max_backups,omitempty" yaml:"max_backups"`
+	MaxAgeDays int    `json:"max_age_days,omitempty" yaml:"max_age_days"`
+	// Capacity of the in-memory ring (ring)
+	Capacity int `json:"capacity,omitempty" yaml:"capacity"`
+	// QueueSize is the number of events buffered before this sink starts dropping
+	QueueSize int `json:"queue_size,omitempty" yaml:"queue_size"`
+}
+
+// NewEventSink builds a built-in sink from its configuration
+func NewEventSink(cfg SinkConfig, logger Logger) (EventSink, error) {
+	switch cfg.Kind {
+	case SinkLogger:
+		return &loggerSink{logger: logger}, nil
+	case SinkJSONL:
+		if cfg.Path == "" {
+			return nil, errors.New("jsonl sink requires a path")
+		}
+		return newJSONLinesSink(SinkJSONL, &lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+		}), nil
+	case SinkRing:
+		capacity := cfg.Capacity
+		if capacity <= 0 {
+			capacity = defaultRingCapacity
+		}
+		return NewRingSink(capacity), nil
+	case SinkStdout:
+		return newJSONLinesSink(SinkStdout, nopCloser{os.Stdout}), nil
+	default:
+		return nil, errors.New("unknown sink kind: " + cfg.Kind)
+	}
+}
+
+// loggerSink writes one operational log line per event (the original behaviour)
+type loggerSink struct {
+	logger Logger
+}
+
+func (s *loggerSink) Name() string { return SinkLogger }
+
+func (s *loggerSink) Write(event Data) error {
+	logEvent(s.logger, event)
+	return nil
+}
+
+func (s *loggerSink) Close() error { return nil }
+
+// jsonLinesSink writes one JSON object per line
+type jsonLinesSink struct {
+	name string
+	w    io.WriteCloser
+	enc  *json.Encoder
+}
+
+func newJSONLinesSink(name string, w io.WriteCloser) *jsonLinesSink {
+	return &jsonLinesSink{name: name, w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *jsonLinesSink) Name() string { return s.name }
+
+func (s *jsonLinesSink) Write(event Data) error {
+	return s.enc.Encode(event)
+}
+
+func (s *jsonLinesSink) Close() error {
+	return s.w.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// RingSink keeps the most recent events in memory
+type RingSink struct {
+	mu     sync.RWMutex
+	events []Data
+	next   int
+	full   bool
+}
+
+// NewRingSink creates a ring holding up to capacity events
+func NewRingSink(capacity int) *RingSink {
+	return &RingSink{events: make([]Data, capacity)}
+}
+
+func (s *RingSink) Name() string { return SinkRing }
+
+func (s *RingSink) Write(event Data) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[s.next] = event
+	s.next = (s.next + 1) % len(s.events)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+func (s *RingSink) Close() error { return nil }
+
+// Recent returns up to limit of the newest events, oldest first; limit <= 0 means all
+func (s *RingSink) Recent(limit int) []Data {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ordered []Data
+	if s.full {
+		ordered = append(append(ordered, s.events[s.next:]...), s.events[:s.next]...)
+	} else {
+		ordered = append(ordered, s.events[:s.next]...)
+	}
+	if limit > 0 && len(ordered) > limit {
+		ordered = ordered[len(ordered)-limit:]
+	}
+	return ordered
+}
+
+// SinkStats reports the health of one sink
+type SinkStats struct {
+	Name    string `json:"name"`
+	Queued  int    `json:"queued"`
+	Written uint64 `json:"written"`
+	Dropped uint64 `json:"dropped"`
+	Errors  uint64 `json:"errors"`
+}
+
+// sinkWorker owns one sink and its bounded queue
+type sinkWorker struct {
+	sink   EventSink
+	queue  chan Data
+	done   chan struct{}
+	logger Logger
+
+	mu           sync.Mutex
+	written      uint64
+	dropped      uint64
+	errors       uint64
+	lastErrorLog time.Time
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	for event := range w.queue {
+		err := w.sink.Write(event)
+
+		w.mu.Lock()
+		if err != nil {
+			w.errors++
+			// Rate-limited so a broken sink does not flood the operational log
+			if time.Since(w.lastErrorLog) >= sinkErrorLogInterval {
+				w.lastErrorLog = time.Now()
+				w.logger.Errorf("Sink %s failed to write event (%d errors so far): %v", w.sink.Name(), w.errors, err)
+			}
+		} else {
+			w.written++
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *sinkWorker) stats() SinkStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return SinkStats{
+		Name:    w.sink.Name(),
+		Queued:  len(w.queue),
+		Written: w.written,
+		Dropped: w.dropped,
+		Errors:  w.errors,
+	}
+}
+
+// EventPipeline fans events out to sinks. Every sink has its own goroutine and
+// bounded queue: a slow or failing sink drops its own events and never blocks
+// the event reader or the other sinks.
+type EventPipeline struct {
+	logger  Logger
+	workers []*sinkWorker
+	closed  bool
+	mu      sync.RWMutex
+}
+
+// NewEventPipeline creates an empty pipeline
+func NewEventPipeline(logger Logger) *EventPipeline {
+	return &EventPipeline{logger: logger}
+}
+
+// AddSink starts feeding sink through a queue of queueSize events (0 = default)
+func (p *EventPipeline) AddSink(sink EventSink, queueSize int) {
+	if queueSize <= 0 {
+		queueSize = defaultSinkQueueSize
+	}
+	w := &sinkWorker{
+		sink:   sink,
+		queue:  make(chan Data, queueSize),
+		done:   make(chan struct{}),
+		logger: p.logger,
+	}
+	go w.run()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.workers = append(p.workers, w)
+}
+
+// Publish enqueues event to every sink without blocking
+func (p *EventPipeline) Publish(event Data) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+	for _, w := range p.workers {
+		select {
+		case w.queue <- event:
+		default:
+			w.mu.Lock()
+			w.dropped++
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Stats returns per-sink counters in configuration order
+func (p *EventPipeline) Stats() []SinkStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	stats := make([]SinkStats, 0, len(p.workers))
+	for _, w := range p.workers {
+		stats = append(stats, w.stats())
+	}
+	return stats
+}
+
+// Close drains the queues and closes every sink
+func (p *EventPipeline) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	workers := p.workers
+	p.mu.Unlock()
+
+	for _, w := range workers {
+		close(w.queue)
+	}
+	for _, w := range workers {
+		<-w.done
+		if err := w.sink.Close(); err != nil {
+			p.logger.Warnf("Failed to close sink %s: %v", w.sink.Name(), err)
+		}
+	}
+}
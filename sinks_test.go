@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSink holds its first Write until release is closed
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (s *blockingSink) Name() string { return "blocking" }
+
+func (s *blockingSink) Write(Data) error {
+	s.once.Do(func() { close(s.started) })
+	<-s.release
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+// failingSink fails every other Write
+type failingSink struct {
+	writes int
+}
+
+func (s *failingSink) Name() string { return "failing" }
+
+func (s *failingSink) Write(Data) error {
+	s.writes++
+	if s.writes%2 == 0 {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func (s *failingSink) Close() error { return nil }
+
+func TestEventPipelineSlowSink(t *testing.T) {
+	logger, err := NewLogger(LogConfig{Kind: LoggerStdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewEventPipeline(logger)
+	slow := &blockingSink{started: make(chan struct{}), release: make(chan struct{})}
+	p.AddSink(slow, 2)
+	p.AddSink(&failingSink{}, 0)
+
+	// The slow sink's worker holds the first event, its queue takes 2 more and drops the rest
+	p.Publish(Data{Pid: 1})
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow sink never got its first event")
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 9; i++ {
+			p.Publish(Data{Pid: 1})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a slow sink")
+	}
+
+	close(slow.release)
+	p.Close()
+	stats := p.Stats()
+	if stats[0] != (SinkStats{Name: "blocking", Written: 3, Dropped: 7}) {
+		t.Errorf("slow sink stats = %+v, want 3 written and 7 dropped", stats[0])
+	}
+	if stats[1] != (SinkStats{Name: "failing", Written: 5, Errors: 5}) {
+		t.Errorf("failing sink stats = %+v, want 5 written, 5 errors and none dropped", stats[1])
+	}
+
+	// Events published after Close are ignored
+	p.Publish(Data{Pid: 1})
+	if stats := p.Stats(); stats[1].Written+stats[1].Errors != 10 {
+		t.Errorf("event published after Close reached a sink: %+v", stats[1])
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SyscallCount is one entry of the syscall_counts map, summed over CPUs
+type SyscallCount struct {
+	Pid       uint32
+	EventType uint32
+	Count     uint64
+}
+
+// SyscallStat is a counter and its rate over the last sampling window
+type SyscallStat struct {
+	Count      uint64  `json:"count"`
+	RatePerSec float64 `json:"rate_per_sec"`
+}
+
+// PIDStats aggregates the counters of one PID, keyed by syscall name
+type PIDStats struct {
+	PID      uint32                 `json:"pid"`
+	Syscalls map[string]SyscallStat `json:"syscalls"`
+	Total    SyscallStat            `json:"total"`
+}
+
+// statsMinWindow is the minimum time between two snapshots used to compute rates,
+// so that frequent /stats polling does not produce noisy rates
+const statsMinWindow = time.Second
+
+type statsKey struct {
+	pid       uint32
+	eventType uint32
+}
+
+// StatsTracker turns raw counter snapshots into per-PID counts and rates
+type StatsTracker struct {
+	mu     sync.Mutex
+	prev   map[statsKey]uint64
+	prevAt time.Time
+	rates  map[statsKey]float64
+}
+
+// NewStatsTracker creates an empty stats tracker
+func NewStatsTracker() *StatsTracker {
+	return &StatsTracker{
+		prev:  make(map[statsKey]uint64),
+		rates: make(map[statsKey]float64),
+	}
+}
+
+// Update folds a new snapshot in and returns per-PID stats sorted by PID.
+// Rates are recomputed once the previous snapshot is at least statsMinWindow old.
+func (st *StatsTracker) Update(counts []SyscallCount, now time.Time) []PIDStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cur := make(map[statsKey]uint64, len(counts))
+	for _, c := range counts {
+		cur[statsKey{pid: c.Pid, eventType: c.EventType}] += c.Count
+	}
+
+	if st.prevAt.IsZero() {
+		st.prev, st.prevAt = cur, now
+	} else if elapsed := now.Sub(st.prevAt); elapsed >= statsMinWindow {
+		rates := make(map[statsKey]float64, len(cur))
+		for k, v := range cur {
+			delta := v
+			if old, ok := st.prev[k]; ok && old <= v {
+				delta = v - old
+			}
+			rates[k] = float64(delta) / elapsed.Seconds()
+		}
+		st.rates, st.prev, st.prevAt = rates, cur, now
+	}
+
+	byPID := make(map[uint32]*PIDStats)
+	for k, v := range cur {
+		ps, ok := byPID[k.pid]
+		if !ok {
+			ps = &PIDStats{PID: k.pid, Syscalls: make(map[string]SyscallStat)}
+			byPID[k.pid] = ps
+		}
+		stat := SyscallStat{Count: v, RatePerSec: st.rates[k]}
+		ps.Syscalls[eventTypeName(k.eventType)] = stat
+		ps.Total.Count += stat.Count
+		ps.Total.RatePerSec += stat.RatePerSec
+	}
+
+	result := make([]PIDStats, 0, len(byPID))
+	for _, ps := range byPID {
+		result = append(result, *ps)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].PID < result[j].PID })
+	return result
+}
+
+// SyscallExitCount is one entry of the syscall_exits map, combined over CPUs
+type SyscallExitCount struct {
+	Pid       uint32
+	EventType uint32
+	Calls     uint64
+	Errors    uint64
+	TotalNs   uint64
+	MaxNs     uint64
+	Bytes     uint64
+}
+
+// SyscallErrnoCount is one entry of the syscall_errors map, summed over CPUs
+type SyscallErrnoCount struct {
+	Pid       uint32
+	EventType uint32
+	Errno     uint32
+	Count     uint64
+}
+
+// SyscallExitStat summarizes completed calls of one syscall.
+// Bytes is only reported for syscalls whose return value is a byte count.
+type SyscallExitStat struct {
+	Calls        uint64            `json:"calls"`
+	Errors       uint64            `json:"errors"`
+	AvgLatencyUs float64           `json:"avg_latency_us"`
+	MaxLatencyUs float64           `json:"max_latency_us"`
+	Bytes        uint64            `json:"bytes,omitempty"`
+	Errnos       map[string]uint64 `json:"errnos,omitempty"`
+}
+
+// PIDExitStats aggregates completed syscalls of one PID, keyed by syscall name
+type PIDExitStats struct {
+	PID      uint32                     `json:"pid"`
+	Syscalls map[string]SyscallExitStat `json:"syscalls"`
+}
+
+// buildExitStats groups exit aggregates and errno counts by PID, sorted by PID
+func buildExitStats(exits []SyscallExitCount, errnos []SyscallErrnoCount) []PIDExitStats {
+	byPID := make(map[uint32]*PIDExitStats)
+	get := func(pid uint32) *PIDExitStats {
+		ps, ok := byPID[pid]
+		if !ok {
+			ps = &PIDExitStats{PID: pid, Syscalls: make(map[string]SyscallExitStat)}
+			byPID[pid] = ps
+		}
+		return ps
+	}
+
+	for _, e := range exits {
+		stat := SyscallExitStat{
+			Calls:        e.Calls,
+			Errors:       e.Errors,
+			MaxLatencyUs: float64(e.MaxNs) / 1e3,
+		}
+		if e.Calls > 0 {
+			stat.AvgLatencyUs = float64(e.TotalNs) / float64(e.Calls) / 1e3
+		}
+		if sc, ok := lookupEventType(e.EventType); ok && sc.countArg {
+			stat.Bytes = e.Bytes
+		}
+		get(e.Pid).Syscalls[eventTypeName(e.EventType)] = stat
+	}
+
+	for _, e := range errnos {
+		ps := get(e.Pid)
+		name := eventTypeName(e.EventType)
+		stat := ps.Syscalls[name]
+		if stat.Errnos == nil {
+			stat.Errnos = make(map[string]uint64)
+		}
+		stat.Errnos[errnoName(e.Errno)] += e.Count
+		ps.Syscalls[name] = stat
+	}
+
+	result := make([]PIDExitStats, 0, len(byPID))
+	for _, ps := range byPID {
+		result = append(result, *ps)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].PID < result[j].PID })
+	return result
+}
+
+// errnoName returns the symbolic name of an errno (EAGAIN, EBADF, ...)
+func errnoName(errno uint32) string {
+	if name := unix.ErrnoName(syscall.Errno(errno)); name != "" {
+		return name
+	}
+	return "errno_" + strconv.FormatUint(uint64(errno), 10)
+}
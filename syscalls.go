@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// syscallProbe describes one traceable syscall and the programs able to hook its entry and exit.
+// eventType is the stable ID carried in events and counters: never renumber an
+// entry, only append new ones. fdArg/countArg tell whether the first and third
+// arguments are a file descriptor and a requested byte count.
+type syscallProbe struct {
+	name       string
+	eventType  uint32
+	nr         uint32
+	fdArg      bool
+	countArg   bool
+	fentryProg    string
+	fexitProg     string
+	kprobeProg    string
+	kretprobeProg string
+}
+
+// syscallRegistry lists every syscall that can be enabled.
+// read and write keep dedicated fentry/fexit and kprobe/kretprobe programs (EVT_READ/EVT_WRITE in ebpf_probe.c);
+// the others are hooked through the generic tracepoint programs only.
+var syscallRegistry = []syscallProbe{
+	{name: "read", eventType: evtRead, nr: unix.SYS_READ, fdArg: true, countArg: true, fentryProg: "fentry_sys_read", fexitProg: "fexit_sys_read", kprobeProg: "sys_read_call", kretprobeProg: "sys_read_ret"},
+	{name: "write", eventType: evtWrite, nr: unix.SYS_WRITE, fdArg: true, countArg: true, fentryProg: "fentry_sys_write", fexitProg: "fexit_sys_write", kprobeProg: "sys_write_call", kretprobeProg: "sys_write_ret"},
+	{name: "openat", eventType: 3, nr: unix.SYS_OPENAT},
+	{name: "close", eventType: 4, nr: unix.SYS_CLOSE, fdArg: true},
+	{name: "connect", eventType: 5, nr: unix.SYS_CONNECT, fdArg: true},
+	{name: "accept", eventType: 6, nr: unix.SYS_ACCEPT, fdArg: true},
+	{name: "accept4", eventType: 7, nr: unix.SYS_ACCEPT4, fdArg: true},
+	{name: "sendto", eventType: 8, nr: unix.SYS_SENDTO, fdArg: true, countArg: true},
+	{name: "recvfrom", eventType: 9, nr: unix.SYS_RECVFROM, fdArg: true, countArg: true},
+	{name: "sendmsg", eventType: 10, nr: unix.SYS_SENDMSG, fdArg: true},
+	{name: "recvmsg", eventType: 11, nr: unix.SYS_RECVMSG, fdArg: true},
+	{name: "fsync", eventType: 12, nr: unix.SYS_FSYNC, fdArg: true},
+	{name: "fdatasync", eventType: 13, nr: unix.SYS_FDATASYNC, fdArg: true},
+	{name: "execve", eventType: 14, nr: unix.SYS_EXECVE},
+	{name: "pread64", eventType: 15, nr: unix.SYS_PREAD64, fdArg: true, countArg: true},
+	{name: "pwrite64", eventType: 16, nr: unix.SYS_PWRITE64, fdArg: true, countArg: true},
+	{name: "readv", eventType: 17, nr: unix.SYS_READV, fdArg: true},
+	{name: "writev", eventType: 18, nr: unix.SYS_WRITEV, fdArg: true},
+	{name: "socket", eventType: 19, nr: unix.SYS_SOCKET},
+	{name: "bind", eventType: 20, nr: unix.SYS_BIND, fdArg: true},
+	{name: "listen", eventType: 21, nr: unix.SYS_LISTEN, fdArg: true},
+	{name: "unlinkat", eventType: 22, nr: unix.SYS_UNLINKAT},
+}
+
+// defaultSyscalls are traced when ProbeOptions.Syscalls is empty
+var defaultSyscalls = []string{"read", "write"}
+
+// SyscallInfo is a registry entry as reported by the API
+type SyscallInfo struct {
+	Name      string      `json:"name"`
+	EventType uint32      `json:"event_type"`
+	Enabled   bool        `json:"enabled"`
+	Attach    *AttachInfo `json:"attach,omitempty"`
+}
+
+// syscallArgs interprets the raw first and third arguments of an event
+func syscallArgs(eventType uint32, arg0, arg2 uint64) (fd int64, count uint64) {
+	sc, _ := lookupEventType(eventType)
+	fd = -1
+	if sc.fdArg {
+		fd = int64(int32(arg0))
+	}
+	if sc.countArg {
+		count = arg2
+	}
+	return fd, count
+}
+
+// lookupSyscall finds a registry entry by name
+func lookupSyscall(name string) (syscallProbe, bool) {
+	for _, sc := range syscallRegistry {
+		if sc.name == name {
+			return sc, true
+		}
+	}
+	return syscallProbe{}, false
+}
+
+// lookupEventType finds a registry entry by event type ID
+func lookupEventType(eventType uint32) (syscallProbe, bool) {
+	for _, sc := range syscallRegistry {
+		if sc.eventType == eventType {
+			return sc, true
+		}
+	}
+	return syscallProbe{}, false
+}
+
+// eventTypeName maps event type IDs to the syscall names used in logs, stats and the API
+func eventTypeName(eventType uint32) string {
+	if sc, ok := lookupEventType(eventType); ok {
+		return sc.name
+	}
+	if name, ok := processEventName(eventType); ok {
+		return name
+	}
+	return "unknown_" + strconv.FormatUint(uint64(eventType), 10)
+}
+
+// listSyscalls merges the registry with the current attachments
+func listSyscalls(attachments []AttachInfo) []SyscallInfo {
+	attached := make(map[string]AttachInfo, len(attachments))
+	for _, info := range attachments {
+		attached[info.Syscall] = info
+	}
+
+	list := make([]SyscallInfo, 0, len(syscallRegistry))
+	for _, sc := range syscallRegistry {
+		entry := SyscallInfo{Name: sc.name, EventType: sc.eventType}
+		if info, ok := attached[sc.name]; ok {
+			entry.Enabled = true
+			entry.Attach = &info
+		}
+		list = append(list, entry)
+	}
+	return list
+}